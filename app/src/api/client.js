@@ -13,14 +13,16 @@ async function getAccessTokenStrict() {
 
 export async function api(path, { method = "GET", body, headers = {} } = {}) {
   const accessToken = await getAccessTokenStrict();
+  // FormData（檔案上傳）交給瀏覽器設定 multipart boundary
+  const isForm = body instanceof FormData;
   const res = await fetch(`${API_BASE}${path}`, {
     method,
     headers: {
-      "Content-Type": "application/json",
+      ...(isForm ? {} : { "Content-Type": "application/json" }),
       Authorization: `Bearer ${accessToken}`,
       ...headers,
     },
-    body: body ? JSON.stringify(body) : undefined,
+    body: isForm ? body : body ? JSON.stringify(body) : undefined,
   });
   if (!res.ok)
     throw new Error(await res.text().catch(() => `HTTP ${res.status}`));
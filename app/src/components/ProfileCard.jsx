@@ -8,6 +8,7 @@ function ProfileCard() {
   const [loading, setLoading] = useState(true)
   const [editing, setEditing] = useState(false)
   const [saving, setSaving] = useState(false)
+  const [uploading, setUploading] = useState(false)
   const { user, loading: authLoading } = useRequireAuth()
 
 
@@ -41,7 +42,6 @@ function ProfileCard() {
           name: draft?.name ?? '',
           phone: draft?.phone ?? '',
           city: draft?.city ?? '',
-          avatar_url: draft?.avatar_url ?? '',
           bio: draft?.bio ?? '',
         },
       })
@@ -55,6 +55,22 @@ function ProfileCard() {
     }
   }
 
+  async function uploadAvatar(file) {
+    if (!file) return
+    setUploading(true)
+    try {
+      const form = new FormData()
+      form.append('avatar', file)
+      const res = await api('/profile/avatar', { method: 'POST', body: form })
+      setProfile(p => ({ ...p, avatar_url: res.avatar_url }))
+      setDraft(d => ({ ...d, avatar_url: res.avatar_url }))
+    } catch (e) {
+      alert(e.message || 'Failed to upload avatar')
+    } finally {
+      setUploading(false)
+    }
+  }
+
   if (loading) {
     return <div className="border border-white/20 rounded-lg p-3 bg-white/5">Loading profile…</div>
   }
@@ -85,7 +101,6 @@ function ProfileCard() {
               <div className="mt-2 grid gap-2 sm:grid-cols-3">
                 <div className="text-sm text-white/80"><span className="opacity-70">Phone:</span> {profile?.phone || '—'}</div>
                 <div className="text-sm text-white/80"><span className="opacity-70">City:</span> {profile?.city || '—'}</div>
-                <div className="text-sm text-white/80"><span className="opacity-70">Avatar URL:</span> {profile?.avatar_url || '—'}</div>
               </div>
               <div className="mt-2 text-sm text-white/80 whitespace-pre-wrap">{profile?.bio || '—'}</div>
             </>
@@ -120,11 +135,13 @@ function ProfileCard() {
                     />
                   </label>
                   <label className="text-sm grid gap-1">
-                    <span className="text-white/80">Avatar URL</span>
+                    <span className="text-white/80">{uploading ? 'Uploading…' : 'Avatar'}</span>
                     <input
-                      className="bg-transparent outline-none border border-white/20 focus:border-white/40 rounded px-2 py-1"
-                      value={draft?.avatar_url || ''}
-                      onChange={(e) => setDraft(d => ({ ...d, avatar_url: e.target.value }))}
+                      type="file"
+                      accept="image/jpeg,image/png,image/gif"
+                      disabled={uploading}
+                      className="text-xs file:mr-2 file:rounded file:border file:border-white/20 file:bg-transparent file:px-2 file:py-1 file:text-white"
+                      onChange={(e) => uploadAvatar(e.target.files?.[0])}
                     />
                   </label>
                 </div>
@@ -1,6 +1,7 @@
 import { useMemo, useCallback, useEffect, useState } from 'react'
 import Talk from 'talkjs'
 import { Session, Chatbox } from '@talkjs/react'
+import { api } from '../api/client'
 
 function deriveName(email) {
   if (!email) return 'User'
@@ -12,8 +13,8 @@ function deriveName(email) {
  * TaskChatBox
  * Renders TalkJS chat only after the task is assigned and TalkJS is ready.
  * props:
- *  - task: { id, title, requester, assigned_to }
- *  - me:   { email, name }
+ *  - task: { id, title, requester, assigned_to }   (requester / assigned_to are user UUIDs)
+ *  - me:   { id, email, name }
  *  - height?: number (default 320)
  *  - className?: string
  */
@@ -31,20 +32,29 @@ export default function TaskChatBox({ task, me, height = 320, className = '' })
 
   // 2) Current user for TalkJS (only once ready)
   const syncUser = useCallback(() => {
-    if (!ready || !me?.email) return null
+    if (!ready || !me?.id) return null
     return new Talk.User({
-      id: me.email,
+      id: me.id,
       name: me.name || deriveName(me.email),
-      email: me.email,
+      email: me.email || null,
       role: 'default',
     })
   }, [ready, me])
 
-  // 3) The other participant (author vs assignee)
-  const otherEmail = useMemo(() => {
-    if (!task || !me?.email) return ''
-    return me.email === task.requester ? task.assigned_to || '' : task.requester || ''
+  // 3) The other participant (author vs assignee) — display info via profile lookup
+  const otherId = useMemo(() => {
+    if (!task || !me?.id) return ''
+    return me.id === task.requester ? task.assigned_to || '' : task.requester || ''
   }, [task, me])
+  const [other, setOther] = useState(null)
+  useEffect(() => {
+    if (!otherId) return
+    let alive = true
+    api(`/users/${otherId}/profile`)
+      .then((p) => { if (alive) setOther(p) })
+      .catch(() => {})
+    return () => { alive = false }
+  }, [otherId])
 
   // 4) Conversation for this task
   const syncConversation = useCallback(
@@ -52,19 +62,19 @@ export default function TaskChatBox({ task, me, height = 320, className = '' })
       if (!ready || !task?.id) return null
       const conv = session.getOrCreateConversation(`task_${task.id}`)
       conv.setParticipant(session.me)
-      if (otherEmail) {
-        const other = new Talk.User({
-          id: otherEmail,
-          name: deriveName(otherEmail),
-          email: otherEmail,
+      if (otherId) {
+        const otherUser = new Talk.User({
+          id: otherId,
+          name: other?.name || deriveName(other?.email),
+          email: other?.email || null,
           role: 'default',
         })
-        conv.setParticipant(other)
+        conv.setParticipant(otherUser)
       }
       conv.setAttributes({ subject: task.title ?? 'Task', custom: { taskId: task.id } })
       return conv
     },
-    [ready, task?.id, task?.title, otherEmail]
+    [ready, task?.id, task?.title, otherId, other]
   )
 
   // Guards & placeholders
@@ -85,7 +95,7 @@ export default function TaskChatBox({ task, me, height = 320, className = '' })
       </div>
     )
   }
-  if (!me?.email) return null
+  if (!me?.id) return null
 
   return (
     <Session appId={appId} syncUser={syncUser}>
@@ -1,6 +1,7 @@
 import { useMemo, useState, useEffect } from 'react'
 import { useNavigate } from 'react-router-dom'
 import { supabase } from '../lib/supabaseClient'
+import { api } from '../api/client'
 import { useRequireAuth } from '../auth/UseRequireAuth'
 
 
@@ -59,83 +60,35 @@ export default function NewTask() {
 
   const totalEstimate = useMemo(() => (timeCost + advance), [timeCost, advance])
 
-  const scheduledAtISO = useMemo(() => {
-    if (mode !== 'schedule' || !date || !timeStr) return ''
-    const dt = new Date(`${date}T${timeStr}`)   // 以本地時區組合
-    if (Number.isNaN(dt.getTime())) return ''
-    return dt.toISOString()                     // 後端要 RFC3339/ISO
-    }, [mode, date, timeStr])
-
   async function onSubmit(e) {
-    // e.preventDefault()
-    // setTouched(true)
-    // if (!canSubmit) return
-    // setIsSubmitting(true)
-    // try {
-    //   // 把多地點合併成一個字串（MVP 先這樣傳給後端）
-    //   const location_text = locations
-    //     .map((s) => s.trim())
-    //     .filter(Boolean)
-    //     .join(' | ')
-
-
-    //   const payload = {
-    //     title, description, category, location_text,
-    //     estimated_minutes: Number(minutes) || 30,
-    //     prepay_amount_cents: Math.round((advance || 0) * 100),
-    //     is_immediate: mode === 'now',
-    //     scheduled_at: mode === 'schedule' ? scheduledAtISO : '',
-    //   }
-
-    // 由後端寫入
-    //   const t = await api('/tasks', { method: 'POST', body: payload })
-    //   nav(`/tasks/${t.id}`)
-    // } catch (e) {
-    //   alert(e.message || 'Failed to create task')
-    // } finally {
-    //   setIsSubmitting(false)
-    // }
-
-    // 直接寫入supabase
-     e.preventDefault()
+    e.preventDefault()
     setTouched(true)
     if (!canSubmit) return
+    if (!user) {
+      alert('請先登入')
+      return
+    }
     setIsSubmitting(true)
     try {
-      if (!user) {            // 用 context 的 user
-        alert('請先登入')
-        return
-      }
-      const { data: { user: supaUser }  } = await supabase.auth.getUser()
-      if (!user) { alert('請先登入'); return }
-
-      // 把多地點字串化（沿用你現有邏輯）
+      // 把多地點合併成一個字串（MVP 先這樣傳給後端）
       const location_text = locations
         .map((s) => s.trim())
         .filter(Boolean)
         .join(' | ')
 
-    // 組 payload（scheduled_at 用 null，不要空字串）
-    const payload = {
-      title,
-      description,
-      category,
-      location_text,
-      estimated_minutes: Number(minutes) || 30,
-      prepay_amount_cents: Math.round((advance || 0) * 100),
-      is_immediate: mode === 'now',
-      scheduled_at: mode === 'schedule' ? scheduledAtISO : null,
-      requester: supaUser.email, // 🔑 RLS 插入一定要是自己
-    }
-
-    const { data, error } = await supabase
-      .from('tasks')
-      .insert([payload])
-      .select('id')      // 只取回 id 就好；你也可 select('*')
-      .single()
+      const payload = {
+        title, description, category, location_text,
+        estimated_minutes: Number(minutes) || 30,
+        prepay_amount_cents: Math.round((advance || 0) * 100),
+        is_immediate: mode === 'now',
+        // 本地牆上時間 + 時區，由後端換算並檢查不可是過去
+        scheduled_at: mode === 'schedule' ? `${date}T${timeStr}` : '',
+        timezone: Intl.DateTimeFormat().resolvedOptions().timeZone,
+      }
 
-     if (error) throw error
-    nav(`/tasks/${data.id}`)
+      // 由後端寫入（驗證、預算、排程檢查都在 POST /tasks）
+      const t = await api('/tasks', { method: 'POST', body: payload })
+      nav(`/tasks/${t.id}`)
     } catch (e) {
       alert(e.message || 'Failed to create task')
     } finally {
@@ -37,8 +37,8 @@ export default function TaskDetail() {
   })
 
   // 身份判斷
-  const isOwner    = Boolean(user?.email && task?.requester   && user.email === task.requester)
-  const isAssignee = Boolean(user?.email && task?.assigned_to && user.email === task.assigned_to)
+  const isOwner    = Boolean(user?.id && task?.requester   && user.id === task.requester)
+  const isAssignee = Boolean(user?.id && task?.assigned_to && user.id === task.assigned_to)
   const hasLogged   = (work.total_minutes || 0) > 0
   const canComplete = Boolean(
   (isOwner || isAssignee) &&
@@ -55,7 +55,7 @@ export default function TaskDetail() {
   )
 
   console.log('[Detail canAccept]', {
-  user: user?.email,
+  user: user?.id,
   requester: task?.requester,
   assigned_to: task?.assigned_to,
   status: task?.status,
@@ -131,6 +131,83 @@ async function acceptFromDetail() {
     return () => { alive = false }
   }, [id, task])
 
+  // 接單者的公開檔案（只有作者需要看）
+  const [helper, setHelper] = useState(null)
+  useEffect(() => {
+    if (!isOwner || !task?.assigned_to) { setHelper(null); return }
+    let alive = true
+    api(`/users/${task.assigned_to}/profile`)
+      .then((p) => { if (alive) setHelper(p) })
+      .catch(() => {})
+    return () => { alive = false }
+  }, [isOwner, task?.assigned_to])
+
+  // 照片附件（作者 / 接單者才看得到；網址 15 分鐘內有效）
+  const [photos, setPhotos] = useState([])
+  const [uploadingPhoto, setUploadingPhoto] = useState(false)
+  async function reloadPhotos() {
+    try { setPhotos(await api(`/tasks/${id}/attachments`)) } catch { /* 403：略過 */ }
+  }
+  useEffect(() => {
+    if (isOwner || isAssignee) reloadPhotos()
+  }, [id, isOwner, isAssignee])
+
+  async function uploadPhoto(file) {
+    if (!file) return
+    setUploadingPhoto(true)
+    try {
+      const form = new FormData()
+      form.append('role', isOwner ? 'brief' : 'proof')
+      form.append('photo', file)
+      await api(`/tasks/${id}/attachments`, { method: 'POST', body: form })
+      await reloadPhotos()
+    } catch (e) {
+      alert(e.message || 'Upload failed')
+    } finally {
+      setUploadingPhoto(false)
+    }
+  }
+  async function deletePhoto(photoId) {
+    try {
+      await api(`/tasks/${id}/attachments/${photoId}`, { method: 'DELETE' })
+      await reloadPhotos()
+    } catch (e) {
+      alert(e.message || 'Delete failed')
+    }
+  }
+
+  // 待辦清單：作者新增 / 刪除，接單者勾選
+  const [newItem, setNewItem] = useState('')
+  async function reloadTask() {
+    setTask(await api(`/tasks/${id}`))
+  }
+  async function addChecklistItem() {
+    if (!newItem.trim()) return
+    try {
+      await api(`/tasks/${id}/checklist`, { method: 'POST', body: { text: newItem } })
+      setNewItem('')
+      await reloadTask()
+    } catch (e) {
+      alert(e.message || 'Failed to add item')
+    }
+  }
+  async function removeChecklistItem(itemId) {
+    try {
+      await api(`/tasks/${id}/checklist/${itemId}`, { method: 'DELETE' })
+      await reloadTask()
+    } catch (e) {
+      alert(e.message || 'Failed to remove item')
+    }
+  }
+  async function toggleChecklistItem(item) {
+    try {
+      await api(`/tasks/${id}/checklist/${item.id}/check`, { method: item.checked_at ? 'DELETE' : 'POST' })
+      await reloadTask()
+    } catch (e) {
+      alert(e.message || 'Failed to update item')
+    }
+  }
+
   // 重新抓工時
   async function reloadWork() {
       const [t, w] = await Promise.all([
@@ -210,6 +287,7 @@ async function acceptFromDetail() {
         prepay_amount_cents: Math.round((advance || 0) * 100),
         is_immediate: mode === 'now',
         scheduled_at: mode === 'schedule' ? scheduledAtISO : '',
+        timezone: Intl.DateTimeFormat().resolvedOptions().timeZone,
       }
       const updated = await api(`/tasks/${task.id}`, { method: 'PATCH', body: payload })
       setTask(updated)
@@ -288,6 +366,93 @@ async function acceptFromDetail() {
               {task.description || 'No description.'}
             </div>
 
+              {/* Helper (assignee) public profile */}
+              {isOwner && helper && (
+                <div className="border border-white/20 rounded-md p-3 text-sm space-y-1">
+                  <div className="flex items-center gap-2">
+                    {helper.avatar_url && <img src={helper.avatar_url} alt="" className="h-8 w-8 rounded-full object-cover" />}
+                    <b>{helper.name}</b>
+                    {helper.city && <span className="opacity-70">· {helper.city}</span>}
+                  </div>
+                  <div className="opacity-80">
+                    {helper.rating != null ? `★ ${helper.rating.toFixed(1)} (${helper.rating_count})` : 'No reviews yet'}
+                    {' · '}{helper.completed_tasks} tasks done
+                    {helper.on_time_rate != null && ` · ${Math.round(helper.on_time_rate * 100)}% on time`}
+                    {' · '}verification level {helper.verification_level}
+                  </div>
+                  {(helper.phone || helper.email) && (
+                    <div className="opacity-80">{[helper.phone, helper.email].filter(Boolean).join(' · ')}</div>
+                  )}
+                </div>
+              )}
+
+              {/* Checklist */}
+              {(task.checklist || (isOwner && task.status === 'open')) && (
+                <div className="border border-white/20 rounded-md p-3 text-sm space-y-2">
+                  <div className="flex items-center justify-between">
+                    <span>Checklist</span>
+                    {task.checklist && (
+                      <span className="text-xs opacity-80">
+                        {task.checklist.done}/{task.checklist.total} done{task.require_checklist && ' · required to complete'}
+                      </span>
+                    )}
+                  </div>
+                  {(task.checklist?.items || []).map((item) => (
+                    <label key={item.id} className="flex items-center gap-2">
+                      <input type="checkbox" checked={Boolean(item.checked_at)}
+                        disabled={!isAssignee || task.status !== 'open'}
+                        onChange={() => toggleChecklistItem(item)} />
+                      <span className={`flex-1 ${item.checked_at ? 'line-through opacity-60' : ''}`}>{item.text}</span>
+                      {item.checked_at && <span className="text-[11px] opacity-60">{new Date(item.checked_at).toLocaleTimeString()}</span>}
+                      {isOwner && task.status === 'open' && !item.checked_at && (
+                        <button type="button" onClick={() => removeChecklistItem(item.id)} className="px-1 text-xs opacity-70 hover:opacity-100">×</button>
+                      )}
+                    </label>
+                  ))}
+                  {isOwner && task.status === 'open' && !task.checklist?.done && (
+                    <div className="flex gap-2">
+                      <input className="flex-1 rounded-md px-2 py-1 bg-transparent outline-none border border-white/20 focus:border-white/40"
+                        value={newItem} onChange={(e) => setNewItem(e.target.value)} placeholder="Add a step"
+                        onKeyDown={(e) => { if (e.key === 'Enter') addChecklistItem() }} />
+                      <button type="button" onClick={addChecklistItem} className="px-2 py-1 rounded-md border border-white/20 hover:border-white/40">＋</button>
+                    </div>
+                  )}
+                </div>
+              )}
+
+              {/* Photos: brief (requester) / proof (assignee) */}
+              {(isOwner || isAssignee) && (
+                <div className="border border-white/20 rounded-md p-3 text-sm space-y-2">
+                  <div className="flex items-center justify-between">
+                    <span>Photos</span>
+                    {task.status === 'open' && (
+                      <label className="text-xs rounded-md border border-white/20 px-2 py-1 hover:border-white/40 cursor-pointer">
+                        {uploadingPhoto ? 'Uploading…' : isOwner ? '+ Reference photo' : '+ Proof photo'}
+                        <input type="file" accept="image/jpeg,image/png,image/gif" className="hidden" disabled={uploadingPhoto}
+                          onChange={(e) => { uploadPhoto(e.target.files?.[0]); e.target.value = '' }} />
+                      </label>
+                    )}
+                  </div>
+                  {photos.length === 0 ? (
+                    <div className="opacity-70">No photos yet.</div>
+                  ) : (
+                    <div className="grid grid-cols-3 gap-2">
+                      {photos.map((p) => (
+                        <div key={p.id} className="relative">
+                          <a href={p.url} target="_blank" rel="noreferrer">
+                            <img src={p.url} alt={p.role} className="h-24 w-full rounded object-cover" />
+                          </a>
+                          <span className="absolute left-1 top-1 rounded bg-black/60 px-1 text-[10px] uppercase">{p.role}</span>
+                          {p.uploaded_by === user?.id && task.status === 'open' && (
+                            <button onClick={() => deletePhoto(p.id)} className="absolute right-1 top-1 rounded bg-black/60 px-1 text-[10px]">×</button>
+                          )}
+                        </div>
+                      ))}
+                    </div>
+                  )}
+                </div>
+              )}
+
               {/* Worklogs / Time & Cost */}
               {(isOwner || isAssignee) && (
                 <div className="border border-white/20 rounded-md p-3">
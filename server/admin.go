@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operator tooling. Routes live under /admin:
+//   moderator+: list/search tasks and users
+//   admin:      force task transitions, manage roles, reconcile payouts
+// Every write (and every impersonated request) lands in admin_audit_log.
+
+var taskStatuses = map[string]bool{"open": true, "completed": true, "cancelled": true}
+
+func auditAdmin(ctx context.Context, actor, action, target string, detail map[string]any) {
+	body, _ := json.Marshal(detail)
+	if _, err := db.Exec(ctx, `
+    insert into public.admin_audit_log(actor,action,target,detail) values ($1,$2,$3,$4)
+  `, actor, action, target, body); err != nil {
+		log.Printf("[admin] audit %s %s: %v", action, target, err)
+	}
+}
+
+// pageParams reads ?limit=&offset= (limit 1..200, default 50).
+func pageParams(c *gin.Context) (limit, offset int) {
+	limit, _ = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// GET /admin/tasks?q=&status=&user=&limit=&offset=
+func adminListTasks(c *gin.Context) {
+	ctx := c.Request.Context()
+	q := strings.TrimSpace(c.Query("q"))
+	status := strings.TrimSpace(c.Query("status"))
+	user := strings.TrimSpace(c.Query("user"))
+	limit, offset := pageParams(c)
+
+	rows, err := db.Query(ctx, `
+    select `+taskColumns+`
+    from public.tasks
+    where ($1 = '' or title ilike '%' || $1 || '%' or description ilike '%' || $1 || '%' or id::text = $1)
+      and ($2 = '' or status = $2)
+      and ($3 = '' or requester = $3 or assigned_to = $3)
+    order by created_at desc
+    limit $4 offset $5
+  `, q, status, user, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []Task{}
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, t)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+type adminUser struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Phone     string    `json:"phone"`
+	City      string    `json:"city"`
+	Roles     []string  `json:"roles"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GET /admin/users?q=&role=&limit=&offset=
+func adminListUsers(c *gin.Context) {
+	ctx := c.Request.Context()
+	q := strings.TrimSpace(c.Query("q"))
+	role := strings.TrimSpace(c.Query("role"))
+	limit, offset := pageParams(c)
+
+	rows, err := db.Query(ctx, `
+    select p.user_id, p.email, p.name, p.phone, p.city, p.created_at,
+           coalesce(array_agg(r.role order by r.role) filter (where r.role is not null), '{}')
+    from public.profiles p
+    left join public.user_roles r on r.user_id = p.user_id
+    where ($1 = '' or p.email ilike '%' || $1 || '%' or p.name ilike '%' || $1 || '%'
+           or p.phone ilike '%' || $1 || '%' or p.user_id = $1)
+      and ($2 = '' or exists (select 1 from public.user_roles x where x.user_id = p.user_id and x.role = $2))
+    group by p.user_id
+    order by p.created_at desc
+    limit $3 offset $4
+  `, q, role, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []adminUser{}
+	for rows.Next() {
+		var u adminUser
+		if err := rows.Scan(&u.ID, &u.Email, &u.Name, &u.Phone, &u.City, &u.CreatedAt, &u.Roles); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, u)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// PUT /admin/users/:id/roles {"roles": ["moderator", ...]} — replaces server-side roles.
+func adminSetRoles(c *gin.Context) {
+	target := c.Param("id")
+	actor := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var in struct {
+		Roles []string `json:"roles"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	for _, r := range in.Roles {
+		if !knownRoles[r] || r == roleUser {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role: " + r})
+			return
+		}
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `delete from public.user_roles where user_id=$1`, target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	for _, r := range in.Roles {
+		if _, err := tx.Exec(ctx, `
+      insert into public.user_roles(user_id,role,granted_by) values ($1,$2,$3) on conflict do nothing
+    `, target, r, actor); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	auditAdmin(ctx, actor, "set_roles", target, map[string]any{"roles": in.Roles})
+	c.JSON(http.StatusOK, gin.H{"id": target, "roles": in.Roles})
+}
+
+// POST /admin/tasks/:id/transition {"status": "...", "unassign": bool, "reason": "...",
+// "cancelled_by": "requester|helper", "unassigned_by": "requester|helper"}
+// Bypasses the normal flow rules (e.g. cancel a stuck task, reopen a task).
+// cancelled_by / unassigned_by attribute a cancellation or unassign to one
+// side for reliability scoring; without them it counts against nobody.
+func adminTransitionTask(c *gin.Context) {
+	id := c.Param("id")
+	actor := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var in struct {
+		Status       string `json:"status"`
+		Unassign     bool   `json:"unassign"`
+		Reason       string `json:"reason"`
+		CancelledBy  string `json:"cancelled_by"`
+		UnassignedBy string `json:"unassigned_by"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	in.Reason = strings.TrimSpace(in.Reason)
+	if !taskStatuses[in.Status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+	if in.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason required"})
+		return
+	}
+	switch {
+	case in.Status != "cancelled" && in.CancelledBy != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cancelled_by only applies to status cancelled"})
+		return
+	case in.Status == "cancelled" && in.CancelledBy == "":
+		in.CancelledBy = "admin"
+	case in.CancelledBy != "" && in.CancelledBy != "requester" && in.CancelledBy != "helper":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cancelled_by must be requester or helper"})
+		return
+	}
+	switch {
+	case !in.Unassign && in.UnassignedBy != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unassigned_by only applies with unassign"})
+		return
+	case in.Unassign && in.UnassignedBy == "":
+		in.UnassignedBy = "admin"
+	case in.UnassignedBy != "" && in.UnassignedBy != "requester" && in.UnassignedBy != "helper":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unassigned_by must be requester or helper"})
+		return
+	}
+
+	var from, assignedTo string
+	err := db.QueryRow(ctx, `
+    with old as (select status, assigned_to from public.tasks where id=$1 for update)
+    update public.tasks t
+    set status=$2,
+        assigned_to = case when $3 then '' else t.assigned_to end,
+        completed_at = case when $2 = 'completed' then coalesce(t.completed_at, now()) else null end,
+        cancelled_by = $4
+    from old where t.id=$1
+    returning old.status, old.assigned_to
+  `, id, in.Status, in.Unassign, in.CancelledBy).Scan(&from, &assignedTo)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	auditAdmin(ctx, actor, "task_transition", id, map[string]any{
+		"from": from, "to": in.Status, "unassigned": in.Unassign && assignedTo != "",
+		"previous_assignee": assignedTo, "reason": in.Reason, "cancelled_by": in.CancelledBy,
+		"unassigned_by": in.UnassignedBy,
+	})
+	getTask(c)
+}
@@ -0,0 +1,364 @@
+package main
+
+// Personal access tokens (PAT) and service-account credentials.
+// - Format: "hora_pat_<random>" / "hora_sa_<random>"; only the SHA-256 hash
+//   is stored, the plain token is shown once at creation.
+// - Tokens carry scopes "<resource>:read|write"; route groups declare their
+//   resource via requireScope. Browser sessions (Supabase JWT) are unscoped.
+// - A PAT acts as its owner (same uid); a service account has its own uid.
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	patPrefix            = "hora_pat_"
+	serviceAccountPrefix = "hora_sa_"
+)
+
+var knownScopes = map[string]bool{
+	"tasks:read": true, "tasks:write": true,
+	"profile:read": true, "profile:write": true,
+	"me:read": true, "me:write": true,
+	"users:read": true,
+}
+
+type APIToken struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"` // pat | service
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Token      string     `json:"token,omitempty"` // only in the creation response
+}
+
+const apiTokenColumns = `id,kind,name,token_prefix,scopes,created_at,expires_at,last_used_at,revoked_at`
+
+func scanAPIToken(rows interface{ Scan(dest ...any) error }) (APIToken, error) {
+	var t APIToken
+	err := rows.Scan(&t.ID, &t.Kind, &t.Name, &t.Prefix, &t.Scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt)
+	return t, err
+}
+
+func isAPIToken(s string) bool {
+	return strings.HasPrefix(s, patPrefix) || strings.HasPrefix(s, serviceAccountPrefix)
+}
+
+func hashAPIToken(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIToken(prefix string) string {
+	var b [32]byte
+	_, _ = rand.Read(b[:])
+	return prefix + base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+func validScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, s := range scopes {
+		if !knownScopes[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// insertAPIToken stores a new token for userID and returns it with the
+// plain secret filled in.
+func insertAPIToken(ctx context.Context, q dbtx, kind, userID, createdBy, name string, scopes []string, expiresAt *time.Time) (APIToken, error) {
+	prefix := patPrefix
+	if kind == "service" {
+		prefix = serviceAccountPrefix
+	}
+	plain := generateAPIToken(prefix)
+	t, err := scanAPIToken(q.QueryRow(ctx, `
+    insert into public.api_tokens(kind,user_id,created_by,name,token_hash,token_prefix,scopes,expires_at)
+    values ($1,$2,$3,$4,$5,$6,$7,$8)
+    returning `+apiTokenColumns, kind, userID, createdBy, name, hashAPIToken(plain), plain[:len(prefix)+6], scopes, expiresAt))
+	t.Token = plain
+	return t, err
+}
+
+// authenticateAPIToken resolves a PAT / service token to (uid, scopes).
+func authenticateAPIToken(ctx context.Context, plain string) (uid string, scopes []string, tokenID string, err error) {
+	err = db.QueryRow(ctx, `
+    select t.id, t.user_id, t.scopes
+    from public.api_tokens t
+    left join public.service_accounts s on t.kind = 'service' and s.id::text = t.user_id
+    where t.token_hash = $1 and t.revoked_at is null
+      and (t.expires_at is null or t.expires_at > now())
+      and (t.kind = 'pat' or (s.id is not null and s.disabled_at is null))
+  `, hashAPIToken(plain)).Scan(&tokenID, &uid, &scopes)
+	if err != nil {
+		return "", nil, "", err
+	}
+	// last_used_at：最多每分鐘寫一次
+	_, _ = db.Exec(ctx, `
+    update public.api_tokens set last_used_at=now()
+    where id=$1 and (last_used_at is null or last_used_at < now() - interval '1 minute')
+  `, tokenID)
+	return uid, scopes, tokenID, nil
+}
+
+// requireScope: API tokens need "<resource>:read" for GET/HEAD and
+// "<resource>:write" otherwise. JWT sessions pass unchanged.
+func requireScope(resource string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, ok := c.Get("scopes")
+		if !ok {
+			c.Next()
+			return
+		}
+		want := resource + ":write"
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			want = resource + ":read"
+		}
+		for _, s := range v.([]string) {
+			if s == want {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token missing scope " + want})
+	}
+}
+
+// requireSession rejects API tokens (e.g. tokens cannot mint tokens).
+func requireSession(c *gin.Context) {
+	if _, ok := c.Get("scopes"); ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "browser session required"})
+		return
+	}
+	c.Next()
+}
+
+// -------- Personal access token handlers --------
+
+func createMyToken(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var in struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"` // 0 = never
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required"})
+		return
+	}
+	if !validScopes(in.Scopes) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scopes"})
+		return
+	}
+	if in.ExpiresInDays < 0 || in.ExpiresInDays > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must be 0..365"})
+		return
+	}
+	var expiresAt *time.Time
+	if in.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, in.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	t, err := insertAPIToken(ctx, db, "pat", me, me, in.Name, in.Scopes, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, t)
+}
+
+func listMyTokens(c *gin.Context) {
+	me := c.GetString("uid")
+	rows, err := db.Query(c.Request.Context(), `
+    select `+apiTokenColumns+` from public.api_tokens
+    where kind='pat' and user_id=$1 order by created_at desc
+  `, me)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, t)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func revokeMyToken(c *gin.Context) {
+	me := c.GetString("uid")
+	tag, err := db.Exec(c.Request.Context(), `
+    update public.api_tokens set revoked_at=now()
+    where id=$1 and kind='pat' and user_id=$2 and revoked_at is null
+  `, c.Param("id"), me)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// -------- Service account handlers (admin) --------
+
+type ServiceAccount struct {
+	ID         string     `json:"id"` // used as uid by its tokens
+	Name       string     `json:"name"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	Tokens     []APIToken `json:"tokens,omitempty"`
+}
+
+// POST /admin/service-accounts {"name": "...", "scopes": [...]}
+// Creates the account and its first credential (returned once).
+func adminCreateServiceAccount(c *gin.Context) {
+	actor := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var in struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" || !validScopes(in.Scopes) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and valid scopes required"})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var sa ServiceAccount
+	if err := tx.QueryRow(ctx, `
+    insert into public.service_accounts(name,created_by) values ($1,$2)
+    returning id, name, created_by, created_at
+  `, in.Name, actor).Scan(&sa.ID, &sa.Name, &sa.CreatedBy, &sa.CreatedAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	t, err := insertAPIToken(ctx, tx, "service", sa.ID, actor, in.Name, in.Scopes, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	auditAdmin(ctx, actor, "create_service_account", sa.ID, map[string]any{"name": in.Name, "scopes": in.Scopes})
+	sa.Tokens = []APIToken{t}
+	c.JSON(http.StatusCreated, sa)
+}
+
+func adminListServiceAccounts(c *gin.Context) {
+	ctx := c.Request.Context()
+	rows, err := db.Query(ctx, `
+    select id, name, created_by, created_at, disabled_at from public.service_accounts order by created_at desc
+  `)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	out := []ServiceAccount{}
+	for rows.Next() {
+		var sa ServiceAccount
+		if err := rows.Scan(&sa.ID, &sa.Name, &sa.CreatedBy, &sa.CreatedAt, &sa.DisabledAt); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, sa)
+	}
+	rows.Close()
+
+	for i := range out {
+		trows, err := db.Query(ctx, `
+      select `+apiTokenColumns+` from public.api_tokens
+      where kind='service' and user_id=$1 order by created_at desc
+    `, out[i].ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		for trows.Next() {
+			t, err := scanAPIToken(trows)
+			if err != nil {
+				trows.Close()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+				return
+			}
+			out[i].Tokens = append(out[i].Tokens, t)
+		}
+		trows.Close()
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// DELETE /admin/service-accounts/:id — disables the account and revokes its tokens.
+func adminDisableServiceAccount(c *gin.Context) {
+	id := c.Param("id")
+	actor := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var disabledAt time.Time
+	err := db.QueryRow(ctx, `
+    update public.service_accounts set disabled_at=now()
+    where id::text=$1 and disabled_at is null returning disabled_at
+  `, id).Scan(&disabledAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	_, _ = db.Exec(ctx, `
+    update public.api_tokens set revoked_at=now() where kind='service' and user_id=$1 and revoked_at is null
+  `, id)
+	auditAdmin(ctx, actor, "disable_service_account", id, nil)
+	c.Status(http.StatusNoContent)
+}
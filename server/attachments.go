@@ -0,0 +1,311 @@
+package main
+
+// Task photo attachments. The requester attaches "brief" photos (what to pick
+// up, where to go); the assignee attaches "proof" photos (delivered at door).
+// Photos are re-encoded (EXIF incl. GPS dropped) and downscaled before they
+// are stored. Only the requester and assignee can see them: the list endpoint
+// hands out short-lived signed URLs bound to the viewer, and the download
+// re-checks that the viewer is still a participant.
+// ENV:
+//   ATTACHMENT_SIGNING_KEY=...   HMAC key for download URLs (required; with
+//                                HORA_DEV_MODE=true a random per-process key)
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxAttachmentBytes    = 10 << 20
+	maxAttachmentsPerTask = 20
+	attachmentMaxSide     = 2048 // 長邊上限（px）
+	attachmentURLTTL      = 15 * time.Minute
+)
+
+var attachmentRoles = map[string]bool{"brief": true, "proof": true}
+
+type Attachment struct {
+	ID         string    `json:"id"`
+	TaskID     string    `json:"task_id"`
+	Role       string    `json:"role"` // brief | proof
+	UploadedBy string    `json:"uploaded_by"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	CreatedAt  time.Time `json:"created_at"`
+	URL        string    `json:"url,omitempty"`
+	URLExpires time.Time `json:"url_expires_at,omitempty"`
+}
+
+const attachmentColumns = `id,task_id,role,uploaded_by,width,height,created_at`
+
+func scanAttachment(rows interface{ Scan(dest ...any) error }) (Attachment, error) {
+	var a Attachment
+	err := rows.Scan(&a.ID, &a.TaskID, &a.Role, &a.UploadedBy, &a.Width, &a.Height, &a.CreatedAt)
+	return a, err
+}
+
+var attachmentSigningKey []byte
+
+// initAttachmentSigning fails closed like initInvoiceSigning. A random key
+// only works for a single process (links break on restart or across
+// instances), so it is allowed in dev mode only.
+func initAttachmentSigning() {
+	if k := strings.TrimSpace(os.Getenv("ATTACHMENT_SIGNING_KEY")); k != "" {
+		attachmentSigningKey = []byte(k)
+		return
+	}
+	if !devMode() {
+		log.Fatal("ATTACHMENT_SIGNING_KEY is not set")
+	}
+	attachmentSigningKey = make([]byte, 32)
+	_, _ = rand.Read(attachmentSigningKey)
+	log.Printf("[attachments] ATTACHMENT_SIGNING_KEY not set, using a random key (dev mode)")
+}
+
+func attachmentSignature(id, viewer string, exp int64) string {
+	m := hmac.New(sha256.New, attachmentSigningKey)
+	m.Write([]byte(id + "|" + viewer + "|" + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// signAttachmentURL fills a.URL with a download link valid for viewer only.
+func signAttachmentURL(a *Attachment, viewer string) {
+	a.URLExpires = time.Now().Add(attachmentURLTTL).Truncate(time.Second)
+	exp := a.URLExpires.Unix()
+	q := url.Values{}
+	q.Set("u", viewer)
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	q.Set("sig", attachmentSignature(a.ID, viewer, exp))
+	a.URL = publicBaseURL() + "/attachments/" + a.ID + "?" + q.Encode()
+}
+
+// taskParticipants returns requester, assignee, status and category of a task.
+func taskParticipants(ctx context.Context, taskID string) (requester, assignedTo, status, category string, err error) {
+	err = db.QueryRow(ctx, `
+    select requester, assigned_to, status, category from public.tasks where id=$1
+  `, taskID).Scan(&requester, &assignedTo, &status, &category)
+	return
+}
+
+// hasProofPhoto: the assignee uploaded at least one proof photo.
+func hasProofPhoto(ctx context.Context, q dbtx, taskID, assignee string) (bool, error) {
+	var ok bool
+	err := q.QueryRow(ctx, `
+    select exists (
+      select 1 from public.task_attachments
+      where task_id=$1 and role='proof' and uploaded_by=$2 and deleted_at is null
+    )`, taskID, assignee).Scan(&ok)
+	return ok, err
+}
+
+// -------- Handlers --------
+
+// POST /tasks/:id/attachments (multipart: role=brief|proof, photo=<jpeg/png/gif>)
+// brief: requester only; proof: assignee only. Task must be open.
+func createAttachment(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+	// 先限制 body 大小，PostForm 會解析整個 multipart
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAttachmentBytes+1<<20)
+
+	requester, assignedTo, status, _, err := taskParticipants(ctx, taskID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	role := strings.TrimSpace(c.PostForm("role"))
+	if !attachmentRoles[role] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be brief or proof"})
+		return
+	}
+	if (role == "brief" && me != requester) || (role == "proof" && me != assignedTo) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "brief photos are added by the requester, proof photos by the assignee"})
+		return
+	}
+	if status != "open" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task not open"})
+		return
+	}
+
+	fh, err := c.FormFile("photo")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "photo file required"})
+		return
+	}
+	if fh.Size > maxAttachmentBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "photo too large"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid photo"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxAttachmentBytes+1))
+	f.Close()
+	if err != nil || len(data) > maxAttachmentBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid photo"})
+		return
+	}
+	if !imageTypes[http.DetectContentType(data)] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "photo must be jpeg, png or gif"})
+		return
+	}
+	img, err := decodeImage(data, 1)
+	if errors.Is(err, errImageTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image dimensions too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid photo"})
+		return
+	}
+	scaled := fitWithin(img, attachmentMaxSide)
+	out, err := encodeJPEG(scaled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "image error"})
+		return
+	}
+
+	key := newBlobKey("attachments/"+taskID, ".jpg")
+	if err := blobs.Put(ctx, key, bytes.NewReader(out), "image/jpeg"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
+		return
+	}
+	// 上限檢查放在 insert 同一句
+	a, err := scanAttachment(db.QueryRow(ctx, `
+    insert into public.task_attachments(task_id,role,uploaded_by,file_key,width,height)
+    select $1,$2,$3,$4,$5,$6
+    where (select count(*) from public.task_attachments where task_id=$1 and deleted_at is null) < $7
+    returning `+attachmentColumns,
+		taskID, role, me, key, scaled.Bounds().Dx(), scaled.Bounds().Dy(), maxAttachmentsPerTask))
+	if err != nil {
+		_ = blobs.Delete(ctx, key)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusConflict, gin.H{"error": "too many attachments on this task"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	signAttachmentURL(&a, me)
+	c.JSON(http.StatusCreated, a)
+}
+
+// GET /tasks/:id/attachments?role= — requester and assignee only.
+func listAttachments(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	requester, assignedTo, _, _, err := taskParticipants(ctx, taskID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if me != requester && me != assignedTo {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+		return
+	}
+	role := c.Query("role")
+	rows, err := db.Query(ctx, `
+    select `+attachmentColumns+` from public.task_attachments
+    where task_id=$1 and deleted_at is null and ($2 = '' or role = $2)
+    order by created_at asc
+  `, taskID, role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		signAttachmentURL(&a, me)
+		out = append(out, a)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// DELETE /tasks/:id/attachments/:attachmentId — uploader only, while the task is open.
+func deleteAttachment(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var key string
+	err := db.QueryRow(ctx, `
+    update public.task_attachments a set deleted_at=now()
+    from public.tasks t
+    where a.id::text=$2 and a.task_id=$1 and t.id=a.task_id
+      and a.uploaded_by=$3 and a.deleted_at is null and t.status='open'
+    returning a.file_key
+  `, taskID, c.Param("attachmentId"), me).Scan(&key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	_ = blobs.Delete(ctx, key)
+	c.Status(http.StatusNoContent)
+}
+
+// GET /attachments/:id?u=&exp=&sig= — no bearer token (used in <img src>);
+// the signature binds the link to one viewer and expires.
+func getAttachmentFile(c *gin.Context) {
+	id := c.Param("id")
+	viewer := c.Query("u")
+	ctx := c.Request.Context()
+
+	exp, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+	if err != nil || viewer == "" || time.Now().Unix() > exp ||
+		!hmac.Equal([]byte(c.Query("sig")), []byte(attachmentSignature(id, viewer, exp))) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "invalid or expired link"})
+		return
+	}
+
+	// 連結有效期間若被解除指派，也要立即失效
+	var key string
+	if err := db.QueryRow(ctx, `
+    select a.file_key from public.task_attachments a
+    join public.tasks t on t.id = a.task_id
+    where a.id::text=$1 and a.deleted_at is null
+      and (t.requester=$2 or t.assigned_to=$2)
+  `, id, viewer).Scan(&key); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	rc, err := blobs.Get(ctx, key)
+	if errors.Is(err, errBlobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
+		return
+	}
+	defer rc.Close()
+	c.Header("Cache-Control", "private, max-age=900")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, -1, "image/jpeg", rc, nil)
+}
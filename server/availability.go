@@ -0,0 +1,418 @@
+package main
+
+// Helper availability: weekly hours (in the profile's time zone) and time
+// off, plus conflict detection against the helper's other accepted tasks.
+// A task occupies [start, start + estimated_minutes); start is scheduled_at
+// (now for immediate tasks). A task with a window occupies the whole window,
+// since the helper may be asked to come at any time in it. Two tasks conflict
+// when they are less than the travel buffer apart. acceptTask and acceptOffer
+// refuse conflicts with accepted tasks (409, unless the helper passes
+// ?allow_conflicts=true) and only warn when the task falls outside the
+// declared hours or into time off. The check runs in the assignment
+// transaction with the helper's schedule locked, so two parallel accepts
+// cannot both slip past it.
+// No weekly hours declared = available any time.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxAvailabilitySlots = 50
+	maxTimeOffEntries    = 100
+	maxTimeOffNote       = 200
+)
+
+func travelBuffer() time.Duration {
+	mins, err := strconv.Atoi(envOr("TRAVEL_BUFFER_MINUTES", "30"))
+	if err != nil || mins < 0 {
+		mins = 30
+	}
+	return time.Duration(mins) * time.Minute
+}
+
+// AvailabilitySlot is one weekly window, e.g. {weekday: 1, start: "09:00",
+// end: "17:00"} = Mondays 9–17. Weekday is ISO (1 = Monday … 7 = Sunday);
+// end "24:00" means midnight.
+type AvailabilitySlot struct {
+	Weekday int    `json:"weekday"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+}
+
+type TimeOff struct {
+	ID        string    `json:"id"`
+	StartAt   time.Time `json:"start_at"`
+	EndAt     time.Time `json:"end_at"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Availability struct {
+	Timezone string             `json:"timezone"`
+	Slots    []AvailabilitySlot `json:"slots"`
+	TimeOff  []TimeOff          `json:"time_off"` // upcoming only
+}
+
+// ScheduleConflict is another accepted task too close to the one in question.
+type ScheduleConflict struct {
+	TaskID string    `json:"task_id"`
+	Title  string    `json:"title"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+type minuteRange struct{ weekday, start, end int } // ISO weekday, minutes since local midnight
+
+func parseClock(s string) (int, bool) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok || len(h) != 2 || len(m) != 2 {
+		return 0, false
+	}
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if err1 != nil || err2 != nil || mm < 0 || mm > 59 || hh < 0 || hh > 24 || (hh == 24 && mm != 0) {
+		return 0, false
+	}
+	return hh*60 + mm, true
+}
+
+func formatClock(min int) string {
+	return fmt.Sprintf("%02d:%02d", min/60, min%60)
+}
+
+func isoWeekday(t time.Time) int {
+	return int(t.Weekday()+6)%7 + 1
+}
+
+// helperSchedule is everything needed to judge whether a task fits a helper.
+type helperSchedule struct {
+	loc     *time.Location
+	weekly  []minuteRange
+	timeOff []TimeOff
+	busy    []ScheduleConflict // accepted open tasks
+}
+
+func loadHelperSchedule(ctx context.Context, q dbtx, uid string) (helperSchedule, error) {
+	hs := helperSchedule{loc: time.UTC}
+	if loc, ok := loadTimezone(profileTimezone(ctx, q, uid)); ok {
+		hs.loc = loc
+	}
+	now := time.Now()
+
+	rows, err := q.Query(ctx, `
+    select weekday, start_minute, end_minute from public.helper_availability
+    where user_id=$1 order by weekday, start_minute
+  `, uid)
+	if err != nil {
+		return hs, err
+	}
+	for rows.Next() {
+		var r minuteRange
+		if err := rows.Scan(&r.weekday, &r.start, &r.end); err != nil {
+			rows.Close()
+			return hs, err
+		}
+		hs.weekly = append(hs.weekly, r)
+	}
+	rows.Close()
+
+	rows, err = q.Query(ctx, `
+    select id, start_at, end_at, note, created_at from public.helper_time_off
+    where user_id=$1 and end_at > $2 order by start_at
+  `, uid, now)
+	if err != nil {
+		return hs, err
+	}
+	for rows.Next() {
+		var t TimeOff
+		if err := rows.Scan(&t.ID, &t.StartAt, &t.EndAt, &t.Note, &t.CreatedAt); err != nil {
+			rows.Close()
+			return hs, err
+		}
+		hs.timeOff = append(hs.timeOff, t)
+	}
+	rows.Close()
+
+	rows, err = q.Query(ctx, `
+    select `+taskColumns+` from public.tasks
+    where assigned_to=$1 and status='open' and scheduled_at is not null
+  `, uid)
+	if err != nil {
+		return hs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return hs, err
+		}
+		if start, end, ok := taskInterval(t, now); ok && end.After(now) {
+			hs.busy = append(hs.busy, ScheduleConflict{TaskID: t.ID, Title: t.Title, Start: start, End: end})
+		}
+	}
+	return hs, rows.Err()
+}
+
+// lockHelperSchedule loads the helper's schedule inside tx after locking
+// their profile row and open assignments; concurrent accepts by the same
+// helper queue up behind it.
+func lockHelperSchedule(ctx context.Context, tx pgx.Tx, uid string) (helperSchedule, error) {
+	if _, err := tx.Exec(ctx, `select 1 from public.profiles where user_id=$1 for update`, uid); err != nil {
+		return helperSchedule{}, err
+	}
+	if _, err := tx.Exec(ctx, `
+    select 1 from public.tasks where assigned_to=$1 and status='open' for update
+  `, uid); err != nil {
+		return helperSchedule{}, err
+	}
+	return loadHelperSchedule(ctx, tx, uid)
+}
+
+// taskInterval: when the task occupies the helper; ok=false for unscheduled tasks.
+func taskInterval(t Task, now time.Time) (start, end time.Time, ok bool) {
+	switch {
+	case t.IsImmediate:
+		start = now
+	case t.WindowStart != nil && t.WindowEnd != nil:
+		start = *t.WindowStart
+		end = start.Add(time.Duration(t.EstimatedMinutes) * time.Minute)
+		return start, maxTime(end, *t.WindowEnd), true
+	case t.ScheduledAt != nil:
+		start = *t.ScheduledAt
+	default:
+		return start, end, false
+	}
+	return start, start.Add(time.Duration(t.EstimatedMinutes) * time.Minute), true
+}
+
+// conflicts lists the accepted tasks (other than t) within the travel buffer of t.
+func (hs helperSchedule) conflicts(t Task, now time.Time) []ScheduleConflict {
+	start, end, ok := taskInterval(t, now)
+	if !ok {
+		return nil
+	}
+	buf := travelBuffer()
+	var out []ScheduleConflict
+	for _, b := range hs.busy {
+		if b.TaskID != t.ID && start.Before(b.End.Add(buf)) && b.Start.Before(end.Add(buf)) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// warnings: the task falls outside the declared weekly hours or into time off.
+func (hs helperSchedule) warnings(t Task, now time.Time) []string {
+	start, end, ok := taskInterval(t, now)
+	if !ok {
+		return nil
+	}
+	var out []string
+	if len(hs.weekly) > 0 && !hs.withinWeekly(start, end) {
+		out = append(out, "outside your availability hours")
+	}
+	for _, off := range hs.timeOff {
+		if start.Before(off.EndAt) && off.StartAt.Before(end) {
+			out = append(out, "during your time off")
+			break
+		}
+	}
+	return out
+}
+
+// withinWeekly: [start, end) lies inside one weekly slot (local time).
+// Adjacent slots (e.g. 09:00–12:00 and 12:00–17:00) are not merged.
+func (hs helperSchedule) withinWeekly(start, end time.Time) bool {
+	ls, le := start.In(hs.loc), end.In(hs.loc)
+	sMin := ls.Hour()*60 + ls.Minute()
+	// 以牆上時間計（DST 那天不是 1440 分鐘）；跨午夜 > 1440
+	y, m, d := ls.Date()
+	ey, em, ed := le.Date()
+	days := int(time.Date(ey, em, ed, 0, 0, 0, 0, time.UTC).Sub(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour))
+	eMin := days*1440 + le.Hour()*60 + le.Minute()
+	for _, r := range hs.weekly {
+		if r.weekday == isoWeekday(ls) && r.start <= sMin && eMin <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// checkScheduleFit judges whether helper can take t (with hs locked, see
+// lockHelperSchedule). Conflicts are refused unless allowConflicts; the
+// returned warnings are meant for the helper only. Returns 0 when allowed,
+// else the HTTP status and body.
+func (hs helperSchedule) checkScheduleFit(t Task, allowConflicts bool, now time.Time) ([]string, int, gin.H) {
+	conflicts := hs.conflicts(t, now)
+	if len(conflicts) > 0 && !allowConflicts {
+		return nil, http.StatusConflict, gin.H{"error": "schedule conflict", "conflicts": conflicts}
+	}
+	warnings := hs.warnings(t, now)
+	if len(conflicts) > 0 {
+		warnings = append(warnings, "overlaps another accepted task")
+	}
+	return warnings, 0, nil
+}
+
+// fits: no conflicts and no warnings.
+func (hs helperSchedule) fits(t Task, now time.Time) bool {
+	return len(hs.conflicts(t, now)) == 0 && len(hs.warnings(t, now)) == 0
+}
+
+// -------- Handlers --------
+
+// GET /me/availability
+func getMyAvailability(c *gin.Context) {
+	hs, err := loadHelperSchedule(c.Request.Context(), db, c.GetString("uid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	a := Availability{Timezone: hs.loc.String(), Slots: []AvailabilitySlot{}, TimeOff: hs.timeOff}
+	for _, r := range hs.weekly {
+		a.Slots = append(a.Slots, AvailabilitySlot{Weekday: r.weekday, Start: formatClock(r.start), End: formatClock(r.end)})
+	}
+	if a.TimeOff == nil {
+		a.TimeOff = []TimeOff{}
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// PUT /me/availability {slots: [{weekday, start, end}]} — replaces the weekly
+// hours; an empty list means available any time.
+func putMyAvailability(c *gin.Context) {
+	var in struct {
+		Slots []AvailabilitySlot `json:"slots"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	if len(in.Slots) > maxAvailabilitySlots {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at most 50 slots"})
+		return
+	}
+	var ranges []minuteRange
+	for _, s := range in.Slots {
+		start, ok1 := parseClock(s.Start)
+		end, ok2 := parseClock(s.End)
+		if s.Weekday < 1 || s.Weekday > 7 || !ok1 || !ok2 || end <= start {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "slots need weekday 1–7 (Monday = 1) and start < end as HH:MM"})
+			return
+		}
+		ranges = append(ranges, minuteRange{s.Weekday, start, end})
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].weekday != ranges[j].weekday {
+			return ranges[i].weekday < ranges[j].weekday
+		}
+		return ranges[i].start < ranges[j].start
+	})
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].weekday == ranges[i-1].weekday && ranges[i].start < ranges[i-1].end {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "slots must not overlap"})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	uid := c.GetString("uid")
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `delete from public.helper_availability where user_id=$1`, uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	for _, r := range ranges {
+		if _, err := tx.Exec(ctx, `
+      insert into public.helper_availability(user_id,weekday,start_minute,end_minute) values ($1,$2,$3,$4)
+    `, uid, r.weekday, r.start, r.end); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	getMyAvailability(c)
+}
+
+// POST /me/time-off {start_at, end_at, note?} — times as RFC3339 or local
+// (profile zone) "2006-01-02T15:04".
+func createTimeOff(c *gin.Context) {
+	var in struct {
+		StartAt string `json:"start_at"`
+		EndAt   string `json:"end_at"`
+		Note    string `json:"note"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	ctx := c.Request.Context()
+	uid := c.GetString("uid")
+	loc, _ := loadTimezone(profileTimezone(ctx, db, uid))
+	start, err1 := parseLocalTime(in.StartAt, loc)
+	end, err2 := parseLocalTime(in.EndAt, loc)
+	if err1 != nil || err2 != nil || !end.After(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start_at and end_at required, end_at after start_at"})
+		return
+	}
+	if !end.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time off is in the past"})
+		return
+	}
+	in.Note = strings.TrimSpace(in.Note)
+	if len([]rune(in.Note)) > maxTimeOffNote {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "note must be at most 200 characters"})
+		return
+	}
+	var t TimeOff
+	err := db.QueryRow(ctx, `
+    insert into public.helper_time_off(user_id,start_at,end_at,note)
+    select $1,$2,$3,$4
+    where (select count(*) from public.helper_time_off where user_id=$1 and end_at > now()) < $5
+    returning id, start_at, end_at, note, created_at
+  `, uid, start, end, in.Note, maxTimeOffEntries).Scan(&t.ID, &t.StartAt, &t.EndAt, &t.Note, &t.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusConflict, gin.H{"error": "too many time off entries"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, t)
+}
+
+// DELETE /me/time-off/:id
+func deleteTimeOff(c *gin.Context) {
+	tag, err := db.Exec(c.Request.Context(), `
+    delete from public.helper_time_off where id::text=$1 and user_id=$2
+  `, c.Param("id"), c.GetString("uid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTaskInterval(t *testing.T) {
+	berlin := mustLoc(t, "Europe/Berlin")
+	at := func(hh, mm int) *time.Time {
+		v := time.Date(2025, 6, 2, hh, mm, 0, 0, berlin)
+		return &v
+	}
+	now := *at(8, 0)
+
+	tests := []struct {
+		name      string
+		task      Task
+		wantStart time.Time
+		wantEnd   time.Time
+		wantOK    bool
+	}{
+		{
+			name:      "scheduled",
+			task:      Task{ScheduledAt: at(10, 0), EstimatedMinutes: 90},
+			wantStart: *at(10, 0), wantEnd: *at(11, 30), wantOK: true,
+		},
+		{
+			name:      "immediate starts now",
+			task:      Task{IsImmediate: true, ScheduledAt: at(10, 0), EstimatedMinutes: 30},
+			wantStart: now, wantEnd: *at(8, 30), wantOK: true,
+		},
+		{
+			// 時段內隨時可能被叫去：佔用整個時段
+			name:      "window occupies the whole window",
+			task:      Task{ScheduledAt: at(9, 0), WindowStart: at(9, 0), WindowEnd: at(13, 0), EstimatedMinutes: 60},
+			wantStart: *at(9, 0), wantEnd: *at(13, 0), wantOK: true,
+		},
+		{
+			name:      "window shorter than the work",
+			task:      Task{ScheduledAt: at(9, 0), WindowStart: at(9, 0), WindowEnd: at(9, 30), EstimatedMinutes: 60},
+			wantStart: *at(9, 0), wantEnd: *at(10, 0), wantOK: true,
+		},
+		{
+			name: "unscheduled",
+			task: Task{EstimatedMinutes: 60},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := taskInterval(tt.task, now)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("interval = [%s, %s), want [%s, %s)", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestWithinWeekly(t *testing.T) {
+	berlin := mustLoc(t, "Europe/Berlin")
+	at := func(y int, m time.Month, d, hh, mm int) time.Time { return time.Date(y, m, d, hh, mm, 0, 0, berlin) }
+	slot := func(weekday int, start, end string) minuteRange {
+		s, _ := parseClock(start)
+		e, _ := parseClock(end)
+		return minuteRange{weekday, s, e}
+	}
+
+	tests := []struct {
+		name   string
+		weekly []minuteRange
+		start  time.Time
+		end    time.Time
+		want   bool
+	}{
+		{
+			name:   "inside the slot",
+			weekly: []minuteRange{slot(1, "09:00", "17:00")},
+			start:  at(2025, 6, 2, 10, 0), end: at(2025, 6, 2, 11, 0), // Monday
+			want: true,
+		},
+		{
+			name:   "exactly the slot",
+			weekly: []minuteRange{slot(1, "09:00", "17:00")},
+			start:  at(2025, 6, 2, 9, 0), end: at(2025, 6, 2, 17, 0),
+			want: true,
+		},
+		{
+			name:   "runs past the slot end",
+			weekly: []minuteRange{slot(1, "09:00", "17:00")},
+			start:  at(2025, 6, 2, 16, 30), end: at(2025, 6, 2, 17, 30),
+			want: false,
+		},
+		{
+			name:   "other weekday",
+			weekly: []minuteRange{slot(2, "09:00", "17:00")},
+			start:  at(2025, 6, 2, 10, 0), end: at(2025, 6, 2, 11, 0),
+			want: false,
+		},
+		{
+			name:   "adjacent slots are not merged",
+			weekly: []minuteRange{slot(1, "09:00", "12:00"), slot(1, "12:00", "17:00")},
+			start:  at(2025, 6, 2, 11, 0), end: at(2025, 6, 2, 13, 0),
+			want: false,
+		},
+		{
+			name:   "ends at midnight",
+			weekly: []minuteRange{slot(5, "20:00", "24:00")},
+			start:  at(2025, 6, 6, 22, 0), end: at(2025, 6, 7, 0, 0), // Friday → Saturday 00:00
+			want: true,
+		},
+		{
+			name:   "crosses midnight",
+			weekly: []minuteRange{slot(5, "20:00", "24:00"), slot(6, "00:00", "24:00")},
+			start:  at(2025, 6, 6, 23, 0), end: at(2025, 6, 7, 0, 30),
+			want: false,
+		},
+		{
+			// 2025-03-30 02:00 → 03:00：一小時的工作在牆上跨兩小時
+			name:   "DST start counts wall-clock minutes",
+			weekly: []minuteRange{slot(7, "01:00", "03:00")},
+			start:  at(2025, 3, 30, 1, 30), end: at(2025, 3, 30, 1, 30).Add(time.Hour), // 03:30 local
+			want: false,
+		},
+		{
+			name:   "DST start inside a longer slot",
+			weekly: []minuteRange{slot(7, "01:00", "05:00")},
+			start:  at(2025, 3, 30, 1, 30), end: at(2025, 3, 30, 1, 30).Add(time.Hour),
+			want: true,
+		},
+		{
+			name:   "DST end repeats an hour",
+			weekly: []minuteRange{slot(7, "02:00", "03:00")},
+			start:  time.Date(2025, 10, 26, 0, 0, 0, 0, time.UTC), end: time.Date(2025, 10, 26, 1, 0, 0, 0, time.UTC), // 02:00 CEST → 02:00 CET
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs := helperSchedule{loc: berlin, weekly: tt.weekly}
+			if got := hs.withinWeekly(tt.start, tt.end); got != tt.want {
+				t.Errorf("withinWeekly(%s, %s) = %v, want %v", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleConflicts(t *testing.T) {
+	t.Setenv("TRAVEL_BUFFER_MINUTES", "30")
+	berlin := mustLoc(t, "Europe/Berlin")
+	at := func(hh, mm int) *time.Time {
+		v := time.Date(2025, 6, 2, hh, mm, 0, 0, berlin)
+		return &v
+	}
+	now := *at(7, 0)
+	hs := helperSchedule{loc: berlin, busy: []ScheduleConflict{
+		{TaskID: "busy", Start: *at(10, 0), End: *at(11, 0)},
+	}}
+
+	tests := []struct {
+		name string
+		task Task
+		want bool
+	}{
+		{"overlapping", Task{ID: "t", ScheduledAt: at(10, 30), EstimatedMinutes: 60}, true},
+		{"inside the travel buffer after", Task{ID: "t", ScheduledAt: at(11, 20), EstimatedMinutes: 30}, true},
+		{"exactly the travel buffer after", Task{ID: "t", ScheduledAt: at(11, 30), EstimatedMinutes: 30}, false},
+		{"inside the travel buffer before", Task{ID: "t", ScheduledAt: at(9, 0), EstimatedMinutes: 45}, true},
+		{"exactly the travel buffer before", Task{ID: "t", ScheduledAt: at(9, 0), EstimatedMinutes: 30}, false},
+		{"window covering the busy task", Task{ID: "t", ScheduledAt: at(7, 0), WindowStart: at(7, 0), WindowEnd: at(12, 0), EstimatedMinutes: 30}, true},
+		{"the busy task itself", Task{ID: "busy", ScheduledAt: at(10, 0), EstimatedMinutes: 60}, false},
+		{"unscheduled", Task{ID: "t", EstimatedMinutes: 60}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := len(hs.conflicts(tt.task, now)) > 0; got != tt.want {
+				t.Errorf("conflict = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	task := Task{ID: "t", ScheduledAt: at(10, 30), EstimatedMinutes: 60}
+	if _, code, _ := hs.checkScheduleFit(task, false, now); code != http.StatusConflict {
+		t.Errorf("checkScheduleFit without allow_conflicts: code %d, want 409", code)
+	}
+	warnings, code, _ := hs.checkScheduleFit(task, true, now)
+	if code != 0 || len(warnings) != 1 {
+		t.Errorf("checkScheduleFit with allow_conflicts: code %d, warnings %v", code, warnings)
+	}
+}
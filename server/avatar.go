@@ -0,0 +1,180 @@
+package main
+
+// Avatar uploads. The client sends the original photo; the server sniffs the
+// type, re-encodes it (drops EXIF) into fixed square sizes and sets
+// profiles.avatar_url itself — clients can no longer point it at arbitrary
+// URLs (hotlinks, tracking pixels).
+// Layout: avatars/<uid>/<random>/{sm,md,lg}.jpg, served by GET /media/avatars/...
+// ENV:
+//   PUBLIC_BASE_URL=http://localhost:8080   (prefix for avatar_url)
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxAvatarBytes   = 8 << 20
+	minAvatarSide    = 64
+	avatarDefaultKey = "md" // avatar_url 指向的尺寸
+)
+
+// avatarSizes: edge length in pixels of each stored variant.
+var avatarSizes = map[string]int{"sm": 96, "md": 256, "lg": 512}
+
+func publicBaseURL() string {
+	return strings.TrimRight(envOr("PUBLIC_BASE_URL", "http://localhost:8080"), "/")
+}
+
+// avatarURLs maps size name → public URL for an avatar prefix.
+func avatarURLs(prefix string) map[string]string {
+	out := map[string]string{}
+	if prefix == "" {
+		return out
+	}
+	for name := range avatarSizes {
+		out[name] = publicBaseURL() + "/media/" + prefix + "/" + name + ".jpg"
+	}
+	return out
+}
+
+func deleteAvatarBlobs(c *gin.Context, prefix string) {
+	if prefix == "" {
+		return
+	}
+	for name := range avatarSizes {
+		if err := blobs.Delete(c.Request.Context(), prefix+"/"+name+".jpg"); err != nil {
+			log.Printf("[avatar] delete %s: %v", prefix, err)
+		}
+	}
+}
+
+// POST /profile/avatar (multipart, field "avatar": jpeg/png/gif)
+func uploadMyAvatar(c *gin.Context) {
+	uid := c.GetString("uid")
+	email := c.GetString("email")
+	ctx := c.Request.Context()
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarBytes+1<<20)
+	fh, err := c.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "avatar file required"})
+		return
+	}
+	if fh.Size > maxAvatarBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "avatar too large"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid avatar"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxAvatarBytes+1))
+	f.Close()
+	if err != nil || len(data) > maxAvatarBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid avatar"})
+		return
+	}
+	// 以內容判斷型別，不信任副檔名 / Content-Type
+	if !imageTypes[http.DetectContentType(data)] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "avatar must be jpeg, png or gif"})
+		return
+	}
+	img, err := decodeImage(data, minAvatarSide)
+	if errors.Is(err, errImageTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image dimensions too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image (min 64x64)"})
+		return
+	}
+
+	prefix := newBlobKey("avatars/"+uid, "")
+	for name, size := range avatarSizes {
+		out, err := encodeJPEG(squareThumbnail(img, size))
+		if err == nil {
+			err = blobs.Put(ctx, prefix+"/"+name+".jpg", bytes.NewReader(out), "image/jpeg")
+		}
+		if err != nil {
+			deleteAvatarBlobs(c, prefix)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
+			return
+		}
+	}
+
+	// 確保 profile 存在，再換 key 並取回舊的以便清掉舊檔
+	urls := avatarURLs(prefix)
+	var old string
+	_, err = db.Exec(ctx, `
+    insert into public.profiles(user_id,email,name,phone,city,avatar_url,bio,created_at,updated_at)
+    values ($1,$2,$3,'','','','',now(),now())
+    on conflict (user_id) do nothing
+  `, uid, email, deriveName(email))
+	if err == nil {
+		err = db.QueryRow(ctx, `
+      update public.profiles p
+      set avatar_key=$2, avatar_url=$3, updated_at=now()
+      from (select avatar_key from public.profiles where user_id=$1 for update) old
+      where p.user_id=$1
+      returning old.avatar_key
+    `, uid, prefix, urls[avatarDefaultKey]).Scan(&old)
+	}
+	if err != nil {
+		deleteAvatarBlobs(c, prefix)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	deleteAvatarBlobs(c, old)
+	c.JSON(http.StatusOK, gin.H{"avatar_url": urls[avatarDefaultKey], "sizes": urls})
+}
+
+// DELETE /profile/avatar
+func deleteMyAvatar(c *gin.Context) {
+	uid := c.GetString("uid")
+	var old string
+	err := db.QueryRow(c.Request.Context(), `
+    update public.profiles p
+    set avatar_key='', avatar_url='', updated_at=now()
+    from (select avatar_key from public.profiles where user_id=$1 for update) old
+    where p.user_id=$1
+    returning old.avatar_key
+  `, uid).Scan(&old)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	deleteAvatarBlobs(c, old)
+	c.Status(http.StatusNoContent)
+}
+
+// GET /media/avatars/*path — public, no auth (avatars are shown to everyone).
+// Keys contain a random segment and are never reused, so responses are
+// cacheable forever.
+func getAvatarMedia(c *gin.Context) {
+	key := "avatars/" + strings.TrimPrefix(c.Param("path"), "/")
+	if strings.Contains(key, "..") || !strings.HasSuffix(key, ".jpg") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	rc, err := blobs.Get(c.Request.Context(), key)
+	if errors.Is(err, errBlobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
+		return
+	}
+	defer rc.Close()
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, -1, "image/jpeg", rc, nil)
+}
@@ -0,0 +1,126 @@
+package main
+
+// Blob storage for user-uploaded files (receipts, photos, ...).
+// Handlers only talk to the BlobStore interface so the backend can be
+// swapped without touching business code. Default: local filesystem.
+// ENV:
+//   BLOB_STORE=local|s3           (default local)
+//   BLOB_STORE_DIR=./data/blobs   (local backend root)
+//   S3_ENDPOINT, S3_BUCKET, S3_REGION, S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY,
+//   S3_FORCE_PATH_STYLE           (s3 backend, see s3store.go)
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var blobs BlobStore
+
+var errBlobNotFound = errors.New("blob not found")
+
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+func initBlobStore() {
+	switch backend := envOr("BLOB_STORE", "local"); backend {
+	case "local":
+	case "s3":
+		s, err := newS3BlobStore()
+		if err != nil {
+			log.Fatalf("[blob] %v", err)
+		}
+		blobs = s
+		log.Printf("[blob] s3 store %s/%s", s.endpoint, s.bucket)
+		return
+	default:
+		log.Fatalf("[blob] unknown BLOB_STORE %q", backend)
+	}
+	dir := strings.TrimSpace(os.Getenv("BLOB_STORE_DIR"))
+	if dir == "" {
+		dir = "./data/blobs"
+	}
+	blobs = &localBlobStore{root: dir}
+	log.Printf("[blob] local store at %s", dir)
+}
+
+// newBlobKey builds "<prefix>/<random hex><ext>". Keys are never derived from
+// user-supplied file names.
+func newBlobKey(prefix, ext string) string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return prefix + "/" + hex.EncodeToString(b[:]) + ext
+}
+
+// -------- Local filesystem backend --------
+
+type localBlobStore struct {
+	root string
+}
+
+// path maps a key to a file below root; ".." segments cannot escape it.
+func (s *localBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("empty blob key")
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+func (s *localBlobStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// 先寫暫存檔再 rename，避免讀到寫一半的檔案
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *localBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return f, err
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+// User blocking. A block works both ways: neither user sees the other's
+// open tasks (available list, search, task detail) and neither can accept
+// the other's tasks. Open tasks the pair already shares are not changed but
+// carry a warning for the requester, who can ask support to reassign them.
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const blockedPairWarning = "blocked_pair: you and the assignee have blocked each other; contact support to reassign"
+
+func isBlockedPair(ctx context.Context, q dbtx, a, b string) (bool, error) {
+	var blocked bool
+	err := q.QueryRow(ctx, `
+    select exists (
+      select 1 from public.user_blocks
+      where (blocker=$1 and blocked=$2) or (blocker=$2 and blocked=$1)
+    )`, a, b).Scan(&blocked)
+	return blocked, err
+}
+
+// flagBlockedAssignees adds blockedPairWarning to the requester's open
+// tasks whose assignee is in a block with them.
+func flagBlockedAssignees(ctx context.Context, me string, tasks []Task) {
+	var ids []string
+	for _, t := range tasks {
+		if t.Requester == me && t.Status == "open" && t.AssignedTo != "" {
+			ids = append(ids, t.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	rows, err := db.Query(ctx, `
+    select t.id from public.tasks t
+    where t.id::text = any($2) and exists (
+      select 1 from public.user_blocks b
+      where (b.blocker=$1 and b.blocked=t.assigned_to) or (b.blocker=t.assigned_to and b.blocked=$1)
+    )`, me, ids)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	flagged := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			flagged[id] = true
+		}
+	}
+	for i := range tasks {
+		if flagged[tasks[i].ID] {
+			tasks[i].Warnings = append(tasks[i].Warnings, blockedPairWarning)
+		}
+	}
+}
+
+// -------- Block handlers --------
+
+// POST /users/:id/block — returns the open tasks the pair still shares.
+func blockUser(c *gin.Context) {
+	target := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	if target == me {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot block yourself"})
+		return
+	}
+	var exists bool
+	_ = db.QueryRow(ctx, `select exists (select 1 from public.profiles where user_id=$1)`, target).Scan(&exists)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if _, err := db.Exec(ctx, `
+    insert into public.user_blocks(blocker,blocked) values ($1,$2) on conflict do nothing
+  `, me, target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	_, _ = db.Exec(ctx, `
+    delete from public.user_favorites where (user_id=$1 and helper_id=$2) or (user_id=$2 and helper_id=$1)
+  `, me, target)
+
+	rows, err := db.Query(ctx, `
+    select id from public.tasks
+    where status='open' and ((requester=$1 and assigned_to=$2) or (requester=$2 and assigned_to=$1))
+  `, me, target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	shared := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		shared = append(shared, id)
+	}
+	c.JSON(http.StatusOK, gin.H{"blocked": target, "shared_open_tasks": shared})
+}
+
+// DELETE /users/:id/block
+func unblockUser(c *gin.Context) {
+	if _, err := db.Exec(c.Request.Context(), `
+    delete from public.user_blocks where blocker=$1 and blocked=$2
+  `, c.GetString("uid"), c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GET /me/blocks — users I blocked (not those who blocked me).
+func listMyBlocks(c *gin.Context) {
+	rows, err := db.Query(c.Request.Context(), `
+    select b.blocked, coalesce(p.name, ''), b.created_at
+    from public.user_blocks b
+    left join public.profiles p on p.user_id = b.blocked
+    where b.blocker=$1 order by b.created_at desc
+  `, c.GetString("uid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	type blockedUser struct {
+		ID        string    `json:"id"`
+		Name      string    `json:"name"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	out := []blockedUser{}
+	for rows.Next() {
+		var b blockedUser
+		if err := rows.Scan(&b.ID, &b.Name, &b.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, b)
+	}
+	c.JSON(http.StatusOK, out)
+}
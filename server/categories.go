@@ -0,0 +1,23 @@
+package main
+
+// Task categories and their per-category rules.
+
+type taskCategory struct {
+	// MinVerificationLevel an assignee needs to accept (see verification.go).
+	MinVerificationLevel int
+	// MinReliability score an assignee needs (see reliability.go); 0 = none.
+	MinReliability float64
+	// RequireRole an assignee needs in public.user_roles, granted by an
+	// operator through PUT /admin/users/:id/roles (see rbac.go); "" = none.
+	RequireRole string
+	// RequireProofPhoto: completeTask needs at least one "proof" attachment
+	// from the assignee (see attachments.go). Off by default: turning it on
+	// also applies to tasks already in progress, whose helpers were never
+	// asked for a photo.
+	RequireProofPhoto bool
+}
+
+var taskCategories = map[string]taskCategory{
+	"task":      {MinVerificationLevel: verifyEmail, MinReliability: 40},
+	"companion": {MinVerificationLevel: verifyID, MinReliability: 60, RequireRole: roleHelperVerified}, // 需與陌生人見面，幫手須經人工審核
+}
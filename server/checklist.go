@@ -0,0 +1,367 @@
+package main
+
+// Task checklists: ordered sub-steps ("pick up prescription", "buy milk").
+// The requester writes the items, the assignee ticks them off. With
+// tasks.require_checklist set, completeTask refuses until every item is checked.
+// A checked item is frozen (no edits, no delete), and once the assignee has
+// checked anything the requester can no longer append items: the assignee
+// signed off on a list, not on whatever it turns into later.
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxChecklistItems    = 50
+	maxChecklistItemText = 200
+)
+
+type ChecklistItem struct {
+	ID        string     `json:"id"`
+	TaskID    string     `json:"task_id"`
+	Position  int        `json:"position"`
+	Text      string     `json:"text"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	CheckedBy string     `json:"checked_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Checklist is the getTask view: items plus progress.
+type Checklist struct {
+	Done  int             `json:"done"`
+	Total int             `json:"total"`
+	Items []ChecklistItem `json:"items"`
+}
+
+const checklistColumns = `id,task_id,position,text,checked_at,checked_by,created_at`
+
+func scanChecklistItem(rows interface{ Scan(dest ...any) error }) (ChecklistItem, error) {
+	var it ChecklistItem
+	err := rows.Scan(&it.ID, &it.TaskID, &it.Position, &it.Text, &it.CheckedAt, &it.CheckedBy, &it.CreatedAt)
+	return it, err
+}
+
+// normalizeChecklist trims items and drops empty ones.
+func normalizeChecklist(items []string) ([]string, error) {
+	out := []string{}
+	for _, s := range items {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if len([]rune(s)) > maxChecklistItemText {
+			return nil, errors.New("checklist items must be at most 200 characters")
+		}
+		out = append(out, s)
+	}
+	if len(out) > maxChecklistItems {
+		return nil, errors.New("at most 50 checklist items")
+	}
+	return out, nil
+}
+
+func insertChecklistItems(ctx context.Context, q dbtx, taskID string, items []string) error {
+	for i, s := range items {
+		if _, err := q.Exec(ctx, `
+      insert into public.task_checklist_items(task_id,position,text) values ($1,$2,$3)
+    `, taskID, i+1, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadChecklist(ctx context.Context, q dbtx, taskID string) (Checklist, error) {
+	cl := Checklist{Items: []ChecklistItem{}}
+	rows, err := q.Query(ctx, `
+    select `+checklistColumns+` from public.task_checklist_items
+    where task_id=$1 order by position asc, created_at asc
+  `, taskID)
+	if err != nil {
+		return cl, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		it, err := scanChecklistItem(rows)
+		if err != nil {
+			return cl, err
+		}
+		cl.Total++
+		if it.CheckedAt != nil {
+			cl.Done++
+		}
+		cl.Items = append(cl.Items, it)
+	}
+	return cl, rows.Err()
+}
+
+// checklistIncomplete: the task requires a finished checklist and some item
+// is still unchecked.
+func checklistIncomplete(ctx context.Context, q dbtx, taskID string) (bool, error) {
+	var pending bool
+	err := q.QueryRow(ctx, `
+    select t.require_checklist and exists (
+      select 1 from public.task_checklist_items i where i.task_id=t.id and i.checked_at is null
+    )
+    from public.tasks t where t.id=$1
+  `, taskID).Scan(&pending)
+	return pending, err
+}
+
+// checklistItemMissing tells apart the two reasons a guarded item update hit
+// no row: 404 for a missing item, 409 for a checked (frozen) one.
+func checklistItemMissing(c *gin.Context) {
+	var checked bool
+	err := db.QueryRow(c.Request.Context(), `
+    select checked_at is not null from public.task_checklist_items where task_id=$1 and id::text=$2
+  `, c.Param("id"), c.Param("itemId")).Scan(&checked)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "checked items cannot be changed"})
+	}
+}
+
+// checklistTask loads a task for checklist edits: 404 / 403 / 400 handled here.
+func checklistTask(c *gin.Context, wantRequester bool) bool {
+	requester, assignedTo, status, _, err := taskParticipants(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return false
+	}
+	me := c.GetString("uid")
+	if wantRequester && me != requester {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only requester can edit the checklist"})
+		return false
+	}
+	if !wantRequester && me != assignedTo {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only assignee can check items"})
+		return false
+	}
+	if status != "open" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task not open"})
+		return false
+	}
+	return true
+}
+
+// -------- Handlers --------
+
+// GET /tasks/:id/checklist — requester and assignee; others see it in getTask.
+func getChecklist(c *gin.Context) {
+	ctx := c.Request.Context()
+	me := c.GetString("uid")
+	requester, assignedTo, _, _, err := taskParticipants(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if me != requester && me != assignedTo {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+		return
+	}
+	cl, err := loadChecklist(ctx, db, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, cl)
+}
+
+// POST /tasks/:id/checklist {text} — appends an item (requester) until the
+// first item is checked.
+func addChecklistItem(c *gin.Context) {
+	if !checklistTask(c, true) {
+		return
+	}
+	var in struct {
+		Text string `json:"text"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	items, err := normalizeChecklist([]string{in.Text})
+	if err != nil || len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text required (max 200 characters)"})
+		return
+	}
+	ctx := c.Request.Context()
+	it, err := scanChecklistItem(db.QueryRow(ctx, `
+    insert into public.task_checklist_items(task_id,position,text)
+    select $1, coalesce(max(position), 0) + 1, $2
+    from public.task_checklist_items where task_id=$1
+    having count(*) < $3 and count(checked_at) = 0
+    returning `+checklistColumns, c.Param("id"), items[0], maxChecklistItems))
+	if errors.Is(err, pgx.ErrNoRows) {
+		var started bool
+		if err := db.QueryRow(ctx, `
+      select exists (select 1 from public.task_checklist_items where task_id=$1 and checked_at is not null)
+    `, c.Param("id")).Scan(&started); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if started {
+			c.JSON(http.StatusConflict, gin.H{"error": "items cannot be added once checking has started"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "at most 50 checklist items"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, it)
+}
+
+// PATCH /tasks/:id/checklist/:itemId {text} (requester) — unchecked items only.
+func updateChecklistItem(c *gin.Context) {
+	if !checklistTask(c, true) {
+		return
+	}
+	var in struct {
+		Text string `json:"text"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	items, err := normalizeChecklist([]string{in.Text})
+	if err != nil || len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text required (max 200 characters)"})
+		return
+	}
+	it, err := scanChecklistItem(db.QueryRow(c.Request.Context(), `
+    update public.task_checklist_items set text=$3
+    where task_id=$1 and id::text=$2 and checked_at is null
+    returning `+checklistColumns, c.Param("id"), c.Param("itemId"), items[0]))
+	if errors.Is(err, pgx.ErrNoRows) {
+		checklistItemMissing(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, it)
+}
+
+// DELETE /tasks/:id/checklist/:itemId (requester) — unchecked items only.
+func deleteChecklistItem(c *gin.Context) {
+	if !checklistTask(c, true) {
+		return
+	}
+	tag, err := db.Exec(c.Request.Context(), `
+    delete from public.task_checklist_items where task_id=$1 and id::text=$2 and checked_at is null
+  `, c.Param("id"), c.Param("itemId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		checklistItemMissing(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// PUT /tasks/:id/checklist/order {item_ids: [...]} — must list every item once.
+func reorderChecklist(c *gin.Context) {
+	if !checklistTask(c, true) {
+		return
+	}
+	taskID := c.Param("id")
+	ctx := c.Request.Context()
+	var in struct {
+		ItemIDs []string `json:"item_ids"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var total int
+	if err := tx.QueryRow(ctx, `
+    select count(*) from (
+      select 1 from public.task_checklist_items where task_id=$1 for update
+    ) s`, taskID).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	seen := map[string]bool{}
+	for _, id := range in.ItemIDs {
+		seen[id] = true
+	}
+	if len(in.ItemIDs) != total || len(seen) != total {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "item_ids must list every checklist item exactly once"})
+		return
+	}
+	for i, id := range in.ItemIDs {
+		tag, err := tx.Exec(ctx, `
+      update public.task_checklist_items set position=$3 where task_id=$1 and id::text=$2
+    `, taskID, id, i+1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "item_ids must list every checklist item exactly once"})
+			return
+		}
+	}
+	cl, err := loadChecklist(ctx, tx, taskID)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, cl)
+}
+
+// POST /tasks/:id/checklist/:itemId/check (assignee)
+func checkChecklistItem(c *gin.Context) {
+	setChecklistItemChecked(c, true)
+}
+
+// DELETE /tasks/:id/checklist/:itemId/check (assignee)
+func uncheckChecklistItem(c *gin.Context) {
+	setChecklistItemChecked(c, false)
+}
+
+func setChecklistItemChecked(c *gin.Context, checked bool) {
+	if !checklistTask(c, false) {
+		return
+	}
+	// 已勾的再勾一次不更新時間戳
+	it, err := scanChecklistItem(db.QueryRow(c.Request.Context(), `
+    update public.task_checklist_items
+    set checked_at = case when $3 then coalesce(checked_at, now()) end,
+        checked_by = case when $3 then $4 else '' end
+    where task_id=$1 and id::text=$2
+    returning `+checklistColumns, c.Param("id"), c.Param("itemId"), checked, c.GetString("uid")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.JSON(http.StatusOK, it)
+}
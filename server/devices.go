@@ -0,0 +1,199 @@
+package main
+
+// Session and device management.
+// - authMiddleware records (user, X-Device-ID) with user agent, IP and the
+//   Supabase session_id claim; writes are throttled per device.
+// - Revoking a device deny-lists its session_id. authMiddleware rejects
+//   deny-listed sessions. The list is cached in memory and reloaded every
+//   sessionDenylistRefresh, so other instances pick up revocations within
+//   that interval; the instance that handles the revoke applies it at once.
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	deviceTouchInterval    = time.Minute
+	sessionDenylistRefresh = 30 * time.Second
+	sessionDenylistTTL     = 30 * 24 * time.Hour // 超過這段時間的 session 早已失效
+	maxDeviceIDLen         = 128
+)
+
+var (
+	revokedMu       sync.RWMutex
+	revokedSessions = map[string]bool{}
+
+	deviceTouchMu sync.Mutex
+	deviceTouched = map[string]time.Time{}
+)
+
+type Device struct {
+	ID        string     `json:"id"`
+	DeviceID  string     `json:"device_id"`
+	UserAgent string     `json:"user_agent"`
+	IP        string     `json:"ip"`
+	FirstSeen time.Time  `json:"first_seen"`
+	LastSeen  time.Time  `json:"last_seen"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Current   bool       `json:"current"`
+}
+
+func isSessionRevoked(sessionID string) bool {
+	if sessionID == "" {
+		return false
+	}
+	revokedMu.RLock()
+	defer revokedMu.RUnlock()
+	return revokedSessions[sessionID]
+}
+
+func loadRevokedSessions(ctx context.Context) error {
+	rows, err := db.Query(ctx, `select session_id from public.revoked_sessions where revoked_at > $1`,
+		time.Now().Add(-sessionDenylistTTL))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	next := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		next[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	revokedMu.Lock()
+	revokedSessions = next
+	revokedMu.Unlock()
+	return nil
+}
+
+func sessionDenylistLoop() {
+	t := time.NewTicker(sessionDenylistRefresh)
+	defer t.Stop()
+	for ; ; <-t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := loadRevokedSessions(ctx); err != nil {
+			log.Printf("[sessions] reload deny-list: %v", err)
+		}
+		cancel()
+	}
+}
+
+// touchDevice upserts the caller's device row (at most once per
+// deviceTouchInterval per device+session). Revoked rows are left alone:
+// revocation is final for that device id, a later login shows up only under
+// a new X-Device-ID.
+func touchDevice(c *gin.Context, uid, sessionID string) {
+	deviceID := strings.TrimSpace(c.GetHeader("X-Device-ID"))
+	if deviceID == "" || len(deviceID) > maxDeviceIDLen {
+		return
+	}
+	key := uid + "|" + deviceID + "|" + sessionID
+	now := time.Now()
+	deviceTouchMu.Lock()
+	if last, ok := deviceTouched[key]; ok && now.Sub(last) < deviceTouchInterval {
+		deviceTouchMu.Unlock()
+		return
+	}
+	deviceTouched[key] = now
+	if len(deviceTouched) > 10000 { // 簡單防爆：整個清掉重來
+		deviceTouched = map[string]time.Time{key: now}
+	}
+	deviceTouchMu.Unlock()
+
+	ua := c.Request.UserAgent()
+	if len(ua) > 512 {
+		ua = ua[:512]
+	}
+	if _, err := db.Exec(c.Request.Context(), `
+    insert into public.devices(user_id,device_id,user_agent,ip,session_id)
+    values ($1,$2,$3,$4,$5)
+    on conflict (user_id, device_id) do update
+    set user_agent=excluded.user_agent, ip=excluded.ip, session_id=excluded.session_id,
+        last_seen=now()
+    where public.devices.revoked_at is null
+  `, uid, deviceID, ua, c.ClientIP(), sessionID); err != nil {
+		log.Printf("[devices] touch: %v", err)
+	}
+}
+
+// -------- Device handlers --------
+
+func listMyDevices(c *gin.Context) {
+	me := c.GetString("uid")
+	current := strings.TrimSpace(c.GetHeader("X-Device-ID"))
+	rows, err := db.Query(c.Request.Context(), `
+    select id, device_id, user_agent, ip, first_seen, last_seen, revoked_at
+    from public.devices where user_id=$1 order by last_seen desc
+  `, me)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []Device{}
+	for rows.Next() {
+		var d Device
+		if err := rows.Scan(&d.ID, &d.DeviceID, &d.UserAgent, &d.IP, &d.FirstSeen, &d.LastSeen, &d.RevokedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		d.Current = current != "" && d.DeviceID == current
+		out = append(out, d)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// revokeMyDevice marks the device revoked and deny-lists its session.
+func revokeMyDevice(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var sessionID string
+	if err := tx.QueryRow(ctx, `
+    update public.devices set revoked_at=now()
+    where id::text=$1 and user_id=$2
+    returning session_id
+  `, c.Param("id"), me).Scan(&sessionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if sessionID != "" {
+		if _, err := tx.Exec(ctx, `
+      insert into public.revoked_sessions(session_id,user_id) values ($1,$2)
+      on conflict (session_id) do nothing
+    `, sessionID, me); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if sessionID != "" {
+		revokedMu.Lock()
+		revokedSessions[sessionID] = true
+		revokedMu.Unlock()
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Helper earnings: labor from closed worklogs (same rounding as getWorklogs)
+// plus tips. Work on completed tasks is "settled", on open ones "pending";
+// cancelled tasks are never paid out, so their worklogs are left out.
+// Approved expenses are reimbursements, reported separately from earnings.
+
+type earningsBucket struct {
+	Period       string `json:"period"`
+	EarnedCents  int    `json:"earned_cents"`
+	SettledCents int    `json:"settled_cents"`
+	PendingCents int    `json:"pending_cents"`
+	Minutes      int    `json:"minutes"`
+}
+
+type earningsTask struct {
+	TaskID        string     `json:"task_id"`
+	Title         string     `json:"title"`
+	Status        string     `json:"status"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	Minutes       int        `json:"minutes"`
+	LaborCents    int        `json:"labor_cents"`
+	TipCents      int        `json:"tip_cents"`
+	ExpensesCents int        `json:"expenses_cents"`
+	Settled       bool       `json:"settled"`
+}
+
+// parseReportRange reads ?from=&to=&tz= (dates as YYYY-MM-DD or RFC3339).
+// Defaults: last 90 days, UTC. `to` is exclusive.
+func parseReportRange(c *gin.Context) (from, to time.Time, loc *time.Location, err error) {
+	loc = time.UTC
+	if tz := strings.TrimSpace(c.Query("tz")); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return from, to, nil, fmt.Errorf("invalid tz")
+		}
+	}
+	parse := func(s string, endOfDay bool) (time.Time, error) {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, nil
+		}
+		d, err := time.ParseInLocation("2006-01-02", s, loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if endOfDay {
+			d = d.AddDate(0, 0, 1)
+		}
+		return d, nil
+	}
+	now := time.Now().In(loc)
+	to = now
+	from = now.AddDate(0, 0, -90)
+	if s := strings.TrimSpace(c.Query("from")); s != "" {
+		if from, err = parse(s, false); err != nil {
+			return from, to, nil, fmt.Errorf("invalid from")
+		}
+	}
+	if s := strings.TrimSpace(c.Query("to")); s != "" {
+		if to, err = parse(s, true); err != nil {
+			return from, to, nil, fmt.Errorf("invalid to")
+		}
+	}
+	if !from.Before(to) {
+		return from, to, nil, fmt.Errorf("from must be before to")
+	}
+	return from, to, loc, nil
+}
+
+// periodKeys returns the day / ISO week / month bucket keys for t.
+func periodKeys(t time.Time) (day, week, month string) {
+	y, w := t.ISOWeek()
+	return t.Format("2006-01-02"), fmt.Sprintf("%d-W%02d", y, w), t.Format("2006-01")
+}
+
+func sortedBuckets(m map[string]*earningsBucket) []earningsBucket {
+	out := make([]earningsBucket, 0, len(m))
+	for _, b := range m {
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Period < out[j].Period })
+	return out
+}
+
+// GET /me/earnings?from=&to=&tz=&format=json|csv
+func getMyEarnings(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	from, to, loc, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tasks := map[string]*earningsTask{}
+	days := map[string]*earningsBucket{}
+	weeks := map[string]*earningsBucket{}
+	months := map[string]*earningsBucket{}
+	add := func(at time.Time, cents, minutes int, settled bool) {
+		d, w, m := periodKeys(at.In(loc))
+		for _, pair := range []struct {
+			set map[string]*earningsBucket
+			key string
+		}{{days, d}, {weeks, w}, {months, m}} {
+			b := pair.set[pair.key]
+			if b == nil {
+				b = &earningsBucket{Period: pair.key}
+				pair.set[pair.key] = b
+			}
+			b.EarnedCents += cents
+			b.Minutes += minutes
+			if settled {
+				b.SettledCents += cents
+			} else {
+				b.PendingCents += cents
+			}
+		}
+	}
+
+	// 工時（依 end_at 歸入期間）
+	rows, err := db.Query(ctx, `
+    select w.task_id, t.title, t.status, t.completed_at, w.end_at,
+           greatest(ceil(extract(epoch from (w.end_at - w.start_at))/60.0), 1)::int,
+           coalesce(t.rate_cents_per_minute, $4)
+    from public.worklogs w join public.tasks t on t.id = w.task_id
+    where w."user"=$1 and w.end_at is not null and w.end_at > w.start_at
+      and w.end_at >= $2 and w.end_at < $3 and t.status <> 'cancelled'
+    order by w.end_at asc
+  `, me, from, to, centsPerMinute)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	for rows.Next() {
+		var taskID, title, status string
+		var completedAt *time.Time
+		var endAt time.Time
+		var minutes, rate int
+		if err := rows.Scan(&taskID, &title, &status, &completedAt, &endAt, &minutes, &rate); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		t := tasks[taskID]
+		if t == nil {
+			t = &earningsTask{TaskID: taskID, Title: title, Status: status, CompletedAt: completedAt, Settled: status == "completed"}
+			tasks[taskID] = t
+		}
+		cents := minutes * rate
+		t.Minutes += minutes
+		t.LaborCents += cents
+		add(endAt, cents, minutes, t.Settled)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	// 小費（依完成時間歸入期間）與已核准代墊費用
+	rows, err = db.Query(ctx, `
+    select t.id, t.title, t.status, t.completed_at, t.tip_cents,
+           coalesce((select sum(e.amount_cents)::int from public.task_expenses e
+                     where e.task_id = t.id and e.status='approved'), 0)
+    from public.tasks t
+    where t.assigned_to=$1 and t.status='completed'
+      and t.completed_at >= $2 and t.completed_at < $3
+  `, me, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	for rows.Next() {
+		var taskID, title, status string
+		var completedAt *time.Time
+		var tip, expenses int
+		if err := rows.Scan(&taskID, &title, &status, &completedAt, &tip, &expenses); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		t := tasks[taskID]
+		if t == nil {
+			t = &earningsTask{TaskID: taskID, Title: title, Status: status, CompletedAt: completedAt, Settled: true}
+			tasks[taskID] = t
+		}
+		t.TipCents = tip
+		t.ExpensesCents = expenses
+		if tip > 0 && completedAt != nil {
+			add(*completedAt, tip, 0, true)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	items := make([]earningsTask, 0, len(tasks))
+	var earned, settled, pending, tips, minutes int
+	for _, t := range tasks {
+		items = append(items, *t)
+		total := t.LaborCents + t.TipCents
+		earned += total
+		tips += t.TipCents
+		minutes += t.Minutes
+		if t.Settled {
+			settled += total
+		} else {
+			pending += total
+		}
+	}
+	// 未完成的在前，其餘依完成時間新到舊
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i].CompletedAt, items[j].CompletedAt
+		if (a == nil) != (b == nil) {
+			return a == nil
+		}
+		if a != nil && !a.Equal(*b) {
+			return a.After(*b)
+		}
+		return items[i].TaskID < items[j].TaskID
+	})
+
+	if c.Query("format") == "csv" {
+		c.Header("Content-Disposition", `attachment; filename="earnings.csv"`)
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		w := csv.NewWriter(c.Writer)
+		_ = w.Write([]string{"task_id", "title", "status", "completed_at", "minutes", "labor_cents", "tip_cents", "expenses_cents", "settled"})
+		for _, t := range items {
+			done := ""
+			if t.CompletedAt != nil {
+				done = t.CompletedAt.In(loc).Format(time.RFC3339)
+			}
+			_ = w.Write([]string{
+				t.TaskID, t.Title, t.Status, done, strconv.Itoa(t.Minutes),
+				strconv.Itoa(t.LaborCents), strconv.Itoa(t.TipCents), strconv.Itoa(t.ExpensesCents),
+				strconv.FormatBool(t.Settled),
+			})
+		}
+		w.Flush()
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from": from.In(loc),
+		"to":   to.In(loc),
+		"tz":   loc.String(),
+		"totals": gin.H{
+			"earned_cents":  earned,
+			"settled_cents": settled,
+			"pending_cents": pending,
+			"tips_cents":    tips,
+			"minutes":       minutes,
+			"hours":         float64(minutes) / 60,
+		},
+		"by_day":   sortedBuckets(days),
+		"by_week":  sortedBuckets(weeks),
+		"by_month": sortedBuckets(months),
+		"tasks":    items,
+	})
+}
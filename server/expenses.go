@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Expense reimbursement: the assignee submits out-of-pocket costs (with a
+// receipt file), the requester approves or rejects them while the task is
+// open — completion waits for every pending expense, so the invoice and the
+// payout see the final amounts. Approved amounts are added to the task
+// settlement next to total_cost_cents.
+
+const maxReceiptBytes = 10 << 20 // 10 MB
+
+// Receipt content types we accept, keyed by sniffed MIME → stored extension.
+var receiptTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+type Expense struct {
+	ID          string     `json:"id"`
+	TaskID      string     `json:"task_id"`
+	SubmittedBy string     `json:"submitted_by"`
+	AmountCents int        `json:"amount_cents"`
+	Description string     `json:"description"`
+	HasReceipt  bool       `json:"has_receipt"`
+	Status      string     `json:"status"` // pending | approved | rejected
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+const expenseColumns = `id,task_id,submitted_by,amount_cents,description,receipt_key <> '',status,decided_at,created_at,updated_at`
+
+func scanExpense(rows interface{ Scan(dest ...any) error }) (Expense, error) {
+	var e Expense
+	err := rows.Scan(
+		&e.ID, &e.TaskID, &e.SubmittedBy, &e.AmountCents, &e.Description,
+		&e.HasReceipt, &e.Status, &e.DecidedAt, &e.CreatedAt, &e.UpdatedAt,
+	)
+	return e, err
+}
+
+// approvedExpensesCents: sum of approved expenses for settlement.
+func approvedExpensesCents(ctx context.Context, taskID string) int {
+	var cents int
+	_ = db.QueryRow(ctx, `
+    select coalesce(sum(amount_cents)::int, 0)
+    from public.task_expenses where task_id=$1 and status='approved'
+  `, taskID).Scan(&cents)
+	return cents
+}
+
+// -------- Expense handlers --------
+// Only the assignee of an open task can submit; only the requester decides.
+
+func createExpense(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+	// 先限制 body 大小，PostForm 會解析整個 multipart
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxReceiptBytes+1<<20)
+
+	var assignedTo, status string
+	if err := db.QueryRow(ctx, `select assigned_to,status from public.tasks where id=$1`, taskID).Scan(&assignedTo, &status); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if assignedTo != me {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only assignee can submit expenses"})
+		return
+	}
+	if status != "open" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task not open"})
+		return
+	}
+
+	amount, err := strconv.Atoi(strings.TrimSpace(c.PostForm("amount_cents")))
+	if err != nil || amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount_cents must be a positive integer"})
+		return
+	}
+	desc := strings.TrimSpace(c.PostForm("description"))
+	if desc == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "description required"})
+		return
+	}
+
+	// 收據為必填：讀進記憶體後以內容判斷型別，不信任 client 給的 Content-Type
+	fh, err := c.FormFile("receipt")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "receipt file required"})
+		return
+	}
+	if fh.Size > maxReceiptBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "receipt too large"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid receipt"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxReceiptBytes+1))
+	f.Close()
+	if err != nil || len(data) > maxReceiptBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid receipt"})
+		return
+	}
+	ctype := http.DetectContentType(data)
+	ext, ok := receiptTypes[ctype]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "receipt must be jpeg, png or pdf"})
+		return
+	}
+
+	key := newBlobKey("receipts/"+taskID, ext)
+	if err := blobs.Put(ctx, key, bytes.NewReader(data), ctype); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
+		return
+	}
+
+	row := db.QueryRow(ctx, `
+    insert into public.task_expenses
+      (task_id,submitted_by,amount_cents,description,receipt_key,receipt_content_type,status)
+    values ($1,$2,$3,$4,$5,$6,'pending')
+    returning `+expenseColumns, taskID, me, amount, desc, key, ctype)
+	e, err := scanExpense(row)
+	if err != nil {
+		_ = blobs.Delete(ctx, key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, e)
+}
+
+func listExpenses(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var requester, assignedTo string
+	if err := db.QueryRow(ctx, `select requester,assigned_to from public.tasks where id=$1`, taskID).Scan(&requester, &assignedTo); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if requester != me && assignedTo != me {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+		return
+	}
+
+	rows, err := db.Query(ctx, `
+    select `+expenseColumns+`
+    from public.task_expenses where task_id=$1 order by created_at asc
+  `, taskID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+
+	items := []Expense{}
+	pending, approved := 0, 0
+	for rows.Next() {
+		e, err := scanExpense(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		switch e.Status {
+		case "pending":
+			pending += e.AmountCents
+		case "approved":
+			approved += e.AmountCents
+		}
+		items = append(items, e)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":          items,
+		"pending_cents":  pending,
+		"approved_cents": approved,
+	})
+}
+
+func approveExpense(c *gin.Context) { decideExpense(c, "approved") }
+func rejectExpense(c *gin.Context)  { decideExpense(c, "rejected") }
+
+func decideExpense(c *gin.Context, decision string) {
+	taskID := c.Param("id")
+	expenseID := c.Param("expenseId")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var requester, status string
+	if err := db.QueryRow(ctx, `select requester,status from public.tasks where id=$1`, taskID).Scan(&requester, &status); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if requester != me {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only requester can review expenses"})
+		return
+	}
+	// 完成後發票與提領都已定案，不能再改金額
+	if status != "open" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task not open"})
+		return
+	}
+
+	// 只能處理 pending 的項目（條件寫在 update 內避免重複審核，也擋住同時完成任務）
+	row := db.QueryRow(ctx, `
+    update public.task_expenses e
+    set status=$1, decided_at=now(), updated_at=now()
+    where e.id=$2 and e.task_id=$3 and e.status='pending'
+      and exists (select 1 from public.tasks t where t.id=e.task_id and t.status='open')
+    returning `+expenseColumns, decision, expenseID, taskID)
+	e, err := scanExpense(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expense not pending"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+func getExpenseReceipt(c *gin.Context) {
+	taskID := c.Param("id")
+	expenseID := c.Param("expenseId")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var requester, assignedTo string
+	if err := db.QueryRow(ctx, `select requester,assigned_to from public.tasks where id=$1`, taskID).Scan(&requester, &assignedTo); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if requester != me && assignedTo != me {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+		return
+	}
+
+	var key, ctype string
+	if err := db.QueryRow(ctx, `
+    select receipt_key, receipt_content_type from public.task_expenses where id=$1 and task_id=$2
+  `, expenseID, taskID).Scan(&key, &ctype); err != nil || key == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	rc, err := blobs.Get(ctx, key)
+	if errors.Is(err, errBlobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
+		return
+	}
+	defer rc.Close()
+
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, -1, ctype, rc, nil)
+}
@@ -0,0 +1,202 @@
+package main
+
+// Favorite helpers and direct offers.
+// createTask accepts "direct_to" (helper ids) and/or "direct_to_favorites";
+// those helpers get the task exclusively for exclusive_minutes (default
+// defaultExclusiveMinutes), after which it falls back to the public pool.
+// Nothing needs to run at the deadline: visibility and acceptTask compare
+// exclusive_until with now().
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultExclusiveMinutes = 60
+	maxExclusiveMinutes     = 24 * 60
+	maxDirectTargets        = 10
+)
+
+var errInvalidTarget = errors.New("invalid direct offer target")
+
+// resolveDirectTargets validates the requested helpers (dedup, not self,
+// existing, not blocked) and expands favorites.
+func resolveDirectTargets(ctx context.Context, requester string, ids []string, favorites bool) ([]string, error) {
+	seen := map[string]bool{}
+	var out []string
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	for _, id := range ids {
+		add(id)
+	}
+	if favorites {
+		// 可靠度高的優先（超過 maxDirectTargets 時才有差）
+		rows, err := db.Query(ctx, `
+      select f.helper_id from public.user_favorites f
+      left join public.reliability_scores r on r.user_id = f.helper_id
+      where f.user_id=$1
+      order by r.score desc nulls last, f.created_at
+      limit $2`, requester, maxDirectTargets)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			add(id)
+		}
+		rows.Close()
+	}
+	if len(out) > maxDirectTargets {
+		return nil, errInvalidTarget
+	}
+	for _, id := range out {
+		if id == requester {
+			return nil, errInvalidTarget
+		}
+		var ok bool
+		if err := db.QueryRow(ctx, `
+      select exists (select 1 from public.profiles where user_id=$2)
+         and not exists (
+           select 1 from public.user_blocks
+           where (blocker=$1 and blocked=$2) or (blocker=$2 and blocked=$1)
+         )`, requester, id).Scan(&ok); err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errInvalidTarget
+		}
+	}
+	return out, nil
+}
+
+// directOfferAllows reports whether helper may see/accept a task that is
+// (or was) a direct offer.
+func directOfferAllows(ctx context.Context, q dbtx, taskID, helper string, exclusiveUntil *time.Time) (bool, error) {
+	if exclusiveUntil == nil || !time.Now().Before(*exclusiveUntil) {
+		return true, nil
+	}
+	var ok bool
+	err := q.QueryRow(ctx, `
+    select exists (select 1 from public.task_targets where task_id=$1 and helper_id=$2)
+  `, taskID, helper).Scan(&ok)
+	return ok, err
+}
+
+// -------- Favorite handlers --------
+
+// POST /users/:id/favorite
+func addFavorite(c *gin.Context) {
+	target := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	if target == me {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot favorite yourself"})
+		return
+	}
+	var ok bool
+	_ = db.QueryRow(ctx, `select exists (select 1 from public.profiles where user_id=$1)`, target).Scan(&ok)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if blocked, err := isBlockedPair(ctx, db, me, target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	} else if blocked {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user is blocked"})
+		return
+	}
+	if _, err := db.Exec(ctx, `
+    insert into public.user_favorites(user_id,helper_id) values ($1,$2) on conflict do nothing
+  `, me, target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DELETE /users/:id/favorite
+func removeFavorite(c *gin.Context) {
+	if _, err := db.Exec(c.Request.Context(), `
+    delete from public.user_favorites where user_id=$1 and helper_id=$2
+  `, c.GetString("uid"), c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GET /me/favorites — most reliable first, to pick direct-offer targets.
+func listMyFavorites(c *gin.Context) {
+	rows, err := db.Query(c.Request.Context(), `
+    select f.helper_id, coalesce(p.name, ''), coalesce(p.avatar_url, ''), r.score::float8, f.created_at
+    from public.user_favorites f
+    left join public.profiles p on p.user_id = f.helper_id
+    left join public.reliability_scores r on r.user_id = f.helper_id
+    where f.user_id=$1 order by r.score desc nulls last, f.created_at desc
+  `, c.GetString("uid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	type favorite struct {
+		ID          string    `json:"id"`
+		Name        string    `json:"name"`
+		AvatarURL   string    `json:"avatar_url"`
+		Reliability *float64  `json:"reliability"`
+		CreatedAt   time.Time `json:"created_at"`
+	}
+	out := []favorite{}
+	for rows.Next() {
+		var f favorite
+		if err := rows.Scan(&f.ID, &f.Name, &f.AvatarURL, &f.Reliability, &f.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, f)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GET /tasks/offered — direct offers to me that are still exclusive.
+func listOfferedTasks(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+	rows, err := db.Query(ctx, `
+    select `+taskColumns+`
+    from public.tasks t
+    where status='open' and assigned_to = '' and exclusive_until > now()
+      and exists (select 1 from public.task_targets x where x.task_id=t.id and x.helper_id=$1)
+    order by exclusive_until asc
+  `, me)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []Task{}
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, t)
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifSegment builds an APP1 segment holding a single Orientation entry.
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	_ = binary.Write(&tiff, order, uint16(42))
+	_ = binary.Write(&tiff, order, uint32(8)) // IFD0 offset
+	_ = binary.Write(&tiff, order, uint16(1)) // one entry
+	_ = binary.Write(&tiff, order, uint16(0x0112))
+	_ = binary.Write(&tiff, order, uint16(3)) // SHORT
+	_ = binary.Write(&tiff, order, uint32(1))
+	_ = binary.Write(&tiff, order, orientation)
+	_ = binary.Write(&tiff, order, uint16(0))
+	_ = binary.Write(&tiff, order, uint32(0)) // no next IFD
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// testJPEG encodes a w×h image and splices extra segments in after SOI.
+func testJPEG(t *testing.T, w, h int, segments ...[]byte) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / max(w-1, 1)), uint8(y * 255 / max(h-1, 1)), 0, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, data[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	truncated := exifSegment(binary.BigEndian, 6)
+	truncated = truncated[:len(truncated)-10]
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no exif", testJPEG(t, 8, 4), 1},
+		{"little endian", testJPEG(t, 8, 4, exifSegment(binary.LittleEndian, 6)), 6},
+		{"big endian", testJPEG(t, 8, 4, exifSegment(binary.BigEndian, 8)), 8},
+		{"after another APP segment", testJPEG(t, 8, 4, []byte{0xFF, 0xE0, 0, 4, 'J', 'F'}, exifSegment(binary.BigEndian, 3)), 3},
+		{"out of range value", testJPEG(t, 8, 4, exifSegment(binary.LittleEndian, 9)), 1},
+		{"truncated segment", append([]byte{0xFF, 0xD8}, truncated...), 1},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"empty", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// 2×1 source: red left, blue right.
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		first       color.RGBA // pixel at (0,0) after correction
+	}{
+		{1, 2, 1, red},
+		{2, 2, 1, blue}, // mirrored
+		{3, 2, 1, blue}, // 180°
+		{6, 1, 2, red},  // stored rotated 90° CCW → turn CW: left ends on top
+		{8, 1, 2, blue}, // stored rotated 90° CW → turn CCW: right ends on top
+	}
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		b := got.Bounds()
+		if b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if c := color.RGBAModel.Convert(got.At(b.Min.X, b.Min.Y)).(color.RGBA); c != tt.first {
+			t.Errorf("orientation %d: first pixel %v, want %v", tt.orientation, c, tt.first)
+		}
+	}
+}
+
+func TestDecodeImageAppliesOrientation(t *testing.T) {
+	data := testJPEG(t, 64, 32, exifSegment(binary.LittleEndian, 6))
+	img, err := decodeImage(data, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 32 || b.Dy() != 64 {
+		t.Errorf("decoded %dx%d, want 32x64", b.Dx(), b.Dy())
+	}
+
+	// 重新編碼後不再帶 EXIF
+	out, err := encodeJPEG(fitWithin(img, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("Exif\x00\x00")) {
+		t.Error("re-encoded image still carries EXIF")
+	}
+	if _, err := decodeImage(testJPEG(t, 8, 8), 16); err == nil {
+		t.Error("image below minSide accepted")
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+// Invoices and receipts for completed tasks.
+// - Issued inside the completeTask transaction (or lazily for older tasks).
+// - Numbers come from a per-year counter row locked in the same transaction,
+//   so they are sequential and gapless; clients never choose them.
+// - The rendered data is stored as an immutable snapshot together with an
+//   HMAC (INVOICE_SIGNING_KEY) so a document can be checked against the DB.
+// Optional ENV:
+//   PLATFORM_FEE_BPS=0          service fee in basis points of the labor amount
+// Required ENV:
+//   INVOICE_SIGNING_KEY=...     HMAC key for document hashes (startup fails without it)
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const invoiceCurrency = "EUR"
+
+var invoiceSigningKey []byte
+
+// initInvoiceSigning fails closed: an empty HMAC key would let anyone
+// produce a valid-looking hash.
+func initInvoiceSigning() {
+	key := strings.TrimSpace(os.Getenv("INVOICE_SIGNING_KEY"))
+	if key == "" {
+		log.Fatal("INVOICE_SIGNING_KEY is not set")
+	}
+	invoiceSigningKey = []byte(key)
+}
+
+type InvoiceParty struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	Phone string `json:"phone,omitempty"`
+	City  string `json:"city,omitempty"`
+}
+
+type InvoiceLine struct {
+	Kind        string `json:"kind"` // labor | expense | tip | fee
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitCents   int    `json:"unit_cents"`
+	AmountCents int    `json:"amount_cents"`
+}
+
+type Invoice struct {
+	Number          string        `json:"number"`
+	TaskID          string        `json:"task_id"`
+	TaskTitle       string        `json:"task_title"`
+	IssuedAt        time.Time     `json:"issued_at"`
+	Currency        string        `json:"currency"`
+	BillTo          InvoiceParty  `json:"bill_to"`
+	Provider        InvoiceParty  `json:"provider"`
+	Lines           []InvoiceLine `json:"lines"`
+	TotalCents      int           `json:"total_cents"`
+	PrepaidCents    int           `json:"prepaid_cents"`
+	BalanceDueCents int           `json:"balance_due_cents"`
+	Hash            string        `json:"hash"`
+}
+
+func platformFeeBps() int {
+	bps, _ := strconv.Atoi(strings.TrimSpace(os.Getenv("PLATFORM_FEE_BPS")))
+	if bps < 0 {
+		return 0
+	}
+	return bps
+}
+
+// invoiceHash signs the invoice content (without the Hash field).
+func invoiceHash(inv Invoice) string {
+	inv.Hash = ""
+	body, _ := json.Marshal(inv)
+	mac := hmac.New(sha256.New, invoiceSigningKey)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func lookupParty(ctx context.Context, q dbtx, userID string) InvoiceParty {
+	p := InvoiceParty{ID: userID}
+	_ = q.QueryRow(ctx, `select email, name, phone, city from public.profiles where user_id=$1`, userID).Scan(&p.Email, &p.Name, &p.Phone, &p.City)
+	if p.Name == "" {
+		p.Name = deriveName(p.Email)
+	}
+	return p
+}
+
+// issueInvoice returns the task's invoice, creating it on first call.
+// Must run inside a transaction so numbering stays gapless. The task row is
+// locked before looking for an existing invoice, so concurrent first calls
+// wait for each other instead of both allocating a number.
+func issueInvoice(ctx context.Context, tx pgx.Tx, taskID string) (Invoice, error) {
+	var inv Invoice
+	var requester, assignedTo, status string
+	var tipCents, rate int
+	if err := tx.QueryRow(ctx, `
+    select title, requester, assigned_to, status, prepay_amount_cents, tip_cents,
+           coalesce(rate_cents_per_minute, $2)
+    from public.tasks where id=$1 for update
+  `, taskID, centsPerMinute).Scan(&inv.TaskTitle, &requester, &assignedTo, &status, &inv.PrepaidCents, &tipCents, &rate); err != nil {
+		return Invoice{}, err
+	}
+	if existing, err := loadInvoice(ctx, tx, taskID); err == nil {
+		return existing, nil
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return Invoice{}, err
+	}
+	if status != "completed" {
+		return Invoice{}, fmt.Errorf("task %s not completed", taskID)
+	}
+
+	// 工時：每段 session 一行，與 getWorklogs 相同的取整規則
+	rows, err := tx.Query(ctx, `
+    select start_at, end_at, greatest(ceil(extract(epoch from (end_at - start_at))/60.0), 1)::int
+    from public.worklogs
+    where task_id=$1 and end_at is not null and end_at > start_at
+    order by start_at asc
+  `, taskID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	laborCents := 0
+	for rows.Next() {
+		var start, end time.Time
+		var minutes int
+		if err := rows.Scan(&start, &end, &minutes); err != nil {
+			rows.Close()
+			return Invoice{}, err
+		}
+		amount := minutes * rate
+		laborCents += amount
+		inv.Lines = append(inv.Lines, InvoiceLine{
+			Kind:        "labor",
+			Description: fmt.Sprintf("Work %s–%s UTC", start.UTC().Format("2006-01-02 15:04"), end.UTC().Format("15:04")),
+			Quantity:    minutes, UnitCents: rate, AmountCents: amount,
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Invoice{}, err
+	}
+
+	rows, err = tx.Query(ctx, `
+    select description, amount_cents from public.task_expenses
+    where task_id=$1 and status='approved' order by created_at asc
+  `, taskID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	for rows.Next() {
+		var desc string
+		var amount int
+		if err := rows.Scan(&desc, &amount); err != nil {
+			rows.Close()
+			return Invoice{}, err
+		}
+		inv.Lines = append(inv.Lines, InvoiceLine{
+			Kind: "expense", Description: "Expense: " + desc,
+			Quantity: 1, UnitCents: amount, AmountCents: amount,
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Invoice{}, err
+	}
+
+	if tipCents > 0 {
+		inv.Lines = append(inv.Lines, InvoiceLine{
+			Kind: "tip", Description: "Tip", Quantity: 1, UnitCents: tipCents, AmountCents: tipCents,
+		})
+	}
+	if fee := int(math.Round(float64(laborCents) * float64(platformFeeBps()) / 10000)); fee > 0 {
+		inv.Lines = append(inv.Lines, InvoiceLine{
+			Kind: "fee", Description: "Service fee", Quantity: 1, UnitCents: fee, AmountCents: fee,
+		})
+	}
+	for _, l := range inv.Lines {
+		inv.TotalCents += l.AmountCents
+	}
+	inv.BalanceDueCents = inv.TotalCents - inv.PrepaidCents
+
+	inv.TaskID = taskID
+	inv.Currency = invoiceCurrency
+	inv.BillTo = lookupParty(ctx, tx, requester)
+	inv.Provider = lookupParty(ctx, tx, assignedTo)
+	inv.IssuedAt = time.Now().UTC().Truncate(time.Second)
+
+	// 號碼與發票放在 savepoint：若已有人開出（on conflict），退回 counter 保持連號
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return Invoice{}, err
+	}
+	defer sp.Rollback(ctx)
+
+	// 年度流水號：upsert 會鎖住該年度的 counter row 直到 commit
+	year := inv.IssuedAt.Year()
+	var seq int
+	if err := sp.QueryRow(ctx, `
+    insert into public.invoice_counters(year, last_seq) values ($1, 1)
+    on conflict (year) do update set last_seq = public.invoice_counters.last_seq + 1
+    returning last_seq
+  `, year).Scan(&seq); err != nil {
+		return Invoice{}, err
+	}
+	inv.Number = fmt.Sprintf("HORA-%d-%06d", year, seq)
+	inv.Hash = invoiceHash(inv)
+
+	data, _ := json.Marshal(inv)
+	tag, err := sp.Exec(ctx, `
+    insert into public.invoices(task_id, number, year, seq, issued_at, data, hash)
+    values ($1,$2,$3,$4,$5,$6,$7)
+    on conflict (task_id) do nothing
+  `, taskID, inv.Number, year, seq, inv.IssuedAt, data, inv.Hash)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		if err := sp.Rollback(ctx); err != nil {
+			return Invoice{}, err
+		}
+		return loadInvoice(ctx, tx, taskID)
+	}
+	return inv, sp.Commit(ctx)
+}
+
+func loadInvoice(ctx context.Context, q dbtx, taskID string) (Invoice, error) {
+	var data []byte
+	if err := q.QueryRow(ctx, `select data from public.invoices where task_id=$1`, taskID).Scan(&data); err != nil {
+		return Invoice{}, err
+	}
+	var inv Invoice
+	err := json.Unmarshal(data, &inv)
+	return inv, err
+}
+
+// -------- Invoice handlers --------
+// GET /tasks/:id/invoice?format=pdf|json (default json)
+// GET /tasks/:id/receipt?format=pdf|json
+
+func getInvoice(c *gin.Context) { serveInvoice(c, "invoice") }
+func getReceipt(c *gin.Context) { serveInvoice(c, "receipt") }
+
+func serveInvoice(c *gin.Context, kind string) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var requester, assignedTo, status string
+	if err := db.QueryRow(ctx, `select requester,assigned_to,status from public.tasks where id=$1`, taskID).Scan(&requester, &assignedTo, &status); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if requester != me && assignedTo != me {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+		return
+	}
+	if status != "completed" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task not completed"})
+		return
+	}
+
+	inv, err := loadInvoice(ctx, db, taskID)
+	if errors.Is(err, pgx.ErrNoRows) && impersonating(c) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invoice not issued yet"})
+		return
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		// 功能上線前完成的任務：補開
+		inv, err = issueInvoiceTx(ctx, taskID)
+	}
+	if err != nil {
+		log.Printf("[invoice] task %s: %v", taskID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, gin.H{"type": kind, "invoice": inv})
+	case "pdf":
+		c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s-%s.pdf"`, kind, inv.Number))
+		c.Data(http.StatusOK, "application/pdf", renderInvoicePDF(inv, kind))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be pdf or json"})
+	}
+}
+
+func issueInvoiceTx(ctx context.Context, taskID string) (Invoice, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Invoice{}, err
+	}
+	defer tx.Rollback(ctx)
+	inv, err := issueInvoice(ctx, tx, taskID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	return inv, tx.Commit(ctx)
+}
+
+func formatCents(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s€%d.%02d", sign, cents/100, cents%100)
+}
+
+func renderInvoicePDF(inv Invoice, kind string) []byte {
+	heading := "INVOICE"
+	if kind == "receipt" {
+		heading = "RECEIPT"
+	}
+	party := func(label string, p InvoiceParty) []pdfLine {
+		out := []pdfLine{{Text: label, Font: pdfBold}, {Text: p.Name}, {Text: p.Email}}
+		if p.Phone != "" {
+			out = append(out, pdfLine{Text: p.Phone})
+		}
+		if p.City != "" {
+			out = append(out, pdfLine{Text: p.City})
+		}
+		return append(out, pdfLine{})
+	}
+
+	lines := []pdfLine{
+		{Text: "Hora — " + heading, Font: pdfBold, Size: 18},
+		{},
+		{Text: "No. " + inv.Number},
+		{Text: "Issued " + inv.IssuedAt.Format("2006-01-02 15:04 MST")},
+		{Text: "Task: " + inv.TaskTitle},
+		{},
+	}
+	lines = append(lines, party("Bill to", inv.BillTo)...)
+	lines = append(lines, party("Provider", inv.Provider)...)
+
+	row := func(desc, qty, unit, amount string) pdfLine {
+		if len([]rune(desc)) > 44 {
+			desc = string([]rune(desc)[:43]) + "…"
+		}
+		return pdfLine{Text: fmt.Sprintf("%-44s %6s %10s %11s", desc, qty, unit, amount), Font: pdfMono, Size: 9}
+	}
+	lines = append(lines, row("Description", "Qty", "Unit", "Amount"))
+	for _, l := range inv.Lines {
+		lines = append(lines, row(l.Description, strconv.Itoa(l.Quantity), formatCents(l.UnitCents), formatCents(l.AmountCents)))
+	}
+	lines = append(lines,
+		pdfLine{},
+		row("Total", "", "", formatCents(inv.TotalCents)),
+		row("Prepaid", "", "", formatCents(inv.PrepaidCents)),
+	)
+	// 收據只列實際已收的金額（預付）；差額另列應補或應退
+	if kind == "receipt" {
+		received := inv.PrepaidCents
+		if received > inv.TotalCents {
+			received = inv.TotalCents
+		}
+		lines = append(lines, row("Amount received", "", "", formatCents(received)))
+		switch {
+		case inv.BalanceDueCents > 0:
+			lines = append(lines, row("Balance due", "", "", formatCents(inv.BalanceDueCents)))
+		case inv.BalanceDueCents < 0:
+			lines = append(lines, row("Refund due", "", "", formatCents(-inv.BalanceDueCents)))
+		}
+	} else {
+		lines = append(lines, row("Balance due", "", "", formatCents(inv.BalanceDueCents)))
+	}
+	lines = append(lines, pdfLine{}, pdfLine{Text: "Verification: " + inv.Hash, Font: pdfMono, Size: 7})
+
+	return renderPDF(heading+" "+inv.Number, lines)
+}
@@ -1,16 +1,32 @@
 // Server entrypoint for Hora (Version A: backend ↔ Supabase Postgres).
-// - Auth: validates Supabase JWT via JWKS (RS256).
+// - Auth: validates Supabase JWT via JWKS (RS256/ES256) or the legacy HS256 secret (see verifier.go).
 // - DB: connects directly to Supabase Postgres using pgxpool.
 // - Scope: profiles, tasks, worklogs CRUD and basic business flows.
 // Required ENV:
 //   SUPABASE_PROJECT_URL=https://<ref>.supabase.co
 //   SUPABASE_DB_URL=postgres://postgres:<password>@db.<ref>.supabase.co:5432/postgres?sslmode=require
+//   INVOICE_SIGNING_KEY (see invoice.go)
+//   PAYOUT_PROVIDER (see payouts.go)
+//   ATTACHMENT_SIGNING_KEY (task photo links, see attachments.go)
+// Optional ENV:
+//   HORA_DEV_MODE (local development only; enables fake backends)
+//   TRUSTED_PROXIES (comma-separated IPs/CIDRs allowed to set X-Forwarded-For; default none)
+//   BLOB_STORE, BLOB_STORE_DIR, S3_* (receipts and other uploads, see blobstore.go)
+//   PUBLIC_BASE_URL (avatar URLs, see avatar.go)
+//   RECURRENCE_HORIZON_DAYS (see recurring.go)
+//   PLATFORM_FEE_BPS (see invoice.go)
+//   PAYOUT_MIN_CENTS, PAYOUT_WEEKDAY (see payouts.go)
+//   JWT_ALLOWED_ALGS, JWT_AUDIENCE, JWT_ALLOWED_ROLES, JWT_CLOCK_SKEW (see verifier.go)
+//   SMS_PROVIDER (see sms.go)
+//   REVIEW_WINDOW_DAYS (see reviews.go)
+//   OTP_BACKEND, SUPABASE_ANON_KEY (see otpauth.go)
 
 package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"expvar"
 	"log"
 	"net/http"
 	"os"
@@ -20,8 +36,8 @@ import (
 	"github.com/MicahParks/keyfunc/v2"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 )
@@ -32,20 +48,37 @@ import (
 var jwks *keyfunc.JWKS
 var db *pgxpool.Pool
 
+// dbtx is satisfied by both *pgxpool.Pool and pgx.Tx.
+type dbtx interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 type Task struct {
-	ID                string     `json:"id"` // ← 原本是 string，改成 int64
-	Title             string     `json:"title"`
-	Description       string     `json:"description"`
-	Category          string     `json:"category"`
-	LocationText      string     `json:"location_text"`
-	EstimatedMinutes  int        `json:"estimated_minutes"`
-	PrepayAmountCents int        `json:"prepay_amount_cents"`
-	IsImmediate       bool       `json:"is_immediate"`
-	ScheduledAt       *time.Time `json:"scheduled_at,omitempty"`
-	Requester         string     `json:"requester"` // Supabase user UUID
-	Status            string     `json:"status"`
-	CreatedAt         time.Time  `json:"created_at"`
-	AssignedTo        string     `json:"assigned_to"`
+	ID                 string     `json:"id"` // ← 原本是 string，改成 int64
+	Title              string     `json:"title"`
+	Description        string     `json:"description"`
+	Category           string     `json:"category"`
+	LocationText       string     `json:"location_text"`
+	EstimatedMinutes   int        `json:"estimated_minutes"`
+	PrepayAmountCents  int        `json:"prepay_amount_cents"`
+	IsImmediate        bool       `json:"is_immediate"`
+	ScheduledAt        *time.Time `json:"scheduled_at,omitempty"`
+	Timezone           string     `json:"timezone"`               // IANA zone the times were entered in (see scheduling.go)
+	WindowStart        *time.Time `json:"window_start,omitempty"` // flexible time: any time in [window_start, window_end]
+	WindowEnd          *time.Time `json:"window_end,omitempty"`
+	Requester          string     `json:"requester"` // Supabase user UUID
+	Status             string     `json:"status"`
+	CreatedAt          time.Time  `json:"created_at"`
+	AssignedTo         string     `json:"assigned_to"`                     // Supabase user UUID, '' = unassigned
+	ExclusiveUntil     *time.Time `json:"exclusive_until,omitempty"`       // direct offer: only targeted helpers until then
+	RateCentsPerMinute *int       `json:"rate_cents_per_minute,omitempty"` // agreed via an offer; null = centsPerMinute
+	RequireChecklist   bool       `json:"require_checklist"`               // completeTask needs every checklist item checked
+	SeriesID           *string    `json:"series_id,omitempty"`             // recurring task (see recurring.go)
+	OccurrenceAt       *time.Time `json:"occurrence_at,omitempty"`         // the series slot this task was generated for
+	Checklist          *Checklist `json:"checklist,omitempty"`             // getTask only (see checklist.go)
+	Warnings           []string   `json:"warnings,omitempty"`              // 非致命提醒（例如超出月預算）
 }
 
 type createTaskInput struct {
@@ -56,18 +89,60 @@ type createTaskInput struct {
 	EstimatedMinutes  int    `json:"estimated_minutes"`
 	PrepayAmountCents int    `json:"prepay_amount_cents"`
 	IsImmediate       bool   `json:"is_immediate"`
-	ScheduledAt       string `json:"scheduled_at"` // ISO8601 (RFC3339) 或空字串
+	ScheduledAt       string `json:"scheduled_at"` // RFC3339 or local "2006-01-02T15:04" in Timezone; 空字串 = 未定
+	Timezone          string `json:"timezone"`     // IANA; default: profile zone (update: the task's zone)
+	WindowStart       string `json:"window_start"` // with WindowEnd, instead of ScheduledAt
+	WindowEnd         string `json:"window_end"`
+
+	// Direct offer (createTask only, see favorites.go)
+	DirectTo          []string `json:"direct_to"`
+	DirectToFavorites bool     `json:"direct_to_favorites"`
+	ExclusiveMinutes  int      `json:"exclusive_minutes"`
+
+	// Checklist items (createTask only; edit via /tasks/:id/checklist)
+	Checklist        []string `json:"checklist"`
+	RequireChecklist *bool    `json:"require_checklist"` // nil = unchanged on update
+}
+
+// validate trims and defaults the common task fields; returns an error
+// message or "".
+func (in *createTaskInput) validate() string {
+	in.Title = strings.TrimSpace(in.Title)
+	in.Description = strings.TrimSpace(in.Description)
+	in.Category = strings.TrimSpace(in.Category)
+	in.LocationText = strings.TrimSpace(in.LocationText)
+	if in.Title == "" {
+		return "title required"
+	}
+	if in.EstimatedMinutes <= 0 {
+		in.EstimatedMinutes = 30
+	}
+	if in.Category == "" {
+		in.Category = "task"
+	}
+	if _, ok := taskCategories[in.Category]; !ok {
+		return "invalid category"
+	}
+	if in.PrepayAmountCents < 0 {
+		in.PrepayAmountCents = 0
+	}
+	return ""
 }
 
 type Profile struct {
-	Email     string    `json:"email"`
-	Name      string    `json:"name"`
-	Phone     string    `json:"phone"`
-	City      string    `json:"city"`
-	AvatarURL string    `json:"avatar_url"`
-	Bio       string    `json:"bio"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                 string    `json:"id"`    // Supabase user UUID (JWT sub)
+	Email              string    `json:"email"` // display only; synced from the JWT claim
+	Name               string    `json:"name"`
+	Phone              string    `json:"phone"`
+	City               string    `json:"city"`
+	Timezone           string    `json:"timezone"` // IANA; default for new tasks and date filters
+	AvatarURL          string    `json:"avatar_url"`
+	Bio                string    `json:"bio"`
+	MonthlyBudgetCents *int      `json:"monthly_budget_cents"` // null = 不限
+	BudgetHardLimit    bool      `json:"budget_hard_limit"`    // true: 超出預算的任務直接拒絕
+	VerificationLevel  int       `json:"verification_level"`   // 0–3, see verification.go
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
 }
 
 type WorkLog struct {
@@ -95,9 +170,11 @@ func main() {
 
 	var err error
 	jwks, err = keyfunc.Get(jwksURL, keyfunc.Options{
-		RefreshInterval: time.Hour, // 定期自動更新金鑰
-		RefreshTimeout:  10 * time.Second,
-		Ctx:             context.Background(),
+		RefreshInterval:   time.Hour, // 定期自動更新金鑰
+		RefreshTimeout:    10 * time.Second,
+		RefreshUnknownKID: true, // 金鑰輪替：遇到未知 kid 時重抓（有 rate limit）
+		RefreshRateLimit:  5 * time.Minute,
+		Ctx:               context.Background(),
 		RefreshErrorHandler: func(err error) {
 			log.Printf("[jwks] refresh error: %v", err)
 		},
@@ -105,6 +182,11 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to init JWKS: %v", err)
 	}
+	verifier, err = newTokenVerifierFromEnv(projectURL+"/auth/v1", jwks.Keyfunc)
+	if err != nil {
+		log.Fatalf("token verifier: %v", err)
+	}
+	log.Printf("[auth] algs=%v aud=%s skew=%s", verifier.allowedAlgs, verifier.audience, verifier.leeway)
 	//DB init
 	// Initialize pgx pool. Keep MaxConns conservative on small instances.
 	// Tip: add `?sslmode=require` in SUPABASE_DB_URL for production.
@@ -134,9 +216,25 @@ func main() {
 	}
 	log.Println("[db] connected")
 
+	initBlobStore()
+	initInvoiceSigning()
+	initAttachmentSigning()
+	initPayoutProvider()
+	initSMSProvider()
+	initOTPBackend(verifier.issuer)
+
 	// go cleanupLoop()
+	go payoutLoop()
+	go sessionDenylistLoop()
+	go reliabilityLoop()
+	go recurrenceLoop()
 
 	r := gin.Default()
+	// c.ClientIP() 用在 OTP 限流與裝置紀錄：預設不信任任何 X-Forwarded-For，
+	// 部署在反向代理後面時以 TRUSTED_PROXIES 列出代理位址
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
 
 	// CORS: allow local dev and production origins. Adjust before deploying preview domains.
 
@@ -146,32 +244,136 @@ func main() {
 			"https://horaapp.co",
 			"https://app.horaapp.co",
 		},
-		AllowMethods:     []string{"GET", "POST", "PATCH", "OPTIONS"},
-		AllowHeaders:     []string{"Authorization", "Content-Type"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Authorization", "Content-Type", "X-Impersonate-User", "X-Device-ID"},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
 	}
 	r.Use(cors.New(c))
 	r.OPTIONS("/*path", func(c *gin.Context) { c.Status(http.StatusNoContent) })
 
-	// r.POST("/auth/request-otp", requestOTP)
-	// r.POST("/auth/verify", verifyOTP)
+	// Server-side OTP login for native / CLI clients (see otpauth.go)
+	r.POST("/auth/request-otp", requestOTP)
+	r.POST("/auth/verify", verifyOTP)
 
 	auth := r.Group("/auth")
-	auth.Use(authMiddleware())
+	auth.Use(authMiddleware(), requireScope("profile"))
 	auth.GET("/me", me)
 
 	// User Profile
 
 	meAPI := r.Group("/profile")
-	meAPI.Use(authMiddleware())
+	meAPI.Use(authMiddleware(), requireScope("profile"))
 	{
 		meAPI.GET("", getMyProfile)
 		meAPI.PATCH("", patchMyProfile)
+		meAPI.POST("/avatar", uploadMyAvatar)
+		meAPI.DELETE("/avatar", deleteMyAvatar)
+	}
+
+	// 公開圖檔（頭像）
+	r.GET("/media/avatars/*path", getAvatarMedia)
+	r.GET("/attachments/:id", getAttachmentFile) // 簽名網址，見 attachments.go
+
+	// 個人報表
+	mine := r.Group("/me")
+	mine.Use(authMiddleware(), requireScope("me"))
+	{
+		mine.GET("/earnings", getMyEarnings)
+		mine.GET("/spending", getMySpending)
+
+		// 提領（改帳戶、發起提領只能用瀏覽器 session，token 外洩也動不了錢）
+		mine.GET("/payout-account", getPayoutAccount)
+		mine.PUT("/payout-account", requireSession, putPayoutAccount)
+		mine.GET("/payouts", listMyPayouts)
+		mine.POST("/payouts", requireSession, requestPayout)
+
+		// 個人 API token（只能用瀏覽器 session 管理）
+		mine.GET("/tokens", requireSession, listMyTokens)
+		mine.POST("/tokens", requireSession, createMyToken)
+		mine.DELETE("/tokens/:id", requireSession, revokeMyToken)
+
+		// 登入裝置
+		mine.GET("/devices", listMyDevices)
+		mine.DELETE("/devices/:id", revokeMyDevice)
+
+		// 封鎖名單
+		mine.GET("/blocks", listMyBlocks)
+		mine.GET("/favorites", listMyFavorites)
+		mine.GET("/reliability", getMyReliability)
+
+		// 可接案時段 / 休假
+		mine.GET("/availability", getMyAvailability)
+		mine.PUT("/availability", putMyAvailability)
+		mine.POST("/time-off", createTimeOff)
+		mine.DELETE("/time-off/:id", deleteTimeOff)
+
+		// Identity verification
+		mine.GET("/verification", getMyVerification)
+		mine.POST("/verification/phone", requireSession, startPhoneVerification)
+		mine.POST("/verification/phone/confirm", requireSession, confirmPhoneVerification)
+		mine.POST("/verification/id", requireSession, submitIDDocument)
+	}
+
+	// 營運後台：查詢給 moderator，寫入只給 admin
+	adminAPI := r.Group("/admin")
+	adminAPI.Use(authMiddleware(), requireSession, requireRole(roleModerator))
+	{
+		adminAPI.GET("/tasks", adminListTasks)
+		adminAPI.GET("/users", adminListUsers)
+		adminAPI.POST("/tasks/:id/transition", requireRole(roleAdmin), adminTransitionTask)
+		adminAPI.PUT("/users/:id/roles", requireRole(roleAdmin), adminSetRoles)
+		adminAPI.GET("/metrics", requireRole(roleAdmin), gin.WrapH(expvar.Handler()))
+		adminAPI.GET("/service-accounts", requireRole(roleAdmin), adminListServiceAccounts)
+		adminAPI.POST("/service-accounts", requireRole(roleAdmin), adminCreateServiceAccount)
+		adminAPI.DELETE("/service-accounts/:id", requireRole(roleAdmin), adminDisableServiceAccount)
+		adminAPI.GET("/id-documents", requireRole(roleAdmin), adminListIDDocuments)
+		adminAPI.GET("/id-documents/:id/file", requireRole(roleAdmin), adminGetIDDocumentFile)
+		adminAPI.POST("/id-documents/:id/approve", requireRole(roleAdmin), adminApproveIDDocument)
+		adminAPI.POST("/id-documents/:id/reject", requireRole(roleAdmin), adminRejectIDDocument)
+		adminAPI.GET("/payouts", requireRole(roleAdmin), adminListPayouts)
+		adminAPI.POST("/payouts/:id/resolve", requireRole(roleAdmin), adminResolvePayout)
+	}
+
+	// 週期性任務
+	seriesAPI := r.Group("/task-series")
+	seriesAPI.Use(authMiddleware(), requireScope("tasks"))
+	{
+		seriesAPI.POST("", createSeries)
+		seriesAPI.GET("", listMySeries)
+		seriesAPI.GET("/:id", getSeries)
+		seriesAPI.PATCH("/:id", updateSeries)
+		seriesAPI.DELETE("/:id", cancelSeries)
+		seriesAPI.GET("/:id/occurrences", listSeriesOccurrences)
+		seriesAPI.POST("/:id/occurrences/skip", skipSeriesOccurrence)
+		seriesAPI.POST("/:id/occurrences/materialize", materializeSeriesOccurrence)
+	}
+
+	// 任務範本
+	templatesAPI := r.Group("/task-templates")
+	templatesAPI.Use(authMiddleware(), requireScope("tasks"))
+	{
+		templatesAPI.GET("", listTemplates)
+		templatesAPI.POST("", createTemplate)
+		templatesAPI.GET("/:id", getTemplate)
+		templatesAPI.PUT("/:id", updateTemplate)
+		templatesAPI.DELETE("/:id", deleteTemplate)
+		templatesAPI.POST("/:id/tasks", createTaskFromTemplate)
+	}
+
+	usersAPI := r.Group("/users")
+	usersAPI.Use(authMiddleware(), requireScope("users"))
+	{
+		usersAPI.GET("/:id/profile", getUserProfile)
+		usersAPI.POST("/:id/block", blockUser)
+		usersAPI.DELETE("/:id/block", unblockUser)
+		usersAPI.GET("/:id/reviews", listUserReviews)
+		usersAPI.POST("/:id/favorite", addFavorite)
+		usersAPI.DELETE("/:id/favorite", removeFavorite)
 	}
 
 	tasksAPI := r.Group("/tasks")
-	tasksAPI.Use(authMiddleware())
+	tasksAPI.Use(authMiddleware(), requireScope("tasks"))
 	{
 		tasksAPI.POST("", createTask)
 		tasksAPI.GET("", listMyTasks)
@@ -179,18 +381,59 @@ func main() {
 		tasksAPI.PATCH("/:id", updateTask) // ← 編輯
 
 		tasksAPI.GET("/available", listAvailableTasks)
+		tasksAPI.GET("/offered", listOfferedTasks) // 指定給我的任務（獨享期內）
 		tasksAPI.GET("/assigned", listAssignedTasks)
 		tasksAPI.GET("/posted", listMyTasks) // alias
 		tasksAPI.GET("/done", listDoneTasks)
 		tasksAPI.GET("/posted/closed", listMyPostedClosed) // 我發的已完成/取消（可選）
 
-		tasksAPI.POST("/:id/accept", acceptTask)     // 接單
+		tasksAPI.POST("/:id/accept", acceptTask)     // 接單（?allow_conflicts=true 略過撞期）
 		tasksAPI.POST("/:id/complete", completeTask) // 完成
 
+		// 複製成新的 open 任務
+		tasksAPI.POST("/:id/duplicate", duplicateTask)
+
+		// 評價（雙盲）
+		tasksAPI.POST("/:id/reviews", createReview)
+		tasksAPI.GET("/:id/reviews", getTaskReviews)
+
+		// 報價 / 議價
+		tasksAPI.POST("/:id/offers", createOffer)
+		tasksAPI.GET("/:id/offers", listOffers)
+		tasksAPI.POST("/:id/offers/:offerId/accept", acceptOffer)
+		tasksAPI.POST("/:id/offers/:offerId/counter", counterOffer)
+		tasksAPI.POST("/:id/offers/:offerId/reject", rejectOffer)
+		tasksAPI.POST("/:id/offers/:offerId/withdraw", withdrawOffer)
+
 		// ✅ 新增打卡與查詢工時
 		tasksAPI.POST("/:id/clock-in", clockIn)
 		tasksAPI.POST("/:id/clock-out", clockOut)
 		tasksAPI.GET("/:id/worklogs", getWorklogs)
+
+		// 代墊費用與收據
+		tasksAPI.POST("/:id/expenses", createExpense)
+		tasksAPI.GET("/:id/expenses", listExpenses)
+		tasksAPI.POST("/:id/expenses/:expenseId/approve", approveExpense)
+		tasksAPI.POST("/:id/expenses/:expenseId/reject", rejectExpense)
+		tasksAPI.GET("/:id/expenses/:expenseId/receipt", getExpenseReceipt)
+
+		// 照片附件（brief = 需求說明，proof = 完成證明）
+		tasksAPI.POST("/:id/attachments", createAttachment)
+		tasksAPI.GET("/:id/attachments", listAttachments)
+		tasksAPI.DELETE("/:id/attachments/:attachmentId", deleteAttachment)
+
+		// 待辦清單：作者編輯，接單者勾選
+		tasksAPI.GET("/:id/checklist", getChecklist)
+		tasksAPI.POST("/:id/checklist", addChecklistItem)
+		tasksAPI.PUT("/:id/checklist/order", reorderChecklist)
+		tasksAPI.PATCH("/:id/checklist/:itemId", updateChecklistItem)
+		tasksAPI.DELETE("/:id/checklist/:itemId", deleteChecklistItem)
+		tasksAPI.POST("/:id/checklist/:itemId/check", checkChecklistItem)
+		tasksAPI.DELETE("/:id/checklist/:itemId/check", uncheckChecklistItem)
+
+		// 發票 / 收據（PDF 或 JSON）
+		tasksAPI.GET("/:id/invoice", getInvoice)
+		tasksAPI.GET("/:id/receipt", getReceipt)
 	}
 
 	addr := ":8080"
@@ -208,11 +451,16 @@ func me(c *gin.Context) {
 		"id":    uid,
 		"email": email,
 		"name":  deriveName(email),
+		"roles": rolesOf(c),
 	})
 }
 
-// Verify "Bearer <JWT>" using JWKS and enforce issuer = <PROJECT_URL>/auth/v1.
+// Verify "Bearer <JWT>" with the configured tokenVerifier (see verifier.go):
+// pinned algorithms, issuer = <PROJECT_URL>/auth/v1, audience, role, expiry.
+// "Bearer hora_pat_…" / "hora_sa_…" API tokens are accepted too (see apitokens.go).
 // Exposes: c.Set("uid") = sub (Supabase user UUID), c.Set("email") if present.
+// uid is the only identity used in tables; email may change or be absent
+// (phone-only users) and is for display only.
 
 func authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -223,51 +471,47 @@ func authMiddleware() gin.HandlerFunc {
 		}
 		tokenStr := strings.TrimPrefix(authz, "Bearer ")
 
-		// 依 alg 選擇驗證方式
-		keyfunc := func(t *jwt.Token) (interface{}, error) {
-			alg := t.Method.Alg()
-			switch alg {
-			case "HS256", "HS384", "HS512":
-				secret := strings.TrimSpace(os.Getenv("SUPABASE_JWT_SECRET"))
-				if secret == "" {
-					return nil, fmt.Errorf("SUPABASE_JWT_SECRET is not set")
-				}
-				return []byte(secret), nil
-			case "RS256", "RS384", "RS512":
-				if jwks == nil {
-					return nil, fmt.Errorf("jwks not initialized")
-				}
-				return jwks.Keyfunc(t)
-			default:
-				return nil, fmt.Errorf("unsupported alg: %s", alg)
+		// 個人 token / service account：同樣設定 uid，另帶 scopes 給 requireScope 檢查
+		if isAPIToken(tokenStr) {
+			uid, scopes, tokenID, err := authenticateAPIToken(c.Request.Context(), tokenStr)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+				return
+			}
+			c.Set("uid", uid)
+			c.Set("scopes", scopes)
+			c.Set("token_id", tokenID)
+			if !applyImpersonation(c) {
+				return
 			}
+			c.Next()
+			return
 		}
 
-		token, err := jwt.Parse(tokenStr, keyfunc)
-		if err != nil || !token.Valid {
+		claims, err := verifier.Verify(tokenStr)
+		if err != nil {
 			log.Printf("[auth] invalid token: %v", err)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid claims"})
-			return
-		}
 
-		// Issuer 檢查
-		expIss := strings.TrimSuffix(os.Getenv("SUPABASE_PROJECT_URL"), "/") + "/auth/v1"
-		if iss := fmt.Sprint(claims["iss"]); expIss != "" && iss != expIss {
-			log.Printf("[auth] invalid issuer: got=%s want=%s", iss, expIss)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid issuer"})
+		// 已登出／被撤銷的 session
+		sessionID, _ := claims["session_id"].(string)
+		if isSessionRevoked(sessionID) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
 			return
 		}
 
+		// sub 是唯一的身分識別（Verify 已保證非空）
 		c.Set("claims", claims)
-		c.Set("uid", fmt.Sprint(claims["sub"]))
+		c.Set("uid", claims["sub"].(string))
+		touchDevice(c, claims["sub"].(string), sessionID)
 		if email, _ := claims["email"].(string); email != "" {
 			c.Set("email", email)
 		}
+		if !applyImpersonation(c) {
+			return
+		}
 		c.Next()
 	}
 }
@@ -284,46 +528,65 @@ func deriveName(email string) string {
 }
 
 // -------- Profile handlers --------
+// Profiles are keyed by user_id (JWT sub). Email is kept only as display data
+// and refreshed from the token, so changing it in Supabase keeps the profile.
 // getMyProfile: lazy-create profile if missing (idempotent).
-// patchMyProfile: upsert via ON CONFLICT(email).
+// patchMyProfile: upsert via ON CONFLICT(user_id).
 
 func getMyProfile(c *gin.Context) {
+	uid := c.GetString("uid")
 	email := c.GetString("email")
 	ctx := c.Request.Context()
 
 	var p Profile
 	err := db.QueryRow(ctx, `
-    select email, name, phone, city, avatar_url, bio, created_at, updated_at
-    from public.profiles where email = $1
-  `, email).Scan(&p.Email, &p.Name, &p.Phone, &p.City, &p.AvatarURL, &p.Bio, &p.CreatedAt, &p.UpdatedAt)
+    select user_id, email, name, phone, city, timezone, avatar_url, bio, monthly_budget_cents, budget_hard_limit, verification_level, created_at, updated_at
+    from public.profiles where user_id = $1
+  `, uid).Scan(&p.ID, &p.Email, &p.Name, &p.Phone, &p.City, &p.Timezone, &p.AvatarURL, &p.Bio, &p.MonthlyBudgetCents, &p.BudgetHardLimit, &p.VerificationLevel, &p.CreatedAt, &p.UpdatedAt)
 
+	if err != nil && impersonating(c) {
+		// 代登入只讀：不替對方建 profile
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not created yet"})
+		return
+	}
 	if err != nil {
 		// 不存在就建一筆預設
 		now := time.Now()
 		_, err2 := db.Exec(ctx, `
-      insert into public.profiles(email,name,phone,city,avatar_url,bio,created_at,updated_at)
-      values ($1,$2,'','','','',$3,$3)
-    `, email, deriveName(email), now)
+      insert into public.profiles(user_id,email,name,phone,city,avatar_url,bio,created_at,updated_at)
+      values ($1,$2,$3,'','','','',$4,$4)
+    `, uid, email, deriveName(email), now)
 		if err2 != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 			return
 		}
 		p = Profile{
-			Email: email, Name: deriveName(email),
+			ID: uid, Email: email, Name: deriveName(email), Timezone: defaultTimezone,
 			CreatedAt: now, UpdatedAt: now,
 		}
+	} else if email != "" && email != p.Email && !impersonating(c) {
+		// email 在 Supabase 端變更：只同步顯示用欄位
+		_, _ = db.Exec(ctx, `update public.profiles set email=$2 where user_id=$1`, uid, email)
+		p.Email = email
 	}
+	syncEmailVerification(c)
+	p.VerificationLevel, _ = verificationLevel(ctx, db, uid)
 	c.JSON(http.StatusOK, p)
 }
 
 func patchMyProfile(c *gin.Context) {
+	uid := c.GetString("uid")
 	email := c.GetString("email")
 	var in struct {
 		Name      *string `json:"name"`
 		Phone     *string `json:"phone"`
 		City      *string `json:"city"`
+		Timezone  *string `json:"timezone"`
 		AvatarURL *string `json:"avatar_url"`
 		Bio       *string `json:"bio"`
+		// 0 或負數 = 取消預算
+		MonthlyBudgetCents *int  `json:"monthly_budget_cents"`
+		BudgetHardLimit    *bool `json:"budget_hard_limit"`
 	}
 	if err := c.BindJSON(&in); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
@@ -334,9 +597,9 @@ func patchMyProfile(c *gin.Context) {
 	ctx := c.Request.Context()
 	var p Profile
 	_ = db.QueryRow(ctx, `
-    select email, name, phone, city, avatar_url, bio, created_at, updated_at
-    from public.profiles where email = $1
-  `, email).Scan(&p.Email, &p.Name, &p.Phone, &p.City, &p.AvatarURL, &p.Bio, &p.CreatedAt, &p.UpdatedAt)
+    select user_id, email, name, phone, city, timezone, avatar_url, bio, monthly_budget_cents, budget_hard_limit, verification_level, created_at, updated_at
+    from public.profiles where user_id = $1
+  `, uid).Scan(&p.ID, &p.Email, &p.Name, &p.Phone, &p.City, &p.Timezone, &p.AvatarURL, &p.Bio, &p.MonthlyBudgetCents, &p.BudgetHardLimit, &p.VerificationLevel, &p.CreatedAt, &p.UpdatedAt)
 
 	// upsert
 	if in.Name != nil {
@@ -348,24 +611,51 @@ func patchMyProfile(c *gin.Context) {
 	if in.City != nil {
 		p.City = strings.TrimSpace(*in.City)
 	}
-	if in.AvatarURL != nil {
-		p.AvatarURL = strings.TrimSpace(*in.AvatarURL)
+	if in.Timezone != nil {
+		loc, ok := loadTimezone(*in.Timezone)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timezone must be an IANA zone such as Europe/Berlin"})
+			return
+		}
+		p.Timezone = loc.String()
+	}
+	if p.Timezone == "" {
+		p.Timezone = defaultTimezone
+	}
+	// avatar_url 由 POST /profile/avatar 設定；只接受原值（舊 client 會整包送回）
+	if in.AvatarURL != nil && strings.TrimSpace(*in.AvatarURL) != p.AvatarURL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "avatar_url is read-only; upload via POST /profile/avatar"})
+		return
 	}
 	if in.Bio != nil {
 		p.Bio = strings.TrimSpace(*in.Bio)
 	}
-	p.Email = email
+	if in.MonthlyBudgetCents != nil {
+		p.MonthlyBudgetCents = nil
+		if *in.MonthlyBudgetCents > 0 {
+			p.MonthlyBudgetCents = in.MonthlyBudgetCents
+		}
+	}
+	if in.BudgetHardLimit != nil {
+		p.BudgetHardLimit = *in.BudgetHardLimit
+	}
+	p.ID = uid
+	if email != "" {
+		p.Email = email
+	}
 	if p.CreatedAt.IsZero() {
 		p.CreatedAt = time.Now()
 	}
 	p.UpdatedAt = time.Now()
 
-	_, err := db.Exec(ctx, `
-    insert into public.profiles(email,name,phone,city,avatar_url,bio,created_at,updated_at)
-    values ($1,$2,$3,$4,$5,$6,$7,$8)
-    on conflict (email) do update
-    set name=$2, phone=$3, city=$4, avatar_url=$5, bio=$6, updated_at=$8
-  `, p.Email, p.Name, p.Phone, p.City, p.AvatarURL, p.Bio, p.CreatedAt, p.UpdatedAt)
+	// 改 email / phone 會讓 verification_level 下降（generated column）
+	err := db.QueryRow(ctx, `
+    insert into public.profiles(user_id,email,name,phone,city,avatar_url,bio,monthly_budget_cents,budget_hard_limit,created_at,updated_at,timezone)
+    values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+    on conflict (user_id) do update
+    set email=$2, name=$3, phone=$4, city=$5, bio=$7, monthly_budget_cents=$8, budget_hard_limit=$9, updated_at=$11, timezone=$12
+    returning verification_level
+  `, p.ID, p.Email, p.Name, p.Phone, p.City, p.AvatarURL, p.Bio, p.MonthlyBudgetCents, p.BudgetHardLimit, p.CreatedAt, p.UpdatedAt, p.Timezone).Scan(&p.VerificationLevel)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
@@ -373,6 +663,83 @@ func patchMyProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, p)
 }
 
+// getUserProfile: public, privacy-filtered view of a user (tasks only carry
+// UUIDs). Email and phone are returned only to the user themself or to their
+// counterpart on an active (open, assigned) task.
+func getUserProfile(c *gin.Context) {
+	id := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var email, name, phone, city, avatarURL, bio string
+	var level int
+	var memberSince time.Time
+	if err := db.QueryRow(ctx, `
+    select email, name, phone, city, avatar_url, bio, verification_level, created_at
+    from public.profiles where user_id = $1
+  `, id).Scan(&email, &name, &phone, &city, &avatarURL, &bio, &level, &memberSince); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if name == "" {
+		name = deriveName(email)
+	}
+	avg, count, err := userRating(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	stats, err := loadHelperStats(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	out := gin.H{
+		"id":                 id,
+		"name":               name,
+		"avatar_url":         avatarURL,
+		"city":               city,
+		"bio":                bio,
+		"verification_level": level,
+		"rating":             avg,
+		"rating_count":       count,
+		"completed_tasks":    stats.CompletedTasks,
+		"on_time_rate":       stats.OnTimeRate,
+		"reliability":        nil,
+		"member_since":       memberSince,
+	}
+
+	if r, ok, err := loadReliability(ctx, db, id); err == nil && ok {
+		out["reliability"] = r.Score
+	}
+
+	shared := id == me
+	if !shared {
+		_ = db.QueryRow(ctx, `
+      select exists (
+        select 1 from public.tasks
+        where status = 'open'
+          and ((requester=$1 and assigned_to=$2) or (requester=$2 and assigned_to=$1))
+      )`, me, id).Scan(&shared)
+	}
+	if shared {
+		out["email"] = email
+		out["phone"] = phone
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// trustedProxies parses TRUSTED_PROXIES; nil (trust nobody) when unset.
+func trustedProxies() []string {
+	var out []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
 // -------- Tasks handlers --------
 func createTask(c *gin.Context) {
 	var in createTaskInput
@@ -380,85 +747,153 @@ func createTask(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
 		return
 	}
-	in.Title = strings.TrimSpace(in.Title)
-	in.Description = strings.TrimSpace(in.Description)
-	in.Category = strings.TrimSpace(in.Category)
-	in.LocationText = strings.TrimSpace(in.LocationText)
-	if in.Title == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "title required"})
+	createTaskFrom(c, in)
+}
+
+// createTaskFrom validates and inserts a task for the caller (shared by
+// createTask, templates and duplication).
+func createTaskFrom(c *gin.Context, in createTaskInput) {
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
 		return
 	}
-	if in.EstimatedMinutes <= 0 {
-		in.EstimatedMinutes = 30
+	checklist, err := normalizeChecklist(in.Checklist)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	if in.Category == "" {
-		in.Category = "task"
+	requireChecklist := in.RequireChecklist != nil && *in.RequireChecklist
+
+	requester := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	sched, msg := in.schedule(profileTimezone(ctx, db, requester), taskSchedule{})
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
 	}
-	if in.Category != "task" && in.Category != "companion" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category"})
+
+	// 指定幫手：先獨享一段時間，逾時自動回到公開列表
+	targets, err := resolveDirectTargets(ctx, requester, in.DirectTo, in.DirectToFavorites)
+	if errors.Is(err, errInvalidTarget) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "direct_to must list up to 10 other, unblocked users"})
 		return
 	}
-	if in.PrepayAmountCents < 0 {
-		in.PrepayAmountCents = 0
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
 	}
-
-	var when *time.Time
-	if in.IsImmediate {
-		now := time.Now()
-		when = &now
-	} else if strings.TrimSpace(in.ScheduledAt) != "" {
-		t, err := time.Parse(time.RFC3339, in.ScheduledAt)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "scheduled_at must be RFC3339"})
-			return
+	var exclusiveUntil *time.Time
+	if len(targets) > 0 {
+		mins := in.ExclusiveMinutes
+		if mins <= 0 {
+			mins = defaultExclusiveMinutes
+		}
+		if mins > maxExclusiveMinutes {
+			mins = maxExclusiveMinutes
 		}
-		when = &t
+		t := time.Now().Add(time.Duration(mins) * time.Minute)
+		exclusiveUntil = &t
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// 月預算：軟性上限只提醒，硬性上限直接拒絕。鎖住 profile 避免同時建立的任務都通過檢查
+	if err := lockBudget(ctx, tx, requester); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	var warnings []string
+	warning, reject, err := checkBudget(ctx, tx, requester, estimatedCostCents(in.EstimatedMinutes))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if reject {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": warning})
+		return
+	}
+	if warning != "" {
+		warnings = append(warnings, warning)
 	}
 
-	requester := c.GetString("email")
-	ctx := c.Request.Context()
 	var id string
 	var createdAt time.Time
-	err := db.QueryRow(ctx, `
+	err = tx.QueryRow(ctx, `
     insert into public.tasks
-      (title,description,category,location_text,estimated_minutes,prepay_amount_cents,is_immediate,scheduled_at,requester,status,assigned_to)
-    values ($1,$2,$3,$4,$5,$6,$7,$8,$9,'open','')
+      (title,description,category,location_text,estimated_minutes,prepay_amount_cents,is_immediate,scheduled_at,requester,status,assigned_to,exclusive_until,require_checklist,
+       timezone,window_start,window_end)
+    values ($1,$2,$3,$4,$5,$6,$7,$8,$9,'open','',$10,$11,$12,$13,$14)
     returning id, created_at
-  `, in.Title, in.Description, in.Category, in.LocationText, in.EstimatedMinutes, in.PrepayAmountCents, in.IsImmediate, when, requester).Scan(&id, &createdAt)
+  `, in.Title, in.Description, in.Category, in.LocationText, in.EstimatedMinutes, in.PrepayAmountCents, in.IsImmediate, sched.At, requester, exclusiveUntil, requireChecklist,
+		sched.Timezone, sched.WindowStart, sched.WindowEnd).Scan(&id, &createdAt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
+	for _, helper := range targets {
+		if _, err := tx.Exec(ctx, `insert into public.task_targets(task_id,helper_id) values ($1,$2)`, id, helper); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+	if err := insertChecklistItems(ctx, tx, id, checklist); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
 
 	c.JSON(http.StatusCreated, Task{
 		ID: id, Title: in.Title, Description: in.Description, Category: in.Category,
 		LocationText: in.LocationText, EstimatedMinutes: in.EstimatedMinutes,
 		PrepayAmountCents: in.PrepayAmountCents, IsImmediate: in.IsImmediate,
-		ScheduledAt: when, Requester: requester, Status: "open", CreatedAt: createdAt, AssignedTo: "",
+		ScheduledAt: sched.At, Timezone: sched.Timezone, WindowStart: sched.WindowStart, WindowEnd: sched.WindowEnd,
+		Requester: requester, Status: "open", CreatedAt: createdAt, AssignedTo: "",
+		ExclusiveUntil: exclusiveUntil, RequireChecklist: requireChecklist, Warnings: warnings,
 	})
 }
 
+const taskColumns = `id,title,description,category,location_text,
+           estimated_minutes,prepay_amount_cents,is_immediate,
+           scheduled_at,requester,status,created_at,assigned_to,exclusive_until,
+           rate_cents_per_minute,require_checklist,series_id,occurrence_at,
+           timezone,window_start,window_end`
+
 func scanTask(rows interface{ Scan(dest ...any) error }) (Task, error) {
 	var t Task
 	err := rows.Scan(
 		&t.ID, &t.Title, &t.Description, &t.Category, &t.LocationText,
 		&t.EstimatedMinutes, &t.PrepayAmountCents, &t.IsImmediate,
 		&t.ScheduledAt, &t.Requester, &t.Status, &t.CreatedAt, &t.AssignedTo,
+		&t.ExclusiveUntil, &t.RateCentsPerMinute, &t.RequireChecklist,
+		&t.SeriesID, &t.OccurrenceAt, &t.Timezone, &t.WindowStart, &t.WindowEnd,
 	)
 	return t, err
 }
 
+// listMyTasks / listAvailableTasks / listAssignedTasks / listDoneTasks /
+// listMyPostedClosed accept ?date_from=&date_to= (local days, see scheduling.go).
 func listMyTasks(c *gin.Context) {
-	me := c.GetString("email")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
+	from, to, ok := localDateRange(c)
+	if !ok {
+		return
+	}
 	rows, err := db.Query(ctx, `
-    select id,title,description,category,location_text,
-           estimated_minutes,prepay_amount_cents,is_immediate,
-           scheduled_at,requester,status,created_at,assigned_to
+    select `+taskColumns+`
     from public.tasks
-    where requester = $1
+    where requester = $1`+taskDateFilterSQL("2", "3")+`
     order by created_at desc
-  `, me)
+  `, me, from, to)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
@@ -473,16 +908,17 @@ func listMyTasks(c *gin.Context) {
 		}
 		out = append(out, t)
 	}
+	rows.Close()
+	flagBlockedAssignees(ctx, me, out)
 	c.JSON(http.StatusOK, out)
 }
 
 func getTask(c *gin.Context) {
 	id := c.Param("id")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
 	row := db.QueryRow(ctx, `
-    select id,title,description,category,location_text,
-           estimated_minutes,prepay_amount_cents,is_immediate,
-           scheduled_at,requester,status,created_at,assigned_to
+    select `+taskColumns+`
     from public.tasks where id=$1
   `, id)
 	t, err := scanTask(row)
@@ -490,17 +926,40 @@ func getTask(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
-	c.JSON(http.StatusOK, t)
+	// 封鎖的雙方互相看不到對方的任務（已共用的任務除外）
+	if t.Requester != me && t.AssignedTo != me {
+		if blocked, _ := isBlockedPair(ctx, db, me, t.Requester); blocked {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		if ok, _ := directOfferAllows(ctx, db, t.ID, me, t.ExclusiveUntil); !ok && t.Status == "open" && !hasRole(c, roleModerator) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+	}
+	if cl, err := loadChecklist(ctx, db, t.ID); err == nil && cl.Total > 0 {
+		t.Checklist = &cl
+	}
+	tasks := []Task{t}
+	flagBlockedAssignees(ctx, me, tasks)
+	tasks[0].Warnings = append(tasks[0].Warnings, c.GetStringSlice("warnings")...) // 例如 acceptTask 的行程提醒
+	c.JSON(http.StatusOK, tasks[0])
 }
 
 func updateTask(c *gin.Context) {
 	id := c.Param("id")
-	me := c.GetString("email")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
 
 	// 檢查擁有者 & 狀態
 	var requester, status string
-	if err := db.QueryRow(ctx, `select requester, status from public.tasks where id=$1`, id).Scan(&requester, &status); err != nil {
+	var prev taskSchedule
+	var prevMinutes, rate int
+	if err := db.QueryRow(ctx, `
+    select requester, status, scheduled_at, window_start, window_end, timezone,
+           estimated_minutes, coalesce(rate_cents_per_minute, $2)
+    from public.tasks where id=$1
+  `, id, centsPerMinute).Scan(&requester, &status, &prev.At, &prev.WindowStart, &prev.WindowEnd, &prev.Timezone, &prevMinutes, &rate); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
@@ -518,65 +977,106 @@ func updateTask(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
 		return
 	}
-	in.Title = strings.TrimSpace(in.Title)
-	in.Description = strings.TrimSpace(in.Description)
-	in.Category = strings.TrimSpace(in.Category)
-	in.LocationText = strings.TrimSpace(in.LocationText)
-	if in.Title == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "title required"})
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
 		return
 	}
-	if in.EstimatedMinutes <= 0 {
-		in.EstimatedMinutes = 30
+
+	// 沒改時間的編輯不因「已過去」被擋
+	sched, msg := in.schedule(prev.Timezone, prev)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
 	}
-	if in.Category == "" {
-		in.Category = "task"
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
 	}
-	if in.Category != "task" && in.Category != "companion" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category"})
+	defer tx.Rollback(ctx)
+
+	// 月預算：只檢查增加的估計費用（同 createTask 先鎖 profile）
+	if err := lockBudget(ctx, tx, me); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
-	if in.PrepayAmountCents < 0 {
-		in.PrepayAmountCents = 0
+	warning, reject, err := checkBudget(ctx, tx, me, (in.EstimatedMinutes-prevMinutes)*rate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
 	}
-
-	var when *time.Time
-	if in.IsImmediate {
-		now := time.Now()
-		when = &now
-	} else if strings.TrimSpace(in.ScheduledAt) != "" {
-		tt, err := time.Parse(time.RFC3339, in.ScheduledAt)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "scheduled_at must be RFC3339"})
-			return
-		}
-		when = &tt
+	if reject {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": warning})
+		return
+	}
+	if warning != "" {
+		c.Set("warnings", []string{warning})
 	}
 
-	_, err := db.Exec(ctx, `
+	tag, err := tx.Exec(ctx, `
     update public.tasks
     set title=$1, description=$2, category=$3, location_text=$4,
-        estimated_minutes=$5, prepay_amount_cents=$6, is_immediate=$7, scheduled_at=$8
-    where id=$9
-  `, in.Title, in.Description, in.Category, in.LocationText, in.EstimatedMinutes, in.PrepayAmountCents, in.IsImmediate, when, id)
+        estimated_minutes=$5, prepay_amount_cents=$6, is_immediate=$7, scheduled_at=$8,
+        require_checklist=coalesce($10, require_checklist),
+        timezone=$11, window_start=$12, window_end=$13,
+        series_edited = series_id is not null -- 系列修改不再覆蓋這一場
+    where id=$9 and status='open'
+  `, in.Title, in.Description, in.Category, in.LocationText, in.EstimatedMinutes, in.PrepayAmountCents, in.IsImmediate, sched.At, id, in.RequireChecklist,
+		sched.Timezone, sched.WindowStart, sched.WindowEnd)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only open tasks can be edited"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
 	getTask(c)
 }
 
+// listAvailableTasks: open, unassigned tasks of others; ?q= searches
+// title/description/location. Users in a block with the requester never see
+// the task; direct offers show only to their targets until exclusive_until
+// and are listed first; tasks of requesters with a poor reliability score
+// come last. ?fits_my_schedule=true keeps only tasks that fit the
+// caller's availability and accepted tasks (see availability.go).
 func listAvailableTasks(c *gin.Context) {
-	me := c.GetString("email")
+	me := c.GetString("uid")
+	q := strings.TrimSpace(c.Query("q"))
 	ctx := c.Request.Context()
+	from, to, ok := localDateRange(c)
+	if !ok {
+		return
+	}
+	var hs *helperSchedule
+	if c.Query("fits_my_schedule") == "true" {
+		s, err := loadHelperSchedule(ctx, db, me)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		hs = &s
+	}
+	now := time.Now()
 	rows, err := db.Query(ctx, `
-    select id,title,description,category,location_text,
-           estimated_minutes,prepay_amount_cents,is_immediate,
-           scheduled_at,requester,status,created_at,assigned_to
-    from public.tasks
+    select `+taskColumns+`
+    from public.tasks t
     where status='open' and requester <> $1 and assigned_to = ''
-    order by created_at desc
-  `, me)
+      and ($2 = '' or title ilike '%' || $2 || '%' or description ilike '%' || $2 || '%'
+           or location_text ilike '%' || $2 || '%')
+      and not exists (
+        select 1 from public.user_blocks b
+        where (b.blocker=$1 and b.blocked=t.requester) or (b.blocker=t.requester and b.blocked=$1)
+      )
+      and (exclusive_until is null or exclusive_until <= now()
+           or exists (select 1 from public.task_targets x where x.task_id=t.id and x.helper_id=$1))`+taskDateFilterSQL("3", "4")+`
+    order by (exclusive_until > now()) is true desc, `+flakyRequesterSQL+`, created_at desc
+  `, me, q, from, to)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
@@ -589,22 +1089,27 @@ func listAvailableTasks(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
 			return
 		}
+		if hs != nil && !hs.fits(t, now) {
+			continue
+		}
 		out = append(out, t)
 	}
 	c.JSON(http.StatusOK, out)
 }
 
 func listAssignedTasks(c *gin.Context) {
-	me := c.GetString("email")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
+	from, to, ok := localDateRange(c)
+	if !ok {
+		return
+	}
 	rows, err := db.Query(ctx, `
-    select id,title,description,category,location_text,
-           estimated_minutes,prepay_amount_cents,is_immediate,
-           scheduled_at,requester,status,created_at,assigned_to
+    select `+taskColumns+`
     from public.tasks
-    where assigned_to = $1 and status='open'
+    where assigned_to = $1 and status='open'`+taskDateFilterSQL("2", "3")+`
     order by created_at desc
-  `, me)
+  `, me, from, to)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
@@ -623,16 +1128,18 @@ func listAssignedTasks(c *gin.Context) {
 }
 
 func listDoneTasks(c *gin.Context) {
-	me := c.GetString("email")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
+	from, to, ok := localDateRange(c)
+	if !ok {
+		return
+	}
 	rows, err := db.Query(ctx, `
-    select id,title,description,category,location_text,
-           estimated_minutes,prepay_amount_cents,is_immediate,
-           scheduled_at,requester,status,created_at,assigned_to
+    select `+taskColumns+`
     from public.tasks
-    where assigned_to = $1 and status='completed'
+    where assigned_to = $1 and status='completed'`+taskDateFilterSQL("2", "3")+`
     order by created_at desc
-  `, me)
+  `, me, from, to)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
@@ -651,16 +1158,18 @@ func listDoneTasks(c *gin.Context) {
 }
 
 func listMyPostedClosed(c *gin.Context) {
-	me := c.GetString("email")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
+	from, to, ok := localDateRange(c)
+	if !ok {
+		return
+	}
 	rows, err := db.Query(ctx, `
-    select id,title,description,category,location_text,
-           estimated_minutes,prepay_amount_cents,is_immediate,
-           scheduled_at,requester,status,created_at,assigned_to
+    select `+taskColumns+`
     from public.tasks
-    where requester = $1 and status in ('completed','cancelled')
+    where requester = $1 and status in ('completed','cancelled')`+taskDateFilterSQL("2", "3")+`
     order by created_at desc
-  `, me)
+  `, me, from, to)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
@@ -678,43 +1187,127 @@ func listMyPostedClosed(c *gin.Context) {
 	c.JSON(http.StatusOK, out)
 }
 
-// A user cannot accept their own task. Only open & unassigned tasks can be accepted.
+// checkAssignable: whether helper may take taskID. A user cannot accept their
+// own task. Only open & unassigned tasks can be accepted, not across a block,
+// not during someone else's direct offer, and only with the category's
+// verification level and reliability score. Returns 0 when allowed, else the HTTP status and body.
+func checkAssignable(ctx context.Context, q dbtx, taskID, helper string) (int, gin.H) {
+	var requester, status, assignedTo, category string
+	var exclusiveUntil *time.Time
+	err := q.QueryRow(ctx, `
+    select requester,status,assigned_to,category,exclusive_until from public.tasks where id=$1
+  `, taskID).Scan(&requester, &status, &assignedTo, &category, &exclusiveUntil)
+	if err != nil {
+		return http.StatusNotFound, gin.H{"error": "not found"}
+	}
+	if requester == helper {
+		return http.StatusBadRequest, gin.H{"error": "cannot accept your own task"}
+	}
+	if status != "open" || assignedTo != "" {
+		return http.StatusBadRequest, gin.H{"error": "not available"}
+	}
+	if blocked, err := isBlockedPair(ctx, q, helper, requester); err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "db error"}
+	} else if blocked {
+		return http.StatusForbidden, gin.H{"error": "not available"}
+	}
+	if ok, err := directOfferAllows(ctx, q, taskID, helper, exclusiveUntil); err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "db error"}
+	} else if !ok {
+		return http.StatusForbidden, gin.H{"error": "task is offered to specific helpers until " + exclusiveUntil.Format(time.RFC3339)}
+	}
+	level, err := verificationLevel(ctx, q, helper)
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "db error"}
+	}
+	if need := taskCategories[category].MinVerificationLevel; level < need {
+		return http.StatusForbidden, gin.H{"error": "verification required", "required_level": need, "level": level}
+	}
+	if role := taskCategories[category].RequireRole; role != "" {
+		if ok, err := userHasRole(ctx, q, helper, role); err != nil {
+			return http.StatusInternalServerError, gin.H{"error": "db error"}
+		} else if !ok {
+			return http.StatusForbidden, gin.H{"error": "role required", "required_role": role}
+		}
+	}
+	need := taskCategories[category].MinReliability
+	if ok, r, err := reliabilityAllows(ctx, q, helper, need); err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "db error"}
+	} else if !ok {
+		return http.StatusForbidden, gin.H{"error": "reliability score too low", "required_score": need, "score": r.Score}
+	}
+	return 0, nil
+}
 
+// acceptTask takes the task at its posted terms; pending offers on it are
+// rejected (see offers.go).
 func acceptTask(c *gin.Context) {
 	id := c.Param("id")
-	me := c.GetString("email")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
 
-	var requester, status, assignedTo string
-	err := db.QueryRow(ctx, `select requester,status,assigned_to from public.tasks where id=$1`, id).Scan(&requester, &status, &assignedTo)
+	syncEmailVerification(c) // 等級可能只差 email 尚未同步
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := scanTask(tx.QueryRow(ctx, `select `+taskColumns+` from public.tasks where id=$1 for update`, id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
-	if requester == me {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot accept your own task"})
+	if code, body := checkAssignable(ctx, tx, id, me); code != 0 {
+		c.JSON(code, body)
 		return
 	}
-	if status != "open" || assignedTo != "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "not available"})
+
+	// 行程衝突：和已接的任務撞期直接拒絕（可強制），超出可接時段只提醒
+	hs, err := lockHelperSchedule(ctx, tx, me)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	warnings, code, body := hs.checkScheduleFit(t, c.Query("allow_conflicts") == "true", time.Now())
+	if code != 0 {
+		c.JSON(code, body)
 		return
 	}
+	c.Set("warnings", warnings)
 
-	_, err = db.Exec(ctx, `update public.tasks set assigned_to=$1 where id=$2`, me, id)
+	// 條件寫在 update 內：兩人同時接單只會有一個成功
+	tag, err := tx.Exec(ctx, `
+    update public.tasks set assigned_to=$1 where id=$2 and status='open' and assigned_to=''
+  `, me, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not available"})
+		return
+	}
+	if err := closePendingOffers(ctx, tx, id, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
 
 	getTask(c)
 }
 
 // -------- WorkLog handlers --------
-const centsPerMinute = 50 // 0.5 EUR/min
+const centsPerMinute = 50 // 0.5 EUR/min; default when the task has no agreed rate
 
 func clockIn(c *gin.Context) {
 	taskID := c.Param("id")
-	me := c.GetString("email")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
 
 	var assignedTo, status string
@@ -758,7 +1351,7 @@ func clockIn(c *gin.Context) {
 
 func clockOut(c *gin.Context) {
 	taskID := c.Param("id")
-	me := c.GetString("email")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
 
 	// 找到開著的工時
@@ -791,12 +1384,15 @@ func clockOut(c *gin.Context) {
 
 func getWorklogs(c *gin.Context) {
 	taskID := c.Param("id")
-	me := c.GetString("email")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
 
 	// 權限：作者或接單者
 	var requester, assignedTo string
-	if err := db.QueryRow(ctx, `select requester,assigned_to from public.tasks where id=$1`, taskID).Scan(&requester, &assignedTo); err != nil {
+	var rate int
+	if err := db.QueryRow(ctx, `
+    select requester,assigned_to,coalesce(rate_cents_per_minute,$2) from public.tasks where id=$1
+  `, taskID, centsPerMinute).Scan(&requester, &assignedTo, &rate); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
@@ -838,10 +1434,16 @@ func getWorklogs(c *gin.Context) {
 	var hasOpen bool
 	_ = db.QueryRow(ctx, `select exists (select 1 from public.worklogs where task_id=$1 and end_at is null)`, taskID).Scan(&hasOpen)
 
+	// settlement = 工時費用 + 已核准的代墊費用
+	expensesCents := approvedExpensesCents(ctx, taskID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"items":            items,
 		"total_minutes":    totalMin,
-		"total_cost_cents": totalMin * centsPerMinute,
+		"rate_cents":       rate,
+		"total_cost_cents": totalMin * rate,
+		"expenses_cents":   expensesCents,
+		"total_due_cents":  totalMin*rate + expensesCents,
 		"has_open":         hasOpen,
 	})
 }
@@ -851,14 +1453,31 @@ func getWorklogs(c *gin.Context) {
 // 2) Task must be open and assigned.
 // 3) No open worklog session left.
 // 4) Assignee must have at least one closed worklog.
+// 5) No expense still waiting for the requester's decision.
+// Optional body {"tip_cents": N} (requester only). The invoice is issued in
+// the same transaction as the status change.
 
 func completeTask(c *gin.Context) {
 	taskID := c.Param("id")
-	me := c.GetString("email")
+	me := c.GetString("uid")
 	ctx := c.Request.Context()
 
-	var requester, assignedTo, status string
-	if err := db.QueryRow(ctx, `select requester,assigned_to,status from public.tasks where id=$1`, taskID).Scan(&requester, &assignedTo, &status); err != nil {
+	var in struct {
+		TipCents int `json:"tip_cents"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+			return
+		}
+	}
+	if in.TipCents < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tip_cents must not be negative"})
+		return
+	}
+
+	requester, assignedTo, status, category, err := taskParticipants(ctx, taskID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
@@ -866,6 +1485,10 @@ func completeTask(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
 		return
 	}
+	if in.TipCents > 0 && requester != me {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only requester can tip"})
+		return
+	}
 	if status != "open" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "already closed"})
 		return
@@ -891,11 +1514,67 @@ func completeTask(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one work session is required before completing"})
 		return
 	}
+	if taskCategories[category].RequireProofPhoto {
+		ok, err := hasProofPhoto(ctx, db, taskID, assignedTo)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a proof photo is required before completing"})
+			return
+		}
+	}
+	var pendingExpenses bool
+	if err := db.QueryRow(ctx, `
+    select exists (select 1 from public.task_expenses where task_id=$1 and status='pending')
+  `, taskID).Scan(&pendingExpenses); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if pendingExpenses {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pending expenses must be approved or rejected before completing"})
+		return
+	}
+	pending, err := checklistIncomplete(ctx, db, taskID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if pending {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "all checklist items must be checked before completing"})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
 
-	_, err := db.Exec(ctx, `update public.tasks set status='completed' where id=$1`, taskID)
+	// 同時送出的費用也要擋下：條件寫在 update 內
+	tag, err := tx.Exec(ctx, `
+    update public.tasks set status='completed', completed_at=now(), tip_cents=$2
+    where id=$1 and status='open'
+      and not exists (select 1 from public.task_expenses e where e.task_id=$1 and e.status='pending')
+  `, taskID, in.TipCents)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "already closed"})
+		return
+	}
+	if _, err := issueInvoice(ctx, tx, taskID); err != nil {
+		log.Printf("[invoice] task %s: %v", taskID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
 	getTask(c)
 }
@@ -0,0 +1,19 @@
+-- Expense reimbursement items submitted by the assignee of a task.
+-- receipt_key points into the blob store (see server/blobstore.go).
+
+create table if not exists public.task_expenses (
+  id                   uuid primary key default gen_random_uuid(),
+  task_id              uuid not null references public.tasks(id) on delete cascade,
+  submitted_by         text not null,
+  amount_cents         integer not null check (amount_cents > 0),
+  description          text not null default '',
+  receipt_key          text not null default '',
+  receipt_content_type text not null default '',
+  status               text not null default 'pending'
+                       check (status in ('pending','approved','rejected')),
+  decided_at           timestamptz,
+  created_at           timestamptz not null default now(),
+  updated_at           timestamptz not null default now()
+);
+
+create index if not exists task_expenses_task_idx on public.task_expenses(task_id);
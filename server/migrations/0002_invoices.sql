@@ -0,0 +1,35 @@
+-- Invoices / receipts for completed tasks.
+-- Numbers are HORA-<year>-<seq>, allocated from invoice_counters inside the
+-- completing transaction. Rows are append-only.
+
+alter table public.tasks add column if not exists tip_cents integer not null default 0
+  check (tip_cents >= 0);
+
+create table if not exists public.invoice_counters (
+  year     integer primary key,
+  last_seq integer not null
+);
+
+create table if not exists public.invoices (
+  id         uuid primary key default gen_random_uuid(),
+  task_id    uuid not null unique references public.tasks(id),
+  number     text not null unique,
+  year       integer not null,
+  seq        integer not null,
+  issued_at  timestamptz not null,
+  data       jsonb not null,
+  hash       text not null,
+  unique (year, seq)
+);
+
+create or replace function public.invoices_immutable() returns trigger
+language plpgsql as $$
+begin
+  raise exception 'invoices are immutable';
+end;
+$$;
+
+drop trigger if exists invoices_immutable on public.invoices;
+create trigger invoices_immutable
+  before update or delete on public.invoices
+  for each row execute function public.invoices_immutable();
@@ -0,0 +1,10 @@
+-- When a task was completed; used by earnings / spending reports.
+
+alter table public.tasks add column if not exists completed_at timestamptz;
+
+-- Best-effort backfill: last clock-out of already completed tasks.
+update public.tasks t
+set completed_at = (select max(w.end_at) from public.worklogs w where w.task_id = t.id)
+where t.status = 'completed' and t.completed_at is null;
+
+create index if not exists worklogs_user_end_idx on public.worklogs("user", end_at);
@@ -0,0 +1,7 @@
+-- Optional monthly spending budget for requesters.
+
+alter table public.profiles
+  add column if not exists monthly_budget_cents integer check (monthly_budget_cents > 0),
+  add column if not exists budget_hard_limit boolean not null default false;
+
+create index if not exists tasks_requester_created_idx on public.tasks(requester, created_at);
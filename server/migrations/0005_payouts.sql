@@ -0,0 +1,37 @@
+-- Helper payout accounts and payouts (see server/payouts.go).
+
+create table if not exists public.payout_accounts (
+  "user"      text primary key,
+  holder_name text not null,
+  account_ref text not null,
+  schedule    text not null default 'weekly' check (schedule in ('weekly','on_demand')),
+  updated_at  timestamptz not null default now()
+);
+
+create table if not exists public.payouts (
+  id              uuid primary key default gen_random_uuid(),
+  "user"          text not null,
+  amount_cents    integer not null check (amount_cents > 0),
+  status          text not null default 'pending'
+                  check (status in ('pending','processing','paid','failed')),
+  trigger         text not null check (trigger in ('weekly','on_demand')),
+  attempts        integer not null default 0,
+  last_error      text not null default '',
+  provider_ref    text not null default '',
+  next_attempt_at timestamptz not null default now(),
+  created_at      timestamptz not null default now(),
+  updated_at      timestamptz not null default now(),
+  paid_at         timestamptz
+);
+
+create index if not exists payouts_user_idx on public.payouts("user", created_at desc);
+create index if not exists payouts_due_idx on public.payouts(next_attempt_at) where status = 'pending';
+
+-- A task can belong to at most one (non-failed) payout; rows of failed
+-- payouts are deleted so the task becomes payable again.
+create table if not exists public.payout_items (
+  payout_id    uuid not null references public.payouts(id) on delete cascade,
+  task_id      uuid not null unique references public.tasks(id),
+  amount_cents integer not null,
+  primary key (payout_id, task_id)
+);
@@ -0,0 +1,57 @@
+-- Key users by Supabase auth UUID (JWT sub) instead of email.
+-- Every column that stored an email as identity is rewritten to auth.users.id.
+-- profiles.email stays as display-only data.
+-- Rows whose email has no matching auth user cannot be mapped; profiles are
+-- moved to profiles_orphaned, other tables are left untouched and reported.
+
+begin;
+
+create temporary table email_to_uid on commit drop as
+  select lower(email) as email, id::text as uid
+  from auth.users where email is not null and email <> '';
+
+-- profiles: new primary key user_id
+alter table public.profiles add column if not exists user_id text;
+update public.profiles p set user_id = m.uid
+  from email_to_uid m where lower(p.email) = m.email and p.user_id is null;
+
+create table if not exists public.profiles_orphaned as
+  select * from public.profiles where false;
+insert into public.profiles_orphaned select * from public.profiles where user_id is null;
+delete from public.profiles where user_id is null;
+
+alter table public.profiles drop constraint if exists profiles_pkey;
+alter table public.profiles add primary key (user_id);
+alter table public.profiles alter column email set default '';
+
+-- tasks / worklogs
+update public.tasks t set requester = m.uid
+  from email_to_uid m where lower(t.requester) = m.email;
+update public.tasks t set assigned_to = m.uid
+  from email_to_uid m where t.assigned_to <> '' and lower(t.assigned_to) = m.email;
+update public.worklogs w set "user" = m.uid
+  from email_to_uid m where lower(w."user") = m.email;
+
+-- tables added with expenses / payouts
+update public.task_expenses e set submitted_by = m.uid
+  from email_to_uid m where lower(e.submitted_by) = m.email;
+update public.payout_accounts a set "user" = m.uid
+  from email_to_uid m where lower(a."user") = m.email;
+update public.payouts p set "user" = m.uid
+  from email_to_uid m where lower(p."user") = m.email;
+
+do $$
+declare n integer;
+begin
+  select count(*) into n from public.tasks
+  where requester like '%@%' or assigned_to like '%@%';
+  if n > 0 then
+    raise notice '% task rows still reference an unknown email', n;
+  end if;
+  select count(*) into n from public.profiles_orphaned;
+  if n > 0 then
+    raise notice '% profiles had no auth user and were moved to profiles_orphaned', n;
+  end if;
+end $$;
+
+commit;
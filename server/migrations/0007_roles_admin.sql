@@ -0,0 +1,21 @@
+-- Server-side roles (merged with Supabase app_metadata claims) and the
+-- audit trail for operator actions.
+
+create table if not exists public.user_roles (
+  user_id    text not null,
+  role       text not null check (role in ('helper-verified','moderator','admin')),
+  granted_by text not null default '',
+  created_at timestamptz not null default now(),
+  primary key (user_id, role)
+);
+
+create table if not exists public.admin_audit_log (
+  id         bigserial primary key,
+  actor      text not null,
+  action     text not null,
+  target     text not null default '',
+  detail     jsonb not null default '{}',
+  created_at timestamptz not null default now()
+);
+
+create index if not exists admin_audit_log_target_idx on public.admin_audit_log(target, created_at desc);
@@ -0,0 +1,27 @@
+-- Personal access tokens and service-account credentials (see server/apitokens.go).
+-- Only SHA-256 hashes of the tokens are stored.
+
+create table if not exists public.service_accounts (
+  id          uuid primary key default gen_random_uuid(),
+  name        text not null,
+  created_by  text not null,
+  created_at  timestamptz not null default now(),
+  disabled_at timestamptz
+);
+
+create table if not exists public.api_tokens (
+  id           uuid primary key default gen_random_uuid(),
+  kind         text not null check (kind in ('pat','service')),
+  user_id      text not null, -- owner uid (pat) or service_accounts.id (service)
+  created_by   text not null,
+  name         text not null,
+  token_hash   text not null unique,
+  token_prefix text not null,
+  scopes       text[] not null,
+  created_at   timestamptz not null default now(),
+  expires_at   timestamptz,
+  last_used_at timestamptz,
+  revoked_at   timestamptz
+);
+
+create index if not exists api_tokens_user_idx on public.api_tokens(user_id);
@@ -0,0 +1,23 @@
+-- Devices seen per user (X-Device-ID header) and the session deny-list
+-- checked by authMiddleware (Supabase session_id claim).
+
+create table if not exists public.devices (
+  id         uuid primary key default gen_random_uuid(),
+  user_id    text not null,
+  device_id  text not null,
+  user_agent text not null default '',
+  ip         text not null default '',
+  session_id text not null default '',
+  first_seen timestamptz not null default now(),
+  last_seen  timestamptz not null default now(),
+  revoked_at timestamptz,
+  unique (user_id, device_id)
+);
+
+create table if not exists public.revoked_sessions (
+  session_id text primary key,
+  user_id    text not null,
+  revoked_at timestamptz not null default now()
+);
+
+create index if not exists revoked_sessions_revoked_at_idx on public.revoked_sessions(revoked_at);
@@ -0,0 +1,57 @@
+-- Identity verification tiers (see verification.go).
+-- Level 1: email verified, 2: + phone verified, 3: + ID document approved.
+-- The verified email/phone are stored so changing them drops the level.
+
+alter table public.profiles
+  add column if not exists verified_email    text not null default '',
+  add column if not exists email_verified_at timestamptz,
+  add column if not exists verified_phone    text not null default '',
+  add column if not exists phone_verified_at timestamptz,
+  add column if not exists id_verified_at    timestamptz;
+
+alter table public.profiles
+  add column if not exists verification_level smallint generated always as (
+    case
+      when email_verified_at is null or verified_email = '' or verified_email <> email then 0
+      when phone_verified_at is null or verified_phone = '' or verified_phone <> phone then 1
+      when id_verified_at is null then 2
+      else 3
+    end
+  ) stored;
+
+-- Backfill level 1 for users Supabase already confirmed; otherwise every
+-- existing helper starts at 0 until their next GET /profile.
+update public.profiles p
+  set verified_email = p.email, email_verified_at = u.email_confirmed_at
+  from auth.users u
+  where u.id::text = p.user_id
+    and u.email_confirmed_at is not null
+    and p.email <> '' and lower(p.email) = lower(u.email)
+    and p.email_verified_at is null;
+
+-- One pending code per user; code is stored hashed.
+create table if not exists public.phone_verifications (
+  user_id    text primary key,
+  phone      text not null,
+  code_hash  text not null,
+  attempts   int not null default 0,
+  sent_at    timestamptz not null default now(),
+  expires_at timestamptz not null
+);
+
+create table if not exists public.id_documents (
+  id           uuid primary key default gen_random_uuid(),
+  user_id      text not null,
+  doc_type     text not null check (doc_type in ('passport','id_card','driving_licence','residence_permit')),
+  file_key     text not null,
+  content_type text not null,
+  status       text not null default 'pending' check (status in ('pending','approved','rejected')),
+  reviewed_by  text,
+  reviewed_at  timestamptz,
+  reason       text not null default '',
+  created_at   timestamptz not null default now()
+);
+
+create index if not exists id_documents_status_idx on public.id_documents(status, created_at);
+create unique index if not exists id_documents_one_pending_idx
+  on public.id_documents(user_id) where status = 'pending';
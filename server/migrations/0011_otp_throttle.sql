@@ -0,0 +1,10 @@
+-- Per-email throttling for the server-side OTP login (see otpauth.go).
+
+create table if not exists public.otp_throttle (
+  email           text primary key,
+  window_start    timestamptz not null default now(),
+  request_count   int not null default 0,
+  last_request_at timestamptz not null default 'epoch',
+  failed_attempts int not null default 0,
+  locked_until    timestamptz
+);
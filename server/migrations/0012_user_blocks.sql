@@ -0,0 +1,12 @@
+-- User blocks (see blocks.go). A block hides the pair from each other in
+-- both directions.
+
+create table if not exists public.user_blocks (
+  blocker    text not null,
+  blocked    text not null,
+  created_at timestamptz not null default now(),
+  primary key (blocker, blocked),
+  check (blocker <> blocked)
+);
+
+create index if not exists user_blocks_blocked_idx on public.user_blocks(blocked);
@@ -0,0 +1,21 @@
+-- Favorite helpers and direct task offers (see favorites.go).
+
+create table if not exists public.user_favorites (
+  user_id    text not null,
+  helper_id  text not null,
+  created_at timestamptz not null default now(),
+  primary key (user_id, helper_id),
+  check (user_id <> helper_id)
+);
+
+-- Until exclusive_until only the helpers in task_targets can see and accept
+-- the task; afterwards it is in the public pool.
+alter table public.tasks add column if not exists exclusive_until timestamptz;
+
+create table if not exists public.task_targets (
+  task_id   uuid not null references public.tasks(id) on delete cascade,
+  helper_id text not null,
+  primary key (task_id, helper_id)
+);
+
+create index if not exists task_targets_helper_idx on public.task_targets(helper_id);
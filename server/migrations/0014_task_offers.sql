@@ -0,0 +1,25 @@
+-- Offers / counter-offers on open tasks (see offers.go).
+
+-- Agreed per-minute rate from an accepted offer; null = default rate.
+alter table public.tasks add column if not exists rate_cents_per_minute int
+  check (rate_cents_per_minute is null or rate_cents_per_minute > 0);
+
+create table if not exists public.task_offers (
+  id                    uuid primary key default gen_random_uuid(),
+  task_id               uuid not null references public.tasks(id) on delete cascade,
+  helper_id             text not null,
+  proposed_by           text not null check (proposed_by in ('helper','requester')),
+  rate_cents_per_minute int not null check (rate_cents_per_minute > 0),
+  estimated_minutes     int not null check (estimated_minutes > 0),
+  message               text not null default '',
+  status                text not null default 'pending'
+                        check (status in ('pending','accepted','rejected','withdrawn','countered','expired')),
+  expires_at            timestamptz not null,
+  decided_at            timestamptz,
+  created_at            timestamptz not null default now()
+);
+
+-- One open proposal per (task, helper) thread.
+create unique index if not exists task_offers_one_pending_idx
+  on public.task_offers(task_id, helper_id) where status = 'pending';
+create index if not exists task_offers_task_idx on public.task_offers(task_id, created_at);
@@ -0,0 +1,15 @@
+-- Two-sided, double-blind reviews of completed tasks (see reviews.go).
+
+create table if not exists public.task_reviews (
+  id         uuid primary key default gen_random_uuid(),
+  task_id    uuid not null references public.tasks(id) on delete cascade,
+  reviewer   text not null,
+  reviewee   text not null,
+  role       text not null check (role in ('requester','assignee')), -- reviewer's role on the task
+  stars      smallint not null check (stars between 1 and 5),
+  body       text not null default '',
+  created_at timestamptz not null default now(),
+  unique (task_id, reviewer)
+);
+
+create index if not exists task_reviews_reviewee_idx on public.task_reviews(reviewee, created_at desc);
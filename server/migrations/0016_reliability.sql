@@ -0,0 +1,65 @@
+-- Reliability scores (see reliability.go). Triggers queue users whose
+-- underlying data changed; reliabilityLoop recomputes only those.
+
+create table if not exists public.reliability_scores (
+  user_id     text primary key,
+  score       numeric(5,2) not null,
+  engagements int not null default 0,
+  components  jsonb not null default '{}',
+  computed_at timestamptz not null default now()
+);
+
+create table if not exists public.reliability_dirty (
+  user_id   text primary key,
+  marked_at timestamptz not null default now()
+);
+
+create or replace function public.mark_reliability_dirty(uid text) returns void
+language sql as $$
+  insert into public.reliability_dirty(user_id) values (uid)
+  on conflict (user_id) do nothing;
+$$;
+
+create or replace function public.reliability_tasks_trg() returns trigger
+language plpgsql as $$
+begin
+  if new.assigned_to <> '' then perform public.mark_reliability_dirty(new.assigned_to); end if;
+  perform public.mark_reliability_dirty(new.requester);
+  if tg_op = 'UPDATE' and old.assigned_to <> '' and old.assigned_to <> new.assigned_to then
+    perform public.mark_reliability_dirty(old.assigned_to);
+  end if;
+  return new;
+end $$;
+
+drop trigger if exists reliability_tasks on public.tasks;
+create trigger reliability_tasks after insert or update of assigned_to, status, scheduled_at on public.tasks
+  for each row execute function public.reliability_tasks_trg();
+
+create or replace function public.reliability_worklogs_trg() returns trigger
+language plpgsql as $$
+begin
+  perform public.mark_reliability_dirty(new."user");
+  return new;
+end $$;
+
+drop trigger if exists reliability_worklogs on public.worklogs;
+create trigger reliability_worklogs after insert or update on public.worklogs
+  for each row execute function public.reliability_worklogs_trg();
+
+create or replace function public.reliability_expenses_trg() returns trigger
+language plpgsql as $$
+begin
+  perform public.mark_reliability_dirty(new.submitted_by);
+  return new;
+end $$;
+
+drop trigger if exists reliability_expenses on public.task_expenses;
+create trigger reliability_expenses after update of status on public.task_expenses
+  for each row execute function public.reliability_expenses_trg();
+
+-- 初次計算：所有曾接單或發單的人
+insert into public.reliability_dirty(user_id)
+select assigned_to from public.tasks where assigned_to <> ''
+union
+select requester from public.tasks
+on conflict do nothing;
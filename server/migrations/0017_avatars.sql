@@ -0,0 +1,10 @@
+-- Server-managed avatars (see avatar.go).
+-- avatar_key is the blob prefix of the current upload; avatar_url is derived
+-- from it and can no longer be set by clients.
+
+alter table public.profiles
+  add column if not exists avatar_key text not null default '';
+
+-- Existing avatar_url values were free text supplied by clients (hotlinks,
+-- tracking pixels); drop them, users re-upload.
+update public.profiles set avatar_url = '' where avatar_key = '' and avatar_url <> '';
@@ -0,0 +1,17 @@
+-- Task photo attachments (see attachments.go).
+-- role: brief = requester's reference photos, proof = assignee's proof of work.
+
+create table if not exists public.task_attachments (
+  id          uuid primary key default gen_random_uuid(),
+  task_id     uuid not null references public.tasks(id) on delete cascade,
+  role        text not null check (role in ('brief','proof')),
+  uploaded_by text not null,
+  file_key    text not null,
+  width       int not null,
+  height      int not null,
+  created_at  timestamptz not null default now(),
+  deleted_at  timestamptz
+);
+
+create index if not exists task_attachments_task_idx
+  on public.task_attachments(task_id, created_at) where deleted_at is null;
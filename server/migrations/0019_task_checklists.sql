@@ -0,0 +1,17 @@
+-- Task checklists (see checklist.go).
+
+alter table public.tasks
+  add column if not exists require_checklist boolean not null default false;
+
+create table if not exists public.task_checklist_items (
+  id         uuid primary key default gen_random_uuid(),
+  task_id    uuid not null references public.tasks(id) on delete cascade,
+  position   int not null,
+  text       text not null check (length(text) between 1 and 200),
+  checked_at timestamptz,
+  checked_by text not null default '',
+  created_at timestamptz not null default now()
+);
+
+create index if not exists task_checklist_items_task_idx
+  on public.task_checklist_items(task_id, position);
@@ -0,0 +1,42 @@
+-- Recurring tasks (see recurring.go).
+
+create table if not exists public.task_series (
+  id                      uuid primary key default gen_random_uuid(),
+  requester               text not null,
+  title                   text not null,
+  description             text not null default '',
+  category                text not null default 'task',
+  location_text           text not null default '',
+  estimated_minutes       int not null default 30,
+  prepay_amount_cents     int not null default 0,
+  checklist               text[] not null default '{}',
+  require_checklist       boolean not null default false,
+  rrule                   text not null,
+  timezone                text not null,
+  start_at                timestamptz not null,
+  offer_previous_assignee boolean not null default false,
+  exclusive_minutes       int not null default 0,
+  status                  text not null default 'active'
+                          check (status in ('active','ended','cancelled')),
+  generated_until         timestamptz not null default now(),
+  created_at              timestamptz not null default now(),
+  updated_at              timestamptz not null default now()
+);
+
+create index if not exists task_series_requester_idx on public.task_series(requester, created_at desc);
+create index if not exists task_series_due_idx on public.task_series(generated_until) where status = 'active';
+
+-- Skipped (cancelled) occurrences, including ones not generated yet.
+create table if not exists public.task_series_skips (
+  series_id     uuid not null references public.task_series(id) on delete cascade,
+  occurrence_at timestamptz not null,
+  primary key (series_id, occurrence_at)
+);
+
+alter table public.tasks
+  add column if not exists series_id uuid references public.task_series(id) on delete set null,
+  add column if not exists occurrence_at timestamptz,
+  add column if not exists series_edited boolean not null default false;
+
+-- One task per slot; plain (non-partial) so ON CONFLICT (series_id, occurrence_at) works.
+create unique index if not exists tasks_series_occurrence_uidx on public.tasks(series_id, occurrence_at);
@@ -0,0 +1,23 @@
+-- Saved task templates (see templates.go).
+
+create table if not exists public.task_templates (
+  id                  uuid primary key default gen_random_uuid(),
+  owner               text not null,
+  name                text not null,
+  title               text not null,
+  description         text not null default '',
+  category            text not null default 'task',
+  locations           jsonb not null default '[]',
+  estimated_minutes   int not null default 30,
+  prepay_amount_cents int not null default 0,
+  is_immediate        boolean not null default false,
+  direct_to           text[] not null default '{}',
+  direct_to_favorites boolean not null default false,
+  exclusive_minutes   int not null default 0,
+  checklist           text[] not null default '{}',
+  require_checklist   boolean not null default false,
+  created_at          timestamptz not null default now(),
+  updated_at          timestamptz not null default now()
+);
+
+create index if not exists task_templates_owner_idx on public.task_templates(owner, name);
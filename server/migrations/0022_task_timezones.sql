@@ -0,0 +1,21 @@
+-- Time zones and flexible time windows (see scheduling.go).
+
+alter table public.profiles
+  add column if not exists timezone text not null default 'UTC';
+
+alter table public.tasks
+  add column if not exists timezone     text not null default 'UTC',
+  add column if not exists window_start timestamptz,
+  add column if not exists window_end   timestamptz;
+
+-- A window has both ends; scheduled_at mirrors window_start.
+alter table public.tasks drop constraint if exists tasks_window_check;
+alter table public.tasks add constraint tasks_window_check
+  check ((window_start is null) = (window_end is null) and (window_end is null or window_end > window_start));
+
+-- Existing series occurrences: take the series' zone.
+update public.tasks t set timezone = s.timezone
+from public.task_series s
+where t.series_id = s.id and t.timezone = 'UTC';
+
+create index if not exists tasks_window_idx on public.tasks(coalesce(window_end, scheduled_at)) where status = 'open';
@@ -0,0 +1,26 @@
+-- Helper weekly availability and time off (see availability.go).
+
+create table if not exists public.helper_availability (
+  id           uuid primary key default gen_random_uuid(),
+  user_id      text not null,
+  weekday      smallint not null check (weekday between 1 and 7), -- ISO: 1 = Monday
+  start_minute int not null check (start_minute between 0 and 1439),
+  end_minute   int not null check (end_minute between 1 and 1440),
+  check (end_minute > start_minute)
+);
+
+create index if not exists helper_availability_user_idx on public.helper_availability(user_id, weekday, start_minute);
+
+create table if not exists public.helper_time_off (
+  id         uuid primary key default gen_random_uuid(),
+  user_id    text not null,
+  start_at   timestamptz not null,
+  end_at     timestamptz not null check (end_at > start_at),
+  note       text not null default '',
+  created_at timestamptz not null default now()
+);
+
+create index if not exists helper_time_off_user_idx on public.helper_time_off(user_id, end_at);
+
+-- Conflict checks read the helper's open assignments.
+create index if not exists tasks_assigned_open_idx on public.tasks(assigned_to) where status = 'open';
@@ -0,0 +1,5 @@
+-- Who cancelled a task, so reliability only blames that side (see reliability.go).
+-- '' = unknown (cancelled before this column existed) or an admin decision.
+
+alter table public.tasks add column if not exists cancelled_by text not null default ''
+  check (cancelled_by in ('', 'requester', 'helper', 'admin'));
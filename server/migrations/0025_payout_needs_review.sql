@@ -0,0 +1,7 @@
+-- Payouts whose last send attempt failed are no longer auto-released: the
+-- transfer may have gone out with the error lost, so they wait in
+-- needs_review (tasks still attached) until an admin reconciles them.
+alter table public.payouts drop constraint if exists payouts_status_check;
+alter table public.payouts add constraint payouts_status_check
+  check (status in ('pending','processing','paid','needs_review','failed'));
+create index if not exists payouts_review_idx on public.payouts(updated_at) where status = 'needs_review';
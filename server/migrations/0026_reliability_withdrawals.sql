@@ -0,0 +1,18 @@
+-- Offer withdrawals count towards reliability (see reliability.go): queue the
+-- helper whenever a thread changes, since a new proposal un-counts the
+-- withdrawal before it.
+
+create or replace function public.reliability_offers_trg() returns trigger
+language plpgsql as $$
+begin
+  perform public.mark_reliability_dirty(new.helper_id);
+  return new;
+end $$;
+
+drop trigger if exists reliability_offers on public.task_offers;
+create trigger reliability_offers after insert or update of status on public.task_offers
+  for each row execute function public.reliability_offers_trg();
+
+insert into public.reliability_dirty(user_id)
+select distinct helper_id from public.task_offers where status = 'withdrawn'
+on conflict do nothing;
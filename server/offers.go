@@ -0,0 +1,467 @@
+package main
+
+// Offers on open tasks. Instead of accepting at the posted terms a helper
+// can propose a per-minute rate, estimated minutes and a message.
+// Each (task, helper) pair is one negotiation thread with at most one
+// pending proposal:
+//   - the helper posts an offer; posting again replaces their own pending one
+//   - the requester may counter it, which creates a proposal from their side
+//   - whoever did not write the pending proposal can accept or reject it;
+//     the author can withdraw it
+// Accepting assigns the task atomically with the agreed rate/minutes (checked
+// against the requester's monthly budget) and closes every other pending
+// offer on the task. Offers expire at expires_at (marked lazily whenever the
+// task's offers are read or acted on).
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultOfferTTL    = 48 * time.Hour
+	maxOfferTTL        = 7 * 24 * time.Hour
+	maxOfferRateCents  = 1000 // per minute
+	maxOfferMinutes    = 7 * 24 * 60
+	maxOfferMessageLen = 1000
+	offerByHelper      = "helper"
+	offerByRequester   = "requester"
+	offerStatusPending = "pending"
+)
+
+type Offer struct {
+	ID                 string     `json:"id"`
+	TaskID             string     `json:"task_id"`
+	HelperID           string     `json:"helper_id"`
+	ProposedBy         string     `json:"proposed_by"` // helper | requester
+	RateCentsPerMinute int        `json:"rate_cents_per_minute"`
+	EstimatedMinutes   int        `json:"estimated_minutes"`
+	EstimatedCents     int        `json:"estimated_cents"`
+	Message            string     `json:"message"`
+	Status             string     `json:"status"` // pending | accepted | rejected | withdrawn | countered | expired
+	ExpiresAt          time.Time  `json:"expires_at"`
+	DecidedAt          *time.Time `json:"decided_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+const offerColumns = `id,task_id,helper_id,proposed_by,rate_cents_per_minute,estimated_minutes,message,status,expires_at,decided_at,created_at`
+
+func scanOffer(rows interface{ Scan(dest ...any) error }) (Offer, error) {
+	var o Offer
+	err := rows.Scan(&o.ID, &o.TaskID, &o.HelperID, &o.ProposedBy, &o.RateCentsPerMinute, &o.EstimatedMinutes,
+		&o.Message, &o.Status, &o.ExpiresAt, &o.DecidedAt, &o.CreatedAt)
+	o.EstimatedCents = o.RateCentsPerMinute * o.EstimatedMinutes
+	return o, err
+}
+
+type offerInput struct {
+	RateCentsPerMinute int    `json:"rate_cents_per_minute"`
+	EstimatedMinutes   int    `json:"estimated_minutes"`
+	Message            string `json:"message"`
+	ExpiresInMinutes   int    `json:"expires_in_minutes"` // default 48h, max 7 days
+}
+
+func (in *offerInput) validate() string {
+	in.Message = strings.TrimSpace(in.Message)
+	switch {
+	case in.RateCentsPerMinute <= 0 || in.RateCentsPerMinute > maxOfferRateCents:
+		return "rate_cents_per_minute must be between 1 and 1000"
+	case in.EstimatedMinutes <= 0 || in.EstimatedMinutes > maxOfferMinutes:
+		return "estimated_minutes must be positive"
+	case len(in.Message) > maxOfferMessageLen:
+		return "message too long"
+	}
+	return ""
+}
+
+func (in offerInput) expiresAt() time.Time {
+	ttl := time.Duration(in.ExpiresInMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = defaultOfferTTL
+	}
+	return time.Now().Add(min(ttl, maxOfferTTL))
+}
+
+// expireOffers marks the task's overdue pending offers expired.
+func expireOffers(ctx context.Context, q dbtx, taskID string) error {
+	_, err := q.Exec(ctx, `
+    update public.task_offers set status='expired', decided_at=now()
+    where task_id=$1 and status='pending' and expires_at <= now()
+  `, taskID)
+	return err
+}
+
+// closePendingOffers rejects the task's pending offers except keepID
+// (used once the task is assigned).
+func closePendingOffers(ctx context.Context, q dbtx, taskID, keepID string) error {
+	_, err := q.Exec(ctx, `
+    update public.task_offers set status='rejected', decided_at=now()
+    where task_id=$1 and status='pending' and id::text <> $2
+  `, taskID, keepID)
+	return err
+}
+
+// insertOffer adds a proposal to the (task, helper) thread, retiring the
+// pending one: "withdrawn" if the same side wrote it, "countered" otherwise.
+func insertOffer(ctx context.Context, tx pgx.Tx, taskID, helper, by string, in offerInput) (Offer, error) {
+	if _, err := tx.Exec(ctx, `
+    update public.task_offers
+    set status = case when proposed_by=$3 then 'withdrawn' else 'countered' end, decided_at=now()
+    where task_id=$1 and helper_id=$2 and status='pending'
+  `, taskID, helper, by); err != nil {
+		return Offer{}, err
+	}
+	return scanOffer(tx.QueryRow(ctx, `
+    insert into public.task_offers(task_id,helper_id,proposed_by,rate_cents_per_minute,estimated_minutes,message,expires_at)
+    values ($1,$2,$3,$4,$5,$6,$7)
+    returning `+offerColumns, taskID, helper, by, in.RateCentsPerMinute, in.EstimatedMinutes, in.Message, in.expiresAt()))
+}
+
+// -------- Offer handlers --------
+
+// POST /tasks/:id/offers — helper proposes terms (same eligibility as acceptTask).
+func createOffer(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+	syncEmailVerification(c)
+
+	var in offerInput
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// 鎖住任務列，避免與 acceptOffer / acceptTask 交錯
+	if _, err := tx.Exec(ctx, `select 1 from public.tasks where id::text=$1 for update`, taskID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if code, body := checkAssignable(ctx, tx, taskID, me); code != 0 {
+		c.JSON(code, body)
+		return
+	}
+	if err := expireOffers(ctx, tx, taskID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	o, err := insertOffer(ctx, tx, taskID, me, offerByHelper, in)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, o)
+}
+
+// GET /tasks/:id/offers — the requester sees every thread, a helper only theirs.
+func listOffers(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var requester string
+	if err := db.QueryRow(ctx, `select requester from public.tasks where id=$1`, taskID).Scan(&requester); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	// 代入檢視時不做順手的寫入（見 rbac.go impersonating）
+	if !impersonating(c) {
+		if err := expireOffers(ctx, db, taskID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+	helper := me
+	if requester == me {
+		helper = ""
+	}
+	rows, err := db.Query(ctx, `
+    select `+offerColumns+` from public.task_offers
+    where task_id=$1 and ($2 = '' or helper_id = $2)
+    order by (status = 'pending') desc, created_at desc
+  `, taskID, helper)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []Offer{}
+	for rows.Next() {
+		o, err := scanOffer(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, o)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// lockPendingOffer loads a pending, unexpired offer of the task for update
+// together with the task's requester.
+func lockPendingOffer(ctx context.Context, tx pgx.Tx, taskID, offerID string) (Offer, string, int, gin.H) {
+	var requester string
+	if err := tx.QueryRow(ctx, `select requester from public.tasks where id=$1 for update`, taskID).Scan(&requester); err != nil {
+		return Offer{}, "", http.StatusNotFound, gin.H{"error": "not found"}
+	}
+	if err := expireOffers(ctx, tx, taskID); err != nil {
+		return Offer{}, "", http.StatusInternalServerError, gin.H{"error": "db error"}
+	}
+	o, err := scanOffer(tx.QueryRow(ctx, `
+    select `+offerColumns+` from public.task_offers where id::text=$1 and task_id=$2 for update
+  `, offerID, taskID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Offer{}, "", http.StatusNotFound, gin.H{"error": "not found"}
+	}
+	if err != nil {
+		return Offer{}, "", http.StatusInternalServerError, gin.H{"error": "db error"}
+	}
+	if o.Status != offerStatusPending {
+		return Offer{}, "", http.StatusBadRequest, gin.H{"error": "offer is " + o.Status}
+	}
+	return o, requester, 0, nil
+}
+
+// counterpartyOf: the user who must answer o (not its author).
+func counterpartyOf(o Offer, requester string) string {
+	if o.ProposedBy == offerByHelper {
+		return requester
+	}
+	return o.HelperID
+}
+
+// authorOf: the user who wrote o.
+func authorOf(o Offer, requester string) string {
+	if o.ProposedBy == offerByHelper {
+		return o.HelperID
+	}
+	return requester
+}
+
+// POST /tasks/:id/offers/:offerId/accept[?allow_conflicts=true] — assigns the
+// task on the offer's terms; schedule conflicts as in acceptTask.
+func acceptOffer(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+	syncEmailVerification(c) // 幫手接受還價時
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	o, requester, code, body := lockPendingOffer(ctx, tx, taskID, c.Param("offerId"))
+	if code != 0 {
+		c.JSON(code, body)
+		return
+	}
+	if counterpartyOf(o, requester) != me {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+		return
+	}
+	// 重新檢查：提出報價後可能已被封鎖、驗證等級下降或已有人接單
+	if code, body := checkAssignable(ctx, tx, taskID, o.HelperID); code != 0 {
+		c.JSON(code, body)
+		return
+	}
+
+	isHelper := me == o.HelperID
+
+	// 月預算：任務原本的估計已算在本月內，只檢查議定條件多出的部分。
+	// 預算是委託人的私事：幫手接受還價時超出上限只回中性錯誤，也不附預算警告
+	var posted int
+	if err := tx.QueryRow(ctx, `
+    select estimated_minutes * coalesce(rate_cents_per_minute, $2) from public.tasks where id=$1
+  `, taskID, centsPerMinute).Scan(&posted); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	warning, reject, err := checkBudget(ctx, tx, requester, o.EstimatedCents-posted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if reject {
+		if isHelper {
+			warning = "the requester can no longer accept these terms"
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": warning})
+		return
+	}
+	var warnings []string
+	if warning != "" && !isHelper {
+		warnings = append(warnings, warning)
+	}
+
+	// 行程衝突（見 availability.go），以議定的時長計算。委託人接受時不透露幫手的其他任務，也不能強制
+	t, err := scanTask(tx.QueryRow(ctx, `select `+taskColumns+` from public.tasks where id=$1`, taskID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	t.EstimatedMinutes = o.EstimatedMinutes
+	hs, err := lockHelperSchedule(ctx, tx, o.HelperID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	scheduleWarnings, code, body := hs.checkScheduleFit(t, isHelper && c.Query("allow_conflicts") == "true", time.Now())
+	if code != 0 {
+		if !isHelper {
+			body = gin.H{"error": "the helper has a schedule conflict at this time"}
+		}
+		c.JSON(code, body)
+		return
+	}
+	if isHelper {
+		warnings = append(warnings, scheduleWarnings...)
+	}
+	if len(warnings) > 0 {
+		c.Set("warnings", warnings)
+	}
+
+	tag, err := tx.Exec(ctx, `
+    update public.tasks
+    set assigned_to=$2, rate_cents_per_minute=$3, estimated_minutes=$4
+    where id=$1 and status='open' and assigned_to=''
+  `, taskID, o.HelperID, o.RateCentsPerMinute, o.EstimatedMinutes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not available"})
+		return
+	}
+	if _, err := tx.Exec(ctx, `
+    update public.task_offers set status='accepted', decided_at=now() where id=$1
+  `, o.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := closePendingOffers(ctx, tx, taskID, o.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	getTask(c)
+}
+
+// POST /tasks/:id/offers/:offerId/counter — requester answers with other terms.
+func counterOffer(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var in offerInput
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	o, requester, code, body := lockPendingOffer(ctx, tx, taskID, c.Param("offerId"))
+	if code != 0 {
+		c.JSON(code, body)
+		return
+	}
+	// 幫手要改條件就直接再送一次 createOffer
+	if requester != me || o.ProposedBy != offerByHelper {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the requester can counter a helper's offer"})
+		return
+	}
+	if code, body := checkAssignable(ctx, tx, taskID, o.HelperID); code != 0 {
+		c.JSON(code, body)
+		return
+	}
+	n, err := insertOffer(ctx, tx, taskID, o.HelperID, offerByRequester, in)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, n)
+}
+
+func rejectOffer(c *gin.Context)   { closeOffer(c, "rejected") }
+func withdrawOffer(c *gin.Context) { closeOffer(c, "withdrawn") }
+
+// closeOffer: the counterparty rejects, the author withdraws.
+func closeOffer(c *gin.Context, status string) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	o, requester, code, body := lockPendingOffer(ctx, tx, taskID, c.Param("offerId"))
+	if code != 0 {
+		c.JSON(code, body)
+		return
+	}
+	allowed := counterpartyOf(o, requester)
+	if status == "withdrawn" {
+		allowed = authorOf(o, requester)
+	}
+	if allowed != me {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+		return
+	}
+	o, err = scanOffer(tx.QueryRow(ctx, `
+    update public.task_offers set status=$2, decided_at=now() where id=$1
+    returning `+offerColumns, o.ID, status))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, o)
+}
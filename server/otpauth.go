@@ -0,0 +1,497 @@
+package main
+
+// Server-mediated email OTP login for native/CLI clients
+// (the web app still talks to Supabase directly).
+//   POST /auth/request-otp {"email"}          → 202, code sent by email
+//   POST /auth/verify      {"email","code"}   → Supabase session
+// Limits (per email, stored in otp_throttle; per IP, in memory — the IP is
+// the peer address unless it is listed in TRUSTED_PROXIES, see main.go):
+//   - one code per otpResendAfter, otpMaxRequests per otpRequestWindow
+//   - otpMaxFailures verify attempts lock the email for otpLockout; each
+//     attempt is counted atomically before the code is checked, so parallel
+//     guesses cannot overshoot, and a correct code resets the count
+//   - otpIPMaxVerifies verify attempts per IP per otpLockout
+// Optional ENV:
+//   OTP_BACKEND=gotrue|fake     default gotrue when SUPABASE_ANON_KEY is set,
+//                               otherwise the endpoints answer 503
+//   SUPABASE_ANON_KEY           apikey for GoTrue
+//   HORA_DEV_MODE=true          required for OTP_BACKEND=fake
+// The fake backend logs codes and signs HS256 sessions with
+// SUPABASE_JWT_SECRET — anyone reading the logs can log in as anyone — so
+// it refuses to start unless HORA_DEV_MODE is set (local dev only).
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	otpResendAfter     = time.Minute
+	otpRequestWindow   = time.Hour
+	otpMaxRequests     = 5
+	otpMaxFailures     = 5
+	otpLockout         = 15 * time.Minute
+	otpIPMaxRequests   = 30 // per otpRequestWindow
+	otpIPMaxVerifies   = 20 // per otpLockout
+	otpUpstreamTimeout = 10 * time.Second
+)
+
+var otpBackend OTPBackend
+
+var authOTPEvents = expvar.NewMap("auth_otp")
+
+// errOTPInvalid: wrong or expired code (counts toward the lockout).
+var errOTPInvalid = errors.New("invalid or expired code")
+
+type OTPSession struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	User         struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	} `json:"user"`
+}
+
+type OTPBackend interface {
+	Name() string
+	// Request sends a login code to email (creating the user if needed).
+	Request(ctx context.Context, email string) error
+	// Verify exchanges the code for a session; errOTPInvalid on a bad code.
+	Verify(ctx context.Context, email, code string) (*OTPSession, error)
+}
+
+func initOTPBackend(issuer string) {
+	anonKey := strings.TrimSpace(os.Getenv("SUPABASE_ANON_KEY"))
+	backend := strings.TrimSpace(os.Getenv("OTP_BACKEND"))
+	if backend == "" && anonKey != "" {
+		backend = "gotrue"
+	}
+	switch backend {
+	case "":
+		log.Printf("[otp] disabled (set SUPABASE_ANON_KEY or OTP_BACKEND)")
+		return
+	case "gotrue":
+		if anonKey == "" {
+			log.Fatal("OTP_BACKEND=gotrue requires SUPABASE_ANON_KEY")
+		}
+		otpBackend = &goTrueOTPBackend{
+			baseURL: issuer,
+			apiKey:  anonKey,
+			client:  &http.Client{Timeout: otpUpstreamTimeout},
+		}
+	case "fake":
+		if !devMode() {
+			log.Fatal("OTP_BACKEND=fake mints real session tokens; set HORA_DEV_MODE=true (local dev only)")
+		}
+		secret := strings.TrimSpace(os.Getenv("SUPABASE_JWT_SECRET"))
+		if secret == "" {
+			log.Fatal("OTP_BACKEND=fake requires SUPABASE_JWT_SECRET")
+		}
+		otpBackend = &fakeOTPBackend{issuer: issuer, secret: []byte(secret), codes: map[string]fakeOTPCode{}}
+	default:
+		log.Fatalf("unknown OTP_BACKEND %q", backend)
+	}
+	log.Printf("[otp] backend: %s", otpBackend.Name())
+}
+
+func normalizeEmail(s string) (string, bool) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(s))
+	if err != nil || addr.Name != "" {
+		return "", false
+	}
+	return strings.ToLower(addr.Address), true
+}
+
+// -------- Throttling --------
+
+// ipLimiter is a fixed-window counter per client IP (per instance).
+type ipLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*ipWindow
+}
+
+type ipWindow struct {
+	start time.Time
+	count int
+}
+
+var (
+	otpIPLimiter       = &ipLimiter{windows: map[string]*ipWindow{}}
+	otpVerifyIPLimiter = &ipLimiter{windows: map[string]*ipWindow{}}
+)
+
+func (l *ipLimiter) allow(ip string, limit int, window time.Duration) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.windows) > 10000 { // 簡單防爆：清掉過期的
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= window {
+				delete(l.windows, k)
+			}
+		}
+	}
+	w := l.windows[ip]
+	if w == nil || now.Sub(w.start) >= window {
+		w = &ipWindow{start: now}
+		l.windows[ip] = w
+	}
+	if w.count >= limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// reserveOTPRequest counts a send for email. ok=false means throttled or
+// locked; wait then says when to come back.
+func reserveOTPRequest(ctx context.Context, email string) (ok bool, wait time.Duration, err error) {
+	now := time.Now()
+	windowCutoff := now.Add(-otpRequestWindow)
+	var id string
+	err = db.QueryRow(ctx, `
+    insert into public.otp_throttle as t(email,window_start,request_count,last_request_at)
+    values ($1,$2,1,$2)
+    on conflict (email) do update
+    set window_start    = case when t.window_start < $3 then $2 else t.window_start end,
+        request_count   = case when t.window_start < $3 then 1 else t.request_count + 1 end,
+        last_request_at = $2
+    where (t.locked_until is null or t.locked_until <= $2)
+      and t.last_request_at <= $4
+      and (t.window_start < $3 or t.request_count < $5)
+    returning email
+  `, email, now, windowCutoff, now.Add(-otpResendAfter), otpMaxRequests).Scan(&id)
+	if err == nil {
+		return true, 0, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return false, 0, err
+	}
+
+	var windowStart, lastRequest time.Time
+	var count int
+	var lockedUntil *time.Time
+	if err := db.QueryRow(ctx, `
+    select window_start, request_count, last_request_at, locked_until
+    from public.otp_throttle where email=$1
+  `, email).Scan(&windowStart, &count, &lastRequest, &lockedUntil); err != nil {
+		return false, 0, err
+	}
+	retry := lastRequest.Add(otpResendAfter).Sub(now)
+	if count >= otpMaxRequests {
+		retry = max(retry, windowStart.Add(otpRequestWindow).Sub(now))
+	}
+	if lockedUntil != nil {
+		retry = max(retry, lockedUntil.Sub(now))
+	}
+	return false, max(retry, time.Second), nil
+}
+
+// reserveOTPAttempt counts one verify attempt for email before the code is
+// checked. The increment and the lockout check are a single statement, so
+// concurrent guesses are serialised on the row; the otpMaxFailures-th
+// attempt sets the lock itself (a correct code clears it again).
+// ok=false: locked, wait says for how long. left: attempts remaining after
+// this one if it fails.
+func reserveOTPAttempt(ctx context.Context, email string) (ok bool, left int, wait time.Duration, err error) {
+	now := time.Now()
+	var attempts int
+	err = db.QueryRow(ctx, `
+    insert into public.otp_throttle as t(email,failed_attempts) values ($1,1)
+    on conflict (email) do update
+    set failed_attempts = case when t.locked_until <= $2 then 1 else t.failed_attempts + 1 end,
+        locked_until    = case when (case when t.locked_until <= $2 then 1 else t.failed_attempts + 1 end) >= $3
+                               then $4::timestamptz end
+    where t.locked_until is null or t.locked_until <= $2
+    returning failed_attempts
+  `, email, now, otpMaxFailures, now.Add(otpLockout)).Scan(&attempts)
+	if err == nil {
+		return true, otpMaxFailures - attempts, 0, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return false, 0, 0, err
+	}
+	var lockedUntil time.Time
+	if err := db.QueryRow(ctx, `select locked_until from public.otp_throttle where email=$1`, email).Scan(&lockedUntil); err != nil {
+		return false, 0, 0, err
+	}
+	return false, 0, max(lockedUntil.Sub(now), time.Second), nil
+}
+
+// releaseOTPAttempt un-counts an attempt whose code was never checked
+// (upstream error), lifting a lock that attempt set.
+func releaseOTPAttempt(ctx context.Context, email string) {
+	if _, err := db.Exec(ctx, `
+    update public.otp_throttle
+    set failed_attempts = greatest(failed_attempts - 1, 0),
+        locked_until    = case when failed_attempts - 1 < $2 then null else locked_until end
+    where email=$1 and failed_attempts > 0
+  `, email, otpMaxFailures); err != nil {
+		log.Printf("[otp] release %s: %v", email, err)
+	}
+}
+
+func resetOTPFailures(ctx context.Context, email string) {
+	if _, err := db.Exec(ctx, `
+    update public.otp_throttle set failed_attempts=0, locked_until=null where email=$1
+  `, email); err != nil {
+		log.Printf("[otp] reset %s: %v", email, err)
+	}
+}
+
+func retryAfter(c *gin.Context, d time.Duration) {
+	c.Header("Retry-After", fmt.Sprint(int(math.Ceil(d.Seconds()))))
+}
+
+// -------- OTP auth handlers --------
+
+func requestOTP(c *gin.Context) {
+	if otpBackend == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "otp login not configured"})
+		return
+	}
+	ctx := c.Request.Context()
+	var in struct {
+		Email string `json:"email"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	email, ok := normalizeEmail(in.Email)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email"})
+		return
+	}
+
+	if !otpIPLimiter.allow(c.ClientIP(), otpIPMaxRequests, otpRequestWindow) {
+		authOTPEvents.Add("throttled", 1)
+		retryAfter(c, otpRequestWindow)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+		return
+	}
+	allowed, wait, err := reserveOTPRequest(ctx, email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if !allowed {
+		authOTPEvents.Add("throttled", 1)
+		retryAfter(c, wait)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+		return
+	}
+
+	if err := otpBackend.Request(ctx, email); err != nil {
+		log.Printf("[otp] request %s: %v", email, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "could not send code"})
+		return
+	}
+	authOTPEvents.Add("requested", 1)
+	c.JSON(http.StatusAccepted, gin.H{"ok": true})
+}
+
+func verifyOTP(c *gin.Context) {
+	if otpBackend == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "otp login not configured"})
+		return
+	}
+	ctx := c.Request.Context()
+	var in struct {
+		Email string `json:"email"`
+		Code  string `json:"code"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	email, ok := normalizeEmail(in.Email)
+	in.Code = strings.TrimSpace(in.Code)
+	if !ok || in.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email and code required"})
+		return
+	}
+
+	if !otpVerifyIPLimiter.allow(c.ClientIP(), otpIPMaxVerifies, otpLockout) {
+		authOTPEvents.Add("throttled", 1)
+		retryAfter(c, otpLockout)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+		return
+	}
+	allowed, left, wait, err := reserveOTPAttempt(ctx, email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if !allowed {
+		authOTPEvents.Add("locked", 1)
+		retryAfter(c, wait)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts"})
+		return
+	}
+
+	sess, err := otpBackend.Verify(ctx, email, in.Code)
+	if errors.Is(err, errOTPInvalid) {
+		authOTPEvents.Add("failed", 1)
+		if left <= 0 {
+			retryAfter(c, otpLockout)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts"})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code", "attempts_left": left})
+		return
+	}
+	if err != nil {
+		releaseOTPAttempt(ctx, email)
+		log.Printf("[otp] verify %s: %v", email, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "could not verify code"})
+		return
+	}
+	resetOTPFailures(ctx, email)
+	authOTPEvents.Add("verified", 1)
+	c.JSON(http.StatusOK, sess)
+}
+
+// -------- GoTrue backend --------
+
+type goTrueOTPBackend struct {
+	baseURL string // <PROJECT_URL>/auth/v1
+	apiKey  string
+	client  *http.Client
+}
+
+func (b *goTrueOTPBackend) Name() string { return "gotrue" }
+
+func (b *goTrueOTPBackend) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	buf, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+path, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apikey", b.apiKey)
+	return b.client.Do(req)
+}
+
+func (b *goTrueOTPBackend) Request(ctx context.Context, email string) error {
+	resp, err := b.post(ctx, "/otp", map[string]any{"email": email, "create_user": true})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("gotrue /otp: HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (b *goTrueOTPBackend) Verify(ctx context.Context, email, code string) (*OTPSession, error) {
+	resp, err := b.post(ctx, "/verify", map[string]any{"type": "email", "email": email, "token": code})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusBadRequest, resp.StatusCode == http.StatusUnauthorized,
+		resp.StatusCode == http.StatusForbidden, resp.StatusCode == http.StatusNotFound:
+		return nil, errOTPInvalid
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("gotrue /verify: HTTP %d", resp.StatusCode)
+	}
+	var sess OTPSession
+	if err := json.NewDecoder(resp.Body).Decode(&sess); err != nil {
+		return nil, fmt.Errorf("gotrue /verify: %w", err)
+	}
+	if sess.AccessToken == "" {
+		return nil, errOTPInvalid
+	}
+	return &sess, nil
+}
+
+// -------- Fake backend (local testing) --------
+
+type fakeOTPCode struct {
+	code    string
+	expires time.Time
+}
+
+type fakeOTPBackend struct {
+	issuer string
+	secret []byte
+	mu     sync.Mutex
+	codes  map[string]fakeOTPCode
+}
+
+func (b *fakeOTPBackend) Name() string { return "fake" }
+
+func (b *fakeOTPBackend) Request(ctx context.Context, email string) error {
+	n, _ := rand.Int(rand.Reader, big.NewInt(1000000))
+	code := fmt.Sprintf("%06d", n.Int64())
+	b.mu.Lock()
+	b.codes[email] = fakeOTPCode{code: code, expires: time.Now().Add(10 * time.Minute)}
+	b.mu.Unlock()
+	log.Printf("[otp:fake] code for %s: %s", email, code)
+	return nil
+}
+
+func (b *fakeOTPBackend) Verify(ctx context.Context, email, code string) (*OTPSession, error) {
+	b.mu.Lock()
+	want, ok := b.codes[email]
+	if ok && want.code == code && time.Now().Before(want.expires) {
+		delete(b.codes, email)
+	} else {
+		ok = false
+	}
+	b.mu.Unlock()
+	if !ok {
+		return nil, errOTPInvalid
+	}
+
+	// 固定由 email 推出 user id，重啟後同一個 email 仍是同一個使用者
+	sum := sha256.Sum256([]byte("fake-otp|" + email))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	uid := fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+
+	now := time.Now()
+	ttl := time.Hour
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":        b.issuer,
+		"aud":        "authenticated",
+		"role":       "authenticated",
+		"sub":        uid,
+		"email":      email,
+		"iat":        now.Unix(),
+		"exp":        now.Add(ttl).Unix(),
+		"session_id": fmt.Sprintf("fake-%x", sha256.Sum256([]byte(uid+now.String()))),
+		"amr":        []any{map[string]any{"method": "otp", "timestamp": now.Unix()}},
+	}).SignedString(b.secret)
+	if err != nil {
+		return nil, err
+	}
+	sess := &OTPSession{AccessToken: tok, TokenType: "bearer", ExpiresIn: int(ttl.Seconds())}
+	sess.User.ID = uid
+	sess.User.Email = email
+	return sess, nil
+}
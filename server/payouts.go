@@ -0,0 +1,582 @@
+package main
+
+// Helper payouts.
+// - Each helper registers one payout account (bank details) with a schedule:
+//   "weekly" (batched automatically) or "on_demand" (POST /me/payouts).
+// - A payout bundles every completed, not-yet-paid task of the helper
+//   (labor + tip + approved expenses); payout_items keeps a task from being
+//   paid twice.
+// - payoutLoop sends pending payouts through a PayoutProvider and retries
+//   failures with backoff; after payoutMaxAttempts the payout moves to
+//   needs_review with its tasks still attached. A lost error (timeout, 5xx
+//   after the transfer went out) looks like a failure, so only an admin who
+//   checked the provider settles it: paid, or failed, which releases the
+//   tasks into the next payout (POST /admin/payouts/:id/resolve).
+// - A payout left in 'processing' longer than payoutStaleAfter (the process
+//   died mid-send) is put back to pending by reapStalePayouts. Every send of
+//   the same payout carries the same idempotency key, so a provider that
+//   already executed it returns the original transfer instead of paying twice.
+// Required ENV:
+//   PAYOUT_PROVIDER=fake        only "fake" for now; requires HORA_DEV_MODE=true
+// Optional ENV:
+//   PAYOUT_MIN_CENTS=2000       minimum amount per payout
+//   PAYOUT_WEEKDAY=monday       day weekly batches are created (UTC)
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	payoutMaxAttempts  = 5
+	payoutLoopInterval = time.Minute
+	payoutStaleAfter   = 10 * time.Minute // > payoutLoopInterval, the per-run send timeout
+)
+
+var payouts PayoutProvider
+
+type PayoutRequest struct {
+	PayoutID       string
+	IdempotencyKey string // stable across retries of the same payout
+	User           string
+	HolderName     string
+	AccountRef     string
+	AmountCents    int
+	Currency       string
+}
+
+type PayoutProvider interface {
+	Name() string
+	// Send transfers the money and returns the provider's reference.
+	Send(ctx context.Context, req PayoutRequest) (string, error)
+}
+
+type PayoutAccount struct {
+	HolderName string    `json:"holder_name"`
+	AccountRef string    `json:"account_ref"` // masked in responses
+	Schedule   string    `json:"schedule"`    // weekly | on_demand
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type Payout struct {
+	ID          string     `json:"id"`
+	AmountCents int        `json:"amount_cents"`
+	Status      string     `json:"status"`  // pending | processing | paid | needs_review | failed
+	Trigger     string     `json:"trigger"` // weekly | on_demand
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	ProviderRef string     `json:"provider_ref,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
+}
+
+const payoutColumns = `id,amount_cents,status,trigger,attempts,last_error,provider_ref,created_at,paid_at`
+
+func scanPayout(rows interface{ Scan(dest ...any) error }) (Payout, error) {
+	var p Payout
+	err := rows.Scan(&p.ID, &p.AmountCents, &p.Status, &p.Trigger, &p.Attempts, &p.LastError, &p.ProviderRef, &p.CreatedAt, &p.PaidAt)
+	return p, err
+}
+
+var errBelowPayoutMinimum = errors.New("balance below payout minimum")
+
+func payoutMinCents() int {
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("PAYOUT_MIN_CENTS"))); err == nil && v > 0 {
+		return v
+	}
+	return 2000
+}
+
+func payoutWeekday() time.Weekday {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("PAYOUT_WEEKDAY")))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.ToLower(d.String()) == name {
+			return d
+		}
+	}
+	return time.Monday
+}
+
+func initPayoutProvider() {
+	switch p := strings.TrimSpace(os.Getenv("PAYOUT_PROVIDER")); p {
+	case "":
+		log.Fatal("PAYOUT_PROVIDER is required")
+	case "fake":
+		if !devMode() {
+			log.Fatal("PAYOUT_PROVIDER=fake marks payouts paid without moving money; set HORA_DEV_MODE=true (local dev only)")
+		}
+		payouts = &fakePayoutProvider{sent: map[string]string{}}
+	default:
+		log.Fatalf("unknown PAYOUT_PROVIDER %q", p)
+	}
+	log.Printf("[payout] provider: %s", payouts.Name())
+}
+
+func maskAccountRef(ref string) string {
+	if len(ref) <= 4 {
+		return ref
+	}
+	return strings.Repeat("•", 4) + ref[len(ref)-4:]
+}
+
+// payableTasksSQL: completed tasks of $1 not yet in any payout, with the
+// amount owed to the helper. $2 = default cents per minute.
+const payableTasksSQL = `
+    select t.id,
+           coalesce((select sum(greatest(ceil(extract(epoch from (w.end_at - w.start_at))/60.0), 1))::int
+                     from public.worklogs w
+                     where w.task_id = t.id and w."user" = t.assigned_to
+                       and w.end_at is not null and w.end_at > w.start_at), 0) * coalesce(t.rate_cents_per_minute, $2)
+           + t.tip_cents
+           + coalesce((select sum(e.amount_cents)::int from public.task_expenses e
+                       where e.task_id = t.id and e.status = 'approved'), 0)
+    from public.tasks t
+    where t.assigned_to = $1 and t.status = 'completed'
+      and not exists (select 1 from public.payout_items pi where pi.task_id = t.id)
+  `
+
+func availablePayoutCents(ctx context.Context, q dbtx, user string) (int, error) {
+	var cents int
+	err := q.QueryRow(ctx, `select coalesce(sum(x.cents)::int, 0) from (`+payableTasksSQL+`) x(id, cents)`, user, centsPerMinute).Scan(&cents)
+	return cents, err
+}
+
+// createPayout bundles all payable tasks of user into a pending payout.
+func createPayout(ctx context.Context, user, trigger string) (Payout, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Payout{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// 鎖住帳戶列，避免同一人同時建立兩筆 payout
+	var schedule string
+	if err := tx.QueryRow(ctx, `select schedule from public.payout_accounts where "user"=$1 for update`, user).Scan(&schedule); err != nil {
+		return Payout{}, err
+	}
+
+	rows, err := tx.Query(ctx, payableTasksSQL, user, centsPerMinute)
+	if err != nil {
+		return Payout{}, err
+	}
+	type item struct {
+		taskID string
+		cents  int
+	}
+	var items []item
+	total := 0
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.taskID, &it.cents); err != nil {
+			rows.Close()
+			return Payout{}, err
+		}
+		if it.cents > 0 {
+			items = append(items, it)
+			total += it.cents
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Payout{}, err
+	}
+	if total < payoutMinCents() {
+		return Payout{}, errBelowPayoutMinimum
+	}
+
+	p, err := scanPayout(tx.QueryRow(ctx, `
+    insert into public.payouts("user",amount_cents,status,trigger,next_attempt_at)
+    values ($1,$2,'pending',$3,now())
+    returning `+payoutColumns, user, total, trigger))
+	if err != nil {
+		return Payout{}, err
+	}
+	for _, it := range items {
+		if _, err := tx.Exec(ctx, `
+      insert into public.payout_items(payout_id,task_id,amount_cents) values ($1,$2,$3)
+    `, p.ID, it.taskID, it.cents); err != nil {
+			return Payout{}, err
+		}
+	}
+	return p, tx.Commit(ctx)
+}
+
+// -------- Payout handlers --------
+
+func getPayoutAccount(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var a PayoutAccount
+	err := db.QueryRow(ctx, `
+    select holder_name, account_ref, schedule, updated_at from public.payout_accounts where "user"=$1
+  `, me).Scan(&a.HolderName, &a.AccountRef, &a.Schedule, &a.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no payout account"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	a.AccountRef = maskAccountRef(a.AccountRef)
+	c.JSON(http.StatusOK, a)
+}
+
+func putPayoutAccount(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var in PayoutAccount
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	in.HolderName = strings.TrimSpace(in.HolderName)
+	in.AccountRef = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(in.AccountRef), " ", ""))
+	if in.HolderName == "" || in.AccountRef == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "holder_name and account_ref required"})
+		return
+	}
+	if in.Schedule == "" {
+		in.Schedule = "weekly"
+	}
+	if in.Schedule != "weekly" && in.Schedule != "on_demand" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "schedule must be weekly or on_demand"})
+		return
+	}
+
+	err := db.QueryRow(ctx, `
+    insert into public.payout_accounts("user",holder_name,account_ref,schedule,updated_at)
+    values ($1,$2,$3,$4,now())
+    on conflict ("user") do update
+    set holder_name=$2, account_ref=$3, schedule=$4, updated_at=now()
+    returning updated_at
+  `, me, in.HolderName, in.AccountRef, in.Schedule).Scan(&in.UpdatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	in.AccountRef = maskAccountRef(in.AccountRef)
+	c.JSON(http.StatusOK, in)
+}
+
+func listMyPayouts(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	available, err := availablePayoutCents(ctx, db, me)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	rows, err := db.Query(ctx, `
+    select `+payoutColumns+` from public.payouts where "user"=$1 order by created_at desc
+  `, me)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	items := []Payout{}
+	for rows.Next() {
+		p, err := scanPayout(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		items = append(items, p)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":           items,
+		"available_cents": available,
+		"minimum_cents":   payoutMinCents(),
+	})
+}
+
+// requestPayout: on-demand payout of the whole available balance.
+func requestPayout(c *gin.Context) {
+	me := c.GetString("uid")
+	p, err := createPayout(c.Request.Context(), me, "on_demand")
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payout account required"})
+	case errors.Is(err, errBelowPayoutMinimum):
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("minimum payout is %s", formatCents(payoutMinCents()))})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+	default:
+		c.JSON(http.StatusCreated, p)
+	}
+}
+
+// -------- Payout batch job --------
+
+func payoutLoop() {
+	t := time.NewTicker(payoutLoopInterval)
+	defer t.Stop()
+	for ; ; <-t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), payoutLoopInterval)
+		if time.Now().UTC().Weekday() == payoutWeekday() {
+			scheduleWeeklyPayouts(ctx)
+		}
+		reapStalePayouts(ctx)
+		processPendingPayouts(ctx)
+		cancel()
+	}
+}
+
+// scheduleWeeklyPayouts creates one payout per weekly account per ISO week.
+func scheduleWeeklyPayouts(ctx context.Context) {
+	rows, err := db.Query(ctx, `
+    select a."user" from public.payout_accounts a
+    where a.schedule = 'weekly'
+      and not exists (
+        select 1 from public.payouts p
+        where p."user" = a."user" and p.trigger = 'weekly'
+          and p.created_at >= date_trunc('week', now() at time zone 'utc') at time zone 'utc'
+      )
+  `)
+	if err != nil {
+		log.Printf("[payout] weekly query: %v", err)
+		return
+	}
+	var users []string
+	for rows.Next() {
+		var u string
+		if rows.Scan(&u) == nil {
+			users = append(users, u)
+		}
+	}
+	rows.Close()
+
+	for _, u := range users {
+		p, err := createPayout(ctx, u, "weekly")
+		if errors.Is(err, errBelowPayoutMinimum) {
+			continue
+		}
+		if err != nil {
+			log.Printf("[payout] weekly %s: %v", u, err)
+			continue
+		}
+		log.Printf("[payout] scheduled %s for %s (%s)", p.ID, u, formatCents(p.AmountCents))
+	}
+}
+
+// reapStalePayouts returns payouts stuck in 'processing' to pending so they
+// are retried (with the same idempotency key).
+func reapStalePayouts(ctx context.Context) {
+	tag, err := db.Exec(ctx, `
+    update public.payouts
+    set status='pending', last_error='interrupted while processing', next_attempt_at=now(), updated_at=now()
+    where status='processing' and updated_at < now() - $1::interval
+  `, fmt.Sprintf("%d seconds", int(payoutStaleAfter.Seconds())))
+	if err != nil {
+		log.Printf("[payout] reap: %v", err)
+		return
+	}
+	if n := tag.RowsAffected(); n > 0 {
+		log.Printf("[payout] reset %d stale processing payouts", n)
+	}
+}
+
+func processPendingPayouts(ctx context.Context) {
+	for {
+		done, err := processNextPayout(ctx)
+		if err != nil {
+			log.Printf("[payout] process: %v", err)
+			return
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// processNextPayout claims one due payout and sends it. done=true when
+// nothing is due.
+func processNextPayout(ctx context.Context) (done bool, err error) {
+	var req PayoutRequest
+	var attempts int
+	err = db.QueryRow(ctx, `
+    update public.payouts p set status='processing', attempts=p.attempts+1, updated_at=now()
+    from public.payout_accounts a
+    where p.id = (
+        select id from public.payouts
+        where status='pending' and next_attempt_at <= now()
+        order by next_attempt_at asc
+        for update skip locked limit 1
+      )
+      and a."user" = p."user"
+    returning p.id, p."user", a.holder_name, a.account_ref, p.amount_cents, p.attempts
+  `).Scan(&req.PayoutID, &req.User, &req.HolderName, &req.AccountRef, &req.AmountCents, &attempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	req.Currency = invoiceCurrency
+	req.IdempotencyKey = "hora-payout-" + req.PayoutID
+
+	ref, sendErr := payouts.Send(ctx, req)
+	if sendErr == nil {
+		_, err = db.Exec(ctx, `
+      update public.payouts set status='paid', provider_ref=$2, last_error='', paid_at=now(), updated_at=now()
+      where id=$1
+    `, req.PayoutID, ref)
+		log.Printf("[payout] paid %s (%s) ref=%s", req.PayoutID, formatCents(req.AmountCents), ref)
+		return false, err
+	}
+
+	log.Printf("[payout] attempt %d for %s failed: %v", attempts, req.PayoutID, sendErr)
+	if attempts < payoutMaxAttempts {
+		// 指數退避：1, 2, 4, 8 分鐘…
+		backoff := time.Duration(1<<(attempts-1)) * time.Minute
+		_, err = db.Exec(ctx, `
+      update public.payouts set status='pending', last_error=$2, next_attempt_at=now()+$3::interval, updated_at=now()
+      where id=$1
+    `, req.PayoutID, sendErr.Error(), fmt.Sprintf("%d seconds", int(backoff.Seconds())))
+		return false, err
+	}
+
+	// 不再自動重試：錢可能其實已匯出（錯誤遺失），任務留在這筆 payout 上等人工對帳
+	_, err = db.Exec(ctx, `
+    update public.payouts set status='needs_review', last_error=$2, updated_at=now() where id=$1
+  `, req.PayoutID, sendErr.Error())
+	log.Printf("[payout] %s needs review after %d attempts", req.PayoutID, attempts)
+	return false, err
+}
+
+// -------- Admin --------
+
+// GET /admin/payouts?status=needs_review (default)
+func adminListPayouts(c *gin.Context) {
+	limit, offset := pageParams(c)
+	rows, err := db.Query(c.Request.Context(), `
+    select `+payoutColumns+`, "user" from public.payouts
+    where status=$1 order by updated_at asc limit $2 offset $3
+  `, c.DefaultQuery("status", "needs_review"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	type adminPayout struct {
+		Payout
+		User string `json:"user"`
+	}
+	out := []adminPayout{}
+	for rows.Next() {
+		var p adminPayout
+		if err := rows.Scan(&p.ID, &p.AmountCents, &p.Status, &p.Trigger, &p.Attempts, &p.LastError, &p.ProviderRef, &p.CreatedAt, &p.PaidAt, &p.User); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// POST /admin/payouts/:id/resolve {"outcome": "paid"|"failed", "provider_ref", "note"}
+// — settles a needs_review payout after checking with the provider. paid
+// needs the provider's reference; failed releases the tasks.
+func adminResolvePayout(c *gin.Context) {
+	ctx := c.Request.Context()
+	var in struct {
+		Outcome     string `json:"outcome"`
+		ProviderRef string `json:"provider_ref"`
+		Note        string `json:"note"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	in.ProviderRef = strings.TrimSpace(in.ProviderRef)
+	switch {
+	case in.Outcome != "paid" && in.Outcome != "failed":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "outcome must be paid or failed"})
+		return
+	case in.Outcome == "paid" && in.ProviderRef == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provider_ref required"})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	p, err := scanPayout(tx.QueryRow(ctx, `
+    update public.payouts
+    set status=$2, provider_ref=$3, paid_at=case when $2='paid' then now() end, updated_at=now()
+    where id::text=$1 and status='needs_review'
+    returning `+payoutColumns, c.Param("id"), in.Outcome, in.ProviderRef))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payout not awaiting review"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if in.Outcome == "failed" {
+		// 確認沒有匯出：釋放任務，下次 payout 重新包含
+		if _, err := tx.Exec(ctx, `delete from public.payout_items where payout_id=$1`, p.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	auditAdmin(ctx, c.GetString("uid"), "payout.resolve", p.ID, map[string]any{
+		"outcome": in.Outcome, "provider_ref": in.ProviderRef, "note": in.Note,
+	})
+	c.JSON(http.StatusOK, p)
+}
+
+// -------- Fake provider (local testing) --------
+// Always succeeds, except for account refs starting with "FAIL" which fail
+// every attempt — handy for exercising the retry path. Like a real provider
+// it remembers idempotency keys and returns the first reference on a resend.
+
+type fakePayoutProvider struct {
+	mu   sync.Mutex
+	sent map[string]string // idempotency key → reference
+}
+
+func (*fakePayoutProvider) Name() string { return "fake" }
+
+func (p *fakePayoutProvider) Send(ctx context.Context, req PayoutRequest) (string, error) {
+	if strings.HasPrefix(req.AccountRef, "FAIL") {
+		return "", fmt.Errorf("fake provider: account %s rejected", maskAccountRef(req.AccountRef))
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ref, ok := p.sent[req.IdempotencyKey]; ok {
+		return ref, nil
+	}
+	log.Printf("[payout:fake] %s %s → %s (%s)", req.PayoutID, formatCents(req.AmountCents), req.HolderName, maskAccountRef(req.AccountRef))
+	ref := "fake-" + req.PayoutID
+	p.sent[req.IdempotencyKey] = ref
+	return ref, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+// Minimal PDF writer for server-generated documents (invoices, receipts).
+// Text only, standard Type1 fonts, A4 pages; no external dependencies.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+type pdfFont string
+
+const (
+	pdfRegular pdfFont = "F1" // Helvetica
+	pdfBold    pdfFont = "F2" // Helvetica-Bold
+	pdfMono    pdfFont = "F3" // Courier（表格欄位對齊用）
+)
+
+type pdfLine struct {
+	Text string
+	Font pdfFont
+	Size float64
+}
+
+const (
+	pdfPageW  = 595.0 // A4 in points
+	pdfPageH  = 842.0
+	pdfMargin = 50.0
+)
+
+// renderPDF lays out lines top-to-bottom, starting a new page when full.
+// An empty Text produces a blank line of the given size.
+func renderPDF(title string, lines []pdfLine) []byte {
+	// 分頁
+	var pages [][]pdfLine
+	var cur []pdfLine
+	y := pdfPageH - pdfMargin
+	for _, l := range lines {
+		if l.Size == 0 {
+			l.Size = 10
+		}
+		if l.Font == "" {
+			l.Font = pdfRegular
+		}
+		h := l.Size * 1.4
+		if y-h < pdfMargin && len(cur) > 0 {
+			pages = append(pages, cur)
+			cur = nil
+			y = pdfPageH - pdfMargin
+		}
+		cur = append(cur, l)
+		y -= h
+	}
+	if len(cur) > 0 || len(pages) == 0 {
+		pages = append(pages, cur)
+	}
+
+	// Object numbering: 1 catalog, 2 pages, 3-5 fonts, 6 info, then (page, content) pairs.
+	var objs []string
+	objs = append(objs, "<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 7+2*i)
+	}
+	objs = append(objs, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	objs = append(objs,
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Title (%s) /Producer (Hora) >>", pdfEscape(title)),
+	)
+	for i, pl := range pages {
+		var cs bytes.Buffer
+		y := pdfPageH - pdfMargin
+		for _, l := range pl {
+			y -= l.Size * 1.4
+			if l.Text == "" {
+				continue
+			}
+			fmt.Fprintf(&cs, "BT /%s %.1f Tf %.1f %.1f Td (%s) Tj ET\n", l.Font, l.Size, pdfMargin, y, pdfEscape(l.Text))
+		}
+		objs = append(objs,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>",
+				pdfPageW, pdfPageH, 8+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", cs.Len(), cs.String()),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objs))
+	for i, o := range objs {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 6 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+	return out.Bytes()
+}
+
+// pdfEscape converts s to WinAnsi bytes and escapes PDF string delimiters.
+// Characters outside WinAnsi are replaced with '?'.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString(`\200`)
+		case r == '–':
+			b.WriteString(`\226`)
+		case r == '—':
+			b.WriteString(`\227`)
+		case r == '…':
+			b.WriteString(`\205`)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, `\%03o`, r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,164 @@
+package main
+
+// Role-based access control.
+// Roles come from two places and are merged:
+//   - Supabase app_metadata claims: "role"/"roles" (set by operators in Supabase)
+//   - public.user_roles rows (managed through the /admin API)
+// Every authenticated user implicitly has roleUser. roleAdmin passes any check.
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	roleUser           = "user"
+	roleHelperVerified = "helper-verified"
+	roleModerator      = "moderator"
+	roleAdmin          = "admin"
+)
+
+var knownRoles = map[string]bool{
+	roleUser: true, roleHelperVerified: true, roleModerator: true, roleAdmin: true,
+}
+
+// claimRoles reads app_metadata.role / app_metadata.roles from the JWT.
+func claimRoles(claims jwt.MapClaims) []string {
+	meta, _ := claims["app_metadata"].(map[string]any)
+	if meta == nil {
+		return nil
+	}
+	var out []string
+	if r, ok := meta["role"].(string); ok && knownRoles[r] {
+		out = append(out, r)
+	}
+	if rs, ok := meta["roles"].([]any); ok {
+		for _, v := range rs {
+			if r, ok := v.(string); ok && knownRoles[r] {
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+func dbRoles(ctx context.Context, uid string) ([]string, error) {
+	rows, err := db.Query(ctx, `select role from public.user_roles where user_id=$1`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var r string
+		if err := rows.Scan(&r); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// userHasRole checks another user's server-side roles (claims are only known
+// for the caller). Admin counts as every role, as in hasRole.
+func userHasRole(ctx context.Context, q dbtx, uid, want string) (bool, error) {
+	var ok bool
+	err := q.QueryRow(ctx, `
+    select exists (select 1 from public.user_roles where user_id=$1 and role in ($2, 'admin'))
+  `, uid, want).Scan(&ok)
+	return ok, err
+}
+
+// rolesOf returns (and caches on the context) the caller's roles.
+func rolesOf(c *gin.Context) []string {
+	if v, ok := c.Get("roles"); ok {
+		return v.([]string)
+	}
+	roles := []string{roleUser}
+	seen := map[string]bool{roleUser: true}
+	add := func(rs []string) {
+		for _, r := range rs {
+			if !seen[r] {
+				seen[r] = true
+				roles = append(roles, r)
+			}
+		}
+	}
+	if claims, ok := c.Get("claims"); ok {
+		add(claimRoles(claims.(jwt.MapClaims)))
+	}
+	rs, err := dbRoles(c.Request.Context(), c.GetString("uid"))
+	if err != nil {
+		log.Printf("[rbac] load roles: %v", err)
+	}
+	add(rs)
+	c.Set("roles", roles)
+	return roles
+}
+
+func hasRole(c *gin.Context, want ...string) bool {
+	for _, r := range rolesOf(c) {
+		if r == roleAdmin {
+			return true
+		}
+		for _, w := range want {
+			if r == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requireRole lets the request through if the caller has any of roles.
+// Must run after authMiddleware.
+func requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasRole(c, roles...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient role"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// impersonating reports whether an admin is acting as this request's uid.
+// Handlers skip their incidental writes (lazy inserts, syncs) then, so a
+// support session leaves no trace on the target's data.
+func impersonating(c *gin.Context) bool {
+	_, ok := c.Get("impersonator")
+	return ok
+}
+
+// applyImpersonation handles "X-Impersonate-User: <uid>" for support staff.
+// Admins only, read-only (GET/HEAD; GET handlers check impersonating before
+// writing). The request then runs as the target user; the real caller is
+// kept in "impersonator" and audited.
+func applyImpersonation(c *gin.Context) bool {
+	target := strings.TrimSpace(c.GetHeader("X-Impersonate-User"))
+	if target == "" {
+		return true
+	}
+	if _, isToken := c.Get("scopes"); isToken || !hasRole(c, roleAdmin) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "impersonation requires an admin session"})
+		return false
+	}
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "impersonation is read-only"})
+		return false
+	}
+	actor := c.GetString("uid")
+	auditAdmin(c.Request.Context(), actor, "impersonate", target, map[string]any{
+		"method": c.Request.Method, "path": c.Request.URL.Path,
+	})
+	c.Set("impersonator", actor)
+	c.Set("uid", target)
+	c.Set("email", "")
+	c.Set("roles", []string{roleUser}) // 以目標使用者身分執行，不帶管理權限
+	return true
+}
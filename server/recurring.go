@@ -0,0 +1,966 @@
+package main
+
+// Recurring tasks ("visit grandma every Tuesday 15:00").
+// A task_series holds the task template, an RRULE (see rrule.go), an IANA
+// time zone and the first occurrence. recurrenceLoop materialises concrete
+// tasks rows recurrenceHorizon ahead; each row remembers its series_id and
+// original occurrence_at so a slot is never generated twice.
+// - Single occurrence: edit the generated task with PATCH /tasks/:id (marks
+//   it series_edited so series edits no longer overwrite it), skip it with
+//   /occurrences/skip, or materialise a later one early to edit it.
+// - Whole series: PATCH updates the template and every future open,
+//   unassigned, unedited occurrence; DELETE cancels the series and its
+//   future open occurrences. Changing the rhythm (rrule, start_at,
+//   timezone) removes those occurrences and generates them again from the
+//   new rule; assigned or edited ones stay where they are, and so do
+//   those with attachments, pending offers or direct-offer targets.
+// - Budget: each new occurrence is checked like createTask; over a hard
+//   monthly limit the slot is recorded as skipped instead of created.
+// - offer_previous_assignee: new occurrences are direct-offered (see
+//   favorites.go) to whoever took the latest occurrence.
+// ENV:
+//   RECURRENCE_HORIZON_DAYS=14
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	recurrenceLoopInterval = 15 * time.Minute
+	maxOccurrenceListDays  = 180
+)
+
+func recurrenceHorizon() time.Duration {
+	days, err := strconv.Atoi(envOr("RECURRENCE_HORIZON_DAYS", "14"))
+	if err != nil || days < 1 {
+		days = 14
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+type TaskSeries struct {
+	ID                    string      `json:"id"`
+	Requester             string      `json:"requester"`
+	Title                 string      `json:"title"`
+	Description           string      `json:"description"`
+	Category              string      `json:"category"`
+	LocationText          string      `json:"location_text"`
+	EstimatedMinutes      int         `json:"estimated_minutes"`
+	PrepayAmountCents     int         `json:"prepay_amount_cents"`
+	Checklist             []string    `json:"checklist"`
+	RequireChecklist      bool        `json:"require_checklist"`
+	RRule                 string      `json:"rrule"`
+	Timezone              string      `json:"timezone"`
+	StartAt               time.Time   `json:"start_at"`
+	OfferPreviousAssignee bool        `json:"offer_previous_assignee"`
+	ExclusiveMinutes      int         `json:"exclusive_minutes"`
+	Status                string      `json:"status"` // active | ended | cancelled
+	GeneratedUntil        time.Time   `json:"generated_until"`
+	CreatedAt             time.Time   `json:"created_at"`
+	UpdatedAt             time.Time   `json:"updated_at"`
+	NextOccurrences       []time.Time `json:"next_occurrences,omitempty"`
+}
+
+const seriesColumns = `id,requester,title,description,category,location_text,estimated_minutes,
+           prepay_amount_cents,checklist,require_checklist,rrule,timezone,start_at,
+           offer_previous_assignee,exclusive_minutes,status,generated_until,created_at,updated_at`
+
+func scanSeries(rows interface{ Scan(dest ...any) error }) (TaskSeries, error) {
+	var s TaskSeries
+	err := rows.Scan(
+		&s.ID, &s.Requester, &s.Title, &s.Description, &s.Category, &s.LocationText, &s.EstimatedMinutes,
+		&s.PrepayAmountCents, &s.Checklist, &s.RequireChecklist, &s.RRule, &s.Timezone, &s.StartAt,
+		&s.OfferPreviousAssignee, &s.ExclusiveMinutes, &s.Status, &s.GeneratedUntil, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if s.Checklist == nil {
+		s.Checklist = []string{}
+	}
+	return s, err
+}
+
+// rule returns the parsed RRULE and dtstart in the series' zone.
+func (s TaskSeries) rule() (rrule, time.Time, error) {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return rrule{}, time.Time{}, err
+	}
+	r, err := parseRRule(s.RRule, loc)
+	return r, s.StartAt.In(loc), err
+}
+
+// parseLocalTime accepts RFC3339 or a wall-clock "2006-01-02T15:04[:05]"
+// interpreted in loc.
+func parseLocalTime(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.In(loc), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("time must be RFC3339 or YYYY-MM-DDTHH:MM local time")
+}
+
+func seriesExclusiveUntil(s TaskSeries) time.Time {
+	mins := s.ExclusiveMinutes
+	if mins <= 0 {
+		mins = defaultExclusiveMinutes
+	}
+	return time.Now().Add(time.Duration(min(mins, maxExclusiveMinutes)) * time.Minute)
+}
+
+// previousAssignee: helper of the latest non-cancelled occurrence, unless
+// blocked since.
+func previousAssignee(ctx context.Context, q dbtx, s TaskSeries) string {
+	var helper string
+	_ = q.QueryRow(ctx, `
+    select assigned_to from public.tasks
+    where series_id=$1 and assigned_to <> '' and status <> 'cancelled'
+    order by occurrence_at desc limit 1
+  `, s.ID).Scan(&helper)
+	if helper == "" {
+		return ""
+	}
+	if blocked, _ := isBlockedPair(ctx, q, s.Requester, helper); blocked {
+		return ""
+	}
+	return helper
+}
+
+// errOverBudget: a new occurrence would exceed the requester's hard monthly
+// budget (see spending.go).
+var errOverBudget = errors.New("monthly budget exceeded")
+
+// insertOccurrence creates the task for one slot; created=false if it
+// already existed. New slots are budget-checked like createTask.
+func insertOccurrence(ctx context.Context, q dbtx, s TaskSeries, at time.Time, helper string) (id string, created bool, err error) {
+	err = q.QueryRow(ctx, `select id from public.tasks where series_id=$1 and occurrence_at=$2`, s.ID, at).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", false, err
+	}
+	if _, reject, err := checkBudget(ctx, q, s.Requester, estimatedCostCents(s.EstimatedMinutes)); err != nil {
+		return "", false, err
+	} else if reject {
+		return "", false, errOverBudget
+	}
+
+	var exclusiveUntil *time.Time
+	if helper != "" {
+		t := seriesExclusiveUntil(s)
+		exclusiveUntil = &t
+	}
+	err = q.QueryRow(ctx, `
+    insert into public.tasks
+      (title,description,category,location_text,estimated_minutes,prepay_amount_cents,is_immediate,scheduled_at,
+       requester,status,assigned_to,exclusive_until,require_checklist,series_id,occurrence_at,timezone)
+    values ($1,$2,$3,$4,$5,$6,false,$7,$8,'open','',$9,$10,$11,$7,$12)
+    on conflict (series_id, occurrence_at) do nothing
+    returning id
+  `, s.Title, s.Description, s.Category, s.LocationText, s.EstimatedMinutes, s.PrepayAmountCents, at,
+		s.Requester, exclusiveUntil, s.RequireChecklist, s.ID, s.Timezone).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = q.QueryRow(ctx, `select id from public.tasks where series_id=$1 and occurrence_at=$2`, s.ID, at).Scan(&id)
+		return id, false, err
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if err := insertChecklistItems(ctx, q, id, s.Checklist); err != nil {
+		return "", false, err
+	}
+	if helper != "" {
+		if _, err := q.Exec(ctx, `insert into public.task_targets(task_id,helper_id) values ($1,$2)`, id, helper); err != nil {
+			return "", false, err
+		}
+	}
+	return id, true, nil
+}
+
+func isSkipped(ctx context.Context, q dbtx, seriesID string, at time.Time) bool {
+	var skipped bool
+	_ = q.QueryRow(ctx, `
+    select exists (select 1 from public.task_series_skips where series_id=$1 and occurrence_at=$2)
+  `, seriesID, at).Scan(&skipped)
+	return skipped
+}
+
+// generateSeries materialises the occurrences in (generated_until, upto]
+// and advances generated_until; the series ends once the rule runs out.
+func generateSeries(ctx context.Context, q dbtx, s TaskSeries, upto time.Time) (int, error) {
+	r, dtstart, err := s.rule()
+	if err != nil {
+		return 0, err
+	}
+	after := s.GeneratedUntil
+	if now := time.Now(); after.Before(now) {
+		after = now // 過去的時段不補建
+	}
+	helper := ""
+	if s.OfferPreviousAssignee {
+		helper = previousAssignee(ctx, q, s)
+	}
+	n := 0
+	for _, at := range r.occurrences(dtstart, after, upto) {
+		if isSkipped(ctx, q, s.ID, at) {
+			continue
+		}
+		_, created, err := insertOccurrence(ctx, q, s, at, helper)
+		if errors.Is(err, errOverBudget) {
+			// 硬性預算：這一場不建立，記成 skip 讓 occurrences 列表看得到
+			log.Printf("[recurring] series %s: skipping %s, over budget", s.ID, at.Format(time.RFC3339))
+			if _, err := q.Exec(ctx, `
+        insert into public.task_series_skips(series_id,occurrence_at) values ($1,$2)
+        on conflict do nothing
+      `, s.ID, at); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		if created {
+			n++
+		}
+	}
+	status := s.Status
+	if _, more := r.next(dtstart, upto); !more && status == "active" {
+		status = "ended"
+	}
+	_, err = q.Exec(ctx, `
+    update public.task_series set generated_until=$2, status=$3 where id=$1
+  `, s.ID, upto, status)
+	return n, err
+}
+
+func recurrenceLoop() {
+	t := time.NewTicker(recurrenceLoopInterval)
+	defer t.Stop()
+	for ; ; <-t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), recurrenceLoopInterval)
+		upto := time.Now().Add(recurrenceHorizon())
+		var ids []string
+		rows, err := db.Query(ctx, `
+      select id from public.task_series
+      where status='active' and generated_until < $1
+      order by generated_until limit 200
+    `, upto)
+		if err == nil {
+			for rows.Next() {
+				var id string
+				if rows.Scan(&id) == nil {
+					ids = append(ids, id)
+				}
+			}
+			rows.Close()
+		}
+		created := 0
+		for _, id := range ids {
+			n, err := generateSeriesByID(ctx, id, upto)
+			if err != nil {
+				log.Printf("[recurring] series %s: %v", id, err)
+			}
+			created += n
+		}
+		if created > 0 {
+			log.Printf("[recurring] created %d occurrences", created)
+		}
+		cancel()
+	}
+}
+
+// generateSeriesByID locks one series (skipping it if another worker has it).
+func generateSeriesByID(ctx context.Context, id string, upto time.Time) (int, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	s, err := scanSeries(tx.QueryRow(ctx, `
+    select `+seriesColumns+` from public.task_series
+    where id=$1 and status='active' for update skip locked
+  `, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := generateSeries(ctx, tx, s, upto)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit(ctx)
+}
+
+// -------- Handlers --------
+
+type seriesInput struct {
+	createTaskInput
+	RRule                 string `json:"rrule"`
+	Timezone              string `json:"timezone"`
+	StartAt               string `json:"start_at"` // first occurrence; RFC3339 or local wall-clock time
+	OfferPreviousAssignee bool   `json:"offer_previous_assignee"`
+}
+
+// loadMySeries loads a series owned by the caller (404 otherwise).
+func loadMySeries(c *gin.Context, q dbtx, forUpdate bool) (TaskSeries, bool) {
+	sql := `select ` + seriesColumns + ` from public.task_series where id::text=$1 and requester=$2`
+	if forUpdate {
+		sql += ` for update`
+	}
+	s, err := scanSeries(q.QueryRow(c.Request.Context(), sql, c.Param("id"), c.GetString("uid")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return s, false
+	}
+	return s, true
+}
+
+func withNextOccurrences(s *TaskSeries, n int) {
+	if s.Status != "active" {
+		return
+	}
+	r, dtstart, err := s.rule()
+	if err != nil {
+		return
+	}
+	r.each(dtstart, time.Now(), time.Now().AddDate(1, 0, 0), func(t time.Time) bool {
+		s.NextOccurrences = append(s.NextOccurrences, t)
+		return len(s.NextOccurrences) < n
+	})
+}
+
+// POST /task-series
+func createSeries(c *gin.Context) {
+	var in seriesInput
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	checklist, err := normalizeChecklist(in.Checklist)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	loc, err := time.LoadLocation(strings.TrimSpace(in.Timezone))
+	if err != nil || strings.TrimSpace(in.Timezone) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "timezone must be an IANA zone such as Europe/Berlin"})
+		return
+	}
+	r, err := parseRRule(in.RRule, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start, err := parseLocalTime(in.StartAt, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start_at: " + err.Error()})
+		return
+	}
+	if start.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start_at must be in the future"})
+		return
+	}
+	if _, ok := r.next(start, start.Add(-time.Second)); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rrule produces no occurrences"})
+		return
+	}
+	exclusive := min(max(in.ExclusiveMinutes, 0), maxExclusiveMinutes)
+
+	ctx := c.Request.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	s, err := scanSeries(tx.QueryRow(ctx, `
+    insert into public.task_series
+      (requester,title,description,category,location_text,estimated_minutes,prepay_amount_cents,
+       checklist,require_checklist,rrule,timezone,start_at,offer_previous_assignee,exclusive_minutes,
+       status,generated_until)
+    values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,'active',now())
+    returning `+seriesColumns,
+		c.GetString("uid"), in.Title, in.Description, in.Category, in.LocationText, in.EstimatedMinutes, in.PrepayAmountCents,
+		checklist, in.RequireChecklist != nil && *in.RequireChecklist, r.String(), loc.String(), start,
+		in.OfferPreviousAssignee, exclusive))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	// 立即建立第一批，不等排程
+	if _, err := generateSeries(ctx, tx, s, time.Now().Add(recurrenceHorizon())); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	s, err = scanSeries(tx.QueryRow(ctx, `select `+seriesColumns+` from public.task_series where id=$1`, s.ID))
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	withNextOccurrences(&s, 5)
+	c.JSON(http.StatusCreated, s)
+}
+
+// GET /task-series
+func listMySeries(c *gin.Context) {
+	limit, offset := pageParams(c)
+	rows, err := db.Query(c.Request.Context(), `
+    select `+seriesColumns+` from public.task_series
+    where requester=$1 order by created_at desc limit $2 offset $3
+  `, c.GetString("uid"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []TaskSeries{}
+	for rows.Next() {
+		s, err := scanSeries(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		withNextOccurrences(&s, 1)
+		out = append(out, s)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GET /task-series/:id
+func getSeries(c *gin.Context) {
+	s, ok := loadMySeries(c, db, false)
+	if !ok {
+		return
+	}
+	withNextOccurrences(&s, 5)
+	c.JSON(http.StatusOK, s)
+}
+
+// PATCH /task-series/:id — template fields, offer settings, the rhythm
+// (rrule, start_at, timezone) and "until" (RFC3339 / local time; "" removes
+// the end). Applies to future open, unassigned occurrences that were not
+// edited individually; a new rhythm regenerates them.
+func updateSeries(c *gin.Context) {
+	var in struct {
+		Title                 *string   `json:"title"`
+		Description           *string   `json:"description"`
+		Category              *string   `json:"category"`
+		LocationText          *string   `json:"location_text"`
+		EstimatedMinutes      *int      `json:"estimated_minutes"`
+		PrepayAmountCents     *int      `json:"prepay_amount_cents"`
+		Checklist             *[]string `json:"checklist"`
+		RequireChecklist      *bool     `json:"require_checklist"`
+		OfferPreviousAssignee *bool     `json:"offer_previous_assignee"`
+		ExclusiveMinutes      *int      `json:"exclusive_minutes"`
+		RRule                 *string   `json:"rrule"`
+		StartAt               *string   `json:"start_at"`
+		Timezone              *string   `json:"timezone"`
+		Until                 *string   `json:"until"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	ctx := c.Request.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	s, ok := loadMySeries(c, tx, true)
+	if !ok {
+		return
+	}
+	if s.Status == "cancelled" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "series cancelled"})
+		return
+	}
+
+	prevMinutes := s.EstimatedMinutes
+	tmpl := createTaskInput{
+		Title: s.Title, Description: s.Description, Category: s.Category, LocationText: s.LocationText,
+		EstimatedMinutes: s.EstimatedMinutes, PrepayAmountCents: s.PrepayAmountCents,
+	}
+	if in.Title != nil {
+		tmpl.Title = *in.Title
+	}
+	if in.Description != nil {
+		tmpl.Description = *in.Description
+	}
+	if in.Category != nil {
+		tmpl.Category = *in.Category
+	}
+	if in.LocationText != nil {
+		tmpl.LocationText = *in.LocationText
+	}
+	if in.EstimatedMinutes != nil {
+		tmpl.EstimatedMinutes = *in.EstimatedMinutes
+	}
+	if in.PrepayAmountCents != nil {
+		tmpl.PrepayAmountCents = *in.PrepayAmountCents
+	}
+	if msg := tmpl.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	s.Title, s.Description, s.Category, s.LocationText = tmpl.Title, tmpl.Description, tmpl.Category, tmpl.LocationText
+	s.EstimatedMinutes, s.PrepayAmountCents = tmpl.EstimatedMinutes, tmpl.PrepayAmountCents
+	if in.Checklist != nil {
+		if s.Checklist, err = normalizeChecklist(*in.Checklist); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if in.RequireChecklist != nil {
+		s.RequireChecklist = *in.RequireChecklist
+	}
+	if in.OfferPreviousAssignee != nil {
+		s.OfferPreviousAssignee = *in.OfferPreviousAssignee
+	}
+	if in.ExclusiveMinutes != nil {
+		s.ExclusiveMinutes = min(max(*in.ExclusiveMinutes, 0), maxExclusiveMinutes)
+	}
+
+	r, dtstart, err := s.rule()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid stored rule"})
+		return
+	}
+	rhythmChanged := in.RRule != nil || in.StartAt != nil || in.Timezone != nil
+	if in.Timezone != nil {
+		loc, err := time.LoadLocation(strings.TrimSpace(*in.Timezone))
+		if err != nil || strings.TrimSpace(*in.Timezone) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timezone must be an IANA zone such as Europe/Berlin"})
+			return
+		}
+		// 換時區時保留牆上時間（週二 15:00 還是 15:00）
+		y, mo, d := dtstart.Date()
+		hh, mi, ss := dtstart.Clock()
+		dtstart = time.Date(y, mo, d, hh, mi, ss, 0, loc)
+		s.Timezone = loc.String()
+	}
+	if in.RRule != nil {
+		if r, err = parseRRule(*in.RRule, dtstart.Location()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if in.StartAt != nil {
+		start, err := parseLocalTime(*in.StartAt, dtstart.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start_at: " + err.Error()})
+			return
+		}
+		if start.Before(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start_at must be in the future"})
+			return
+		}
+		dtstart = start
+	}
+	s.StartAt = dtstart
+	if in.Until != nil {
+		if r.Count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "series ends after COUNT occurrences; until cannot be set"})
+			return
+		}
+		r.Until = nil
+		if strings.TrimSpace(*in.Until) != "" {
+			u, err := parseLocalTime(*in.Until, dtstart.Location())
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "until: " + err.Error()})
+				return
+			}
+			r.Until = &u
+		}
+	}
+	if rhythmChanged || in.Until != nil {
+		s.RRule = r.String()
+		// 有下一次就（重新）啟用，否則結束；新規則從現在起重新產生
+		from := maxTime(s.GeneratedUntil, time.Now())
+		if rhythmChanged {
+			from = time.Now()
+			s.GeneratedUntil = from
+		}
+		s.Status = "ended"
+		if _, more := r.next(dtstart, from); more {
+			s.Status = "active"
+		} else if rhythmChanged {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rrule produces no future occurrences"})
+			return
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+    update public.task_series
+    set title=$2, description=$3, category=$4, location_text=$5, estimated_minutes=$6, prepay_amount_cents=$7,
+        checklist=$8, require_checklist=$9, offer_previous_assignee=$10, exclusive_minutes=$11,
+        rrule=$12, status=$13, timezone=$14, start_at=$15, generated_until=$16, updated_at=now()
+    where id=$1
+  `, s.ID, s.Title, s.Description, s.Category, s.LocationText, s.EstimatedMinutes, s.PrepayAmountCents,
+		s.Checklist, s.RequireChecklist, s.OfferPreviousAssignee, s.ExclusiveMinutes, s.RRule, s.Status,
+		s.Timezone, s.StartAt, s.GeneratedUntil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	// 新節奏：移除可重建的場次，下面再依新規則產生。有附件、議價中或已指定幫手的保留
+	// （刪掉會連帶刪除檔案紀錄、報價與指定對象）
+	removed := int64(0)
+	if rhythmChanged {
+		tag, err := tx.Exec(ctx, `
+      delete from public.tasks t
+      where t.series_id=$1 and t.status='open' and t.assigned_to='' and not t.series_edited and t.scheduled_at > now()
+        and not exists (select 1 from public.task_attachments a where a.task_id=t.id)
+        and not exists (select 1 from public.task_offers o where o.task_id=t.id and o.status='pending')
+        and not exists (select 1 from public.task_targets g where g.task_id=t.id)
+    `, s.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		removed = tag.RowsAffected()
+	}
+
+	// 加長估計時間：已建立的場次一起算進月預算（重建的場次在 insertOccurrence 逐一檢查）
+	if delta := s.EstimatedMinutes - prevMinutes; delta > 0 {
+		var n int
+		if err := tx.QueryRow(ctx, `
+      select count(*) from public.tasks
+      where series_id=$1 and status='open' and assigned_to='' and not series_edited and scheduled_at > now()
+    `, s.ID).Scan(&n); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if warning, reject, err := checkBudget(ctx, tx, s.Requester, n*estimatedCostCents(delta)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		} else if reject {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": warning})
+			return
+		}
+	}
+
+	// 套用到未來、未指派、未單獨修改的場次
+	rows, err := tx.Query(ctx, `
+    update public.tasks
+    set title=$2, description=$3, category=$4, location_text=$5, estimated_minutes=$6,
+        prepay_amount_cents=$7, require_checklist=$8
+    where series_id=$1 and status='open' and assigned_to='' and not series_edited and scheduled_at > now()
+    returning id
+  `, s.ID, s.Title, s.Description, s.Category, s.LocationText, s.EstimatedMinutes, s.PrepayAmountCents, s.RequireChecklist)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	var updated []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			updated = append(updated, id)
+		}
+	}
+	rows.Close()
+	if in.Checklist != nil {
+		for _, id := range updated {
+			if _, err := tx.Exec(ctx, `delete from public.task_checklist_items where task_id=$1`, id); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			if err := insertChecklistItems(ctx, tx, id, s.Checklist); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+		}
+	}
+	// 提前結束：取消 until 之後已建立的場次
+	cancelled := int64(0)
+	if r.Until != nil {
+		tag, err := tx.Exec(ctx, `
+      update public.tasks set status='cancelled', cancelled_by='requester'
+      where series_id=$1 and status='open' and occurrence_at > $2
+    `, s.ID, *r.Until)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		cancelled = tag.RowsAffected()
+	}
+	generated := 0
+	if rhythmChanged && s.Status == "active" {
+		if generated, err = generateSeries(ctx, tx, s, time.Now().Add(recurrenceHorizon())); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if s, err = scanSeries(tx.QueryRow(ctx, `select `+seriesColumns+` from public.task_series where id=$1`, s.ID)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	withNextOccurrences(&s, 5)
+	c.JSON(http.StatusOK, gin.H{
+		"series": s, "updated_tasks": len(updated), "cancelled_tasks": cancelled,
+		"removed_tasks": removed, "generated_tasks": generated,
+	})
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// DELETE /task-series/:id — cancels the series and its future open occurrences.
+func cancelSeries(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+	s, ok := loadMySeries(c, tx, true)
+	if !ok {
+		return
+	}
+	if _, err := tx.Exec(ctx, `update public.task_series set status='cancelled', updated_at=now() where id=$1`, s.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	tag, err := tx.Exec(ctx, `
+    update public.tasks set status='cancelled', cancelled_by='requester'
+    where series_id=$1 and status='open' and scheduled_at > now()
+  `, s.ID)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "cancelled", "cancelled_tasks": tag.RowsAffected()})
+}
+
+type seriesOccurrence struct {
+	OccurrenceAt time.Time  `json:"occurrence_at"`
+	Status       string     `json:"status"` // scheduled | skipped | open | completed | cancelled
+	TaskID       string     `json:"task_id,omitempty"`
+	ScheduledAt  *time.Time `json:"scheduled_at,omitempty"` // differs from occurrence_at if edited
+	AssignedTo   string     `json:"assigned_to,omitempty"`
+}
+
+// GET /task-series/:id/occurrences?days=30 — upcoming slots merged with
+// generated tasks and skips.
+func listSeriesOccurrences(c *gin.Context) {
+	s, ok := loadMySeries(c, db, false)
+	if !ok {
+		return
+	}
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	days = min(max(days, 1), maxOccurrenceListDays)
+	ctx := c.Request.Context()
+	now := time.Now()
+	upto := now.AddDate(0, 0, days)
+
+	byAt := map[int64]*seriesOccurrence{}
+	var out []*seriesOccurrence
+	add := func(o *seriesOccurrence) {
+		if prev, ok := byAt[o.OccurrenceAt.Unix()]; ok {
+			*prev = *o
+			return
+		}
+		byAt[o.OccurrenceAt.Unix()] = o
+		out = append(out, o)
+	}
+	if r, dtstart, err := s.rule(); err == nil && s.Status != "cancelled" {
+		for _, at := range r.occurrences(dtstart, now, upto) {
+			add(&seriesOccurrence{OccurrenceAt: at, Status: "scheduled"})
+		}
+	}
+	rows, err := db.Query(ctx, `
+    select occurrence_at from public.task_series_skips
+    where series_id=$1 and occurrence_at > $2 and occurrence_at <= $3
+  `, s.ID, now, upto)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	for rows.Next() {
+		var at time.Time
+		if rows.Scan(&at) == nil {
+			add(&seriesOccurrence{OccurrenceAt: at, Status: "skipped"})
+		}
+	}
+	rows.Close()
+	rows, err = db.Query(ctx, `
+    select id, occurrence_at, scheduled_at, status, assigned_to from public.tasks
+    where series_id=$1 and occurrence_at > $2 and occurrence_at <= $3
+  `, s.ID, now, upto)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	for rows.Next() {
+		o := &seriesOccurrence{}
+		if rows.Scan(&o.TaskID, &o.OccurrenceAt, &o.ScheduledAt, &o.Status, &o.AssignedTo) == nil {
+			add(o)
+		}
+	}
+	rows.Close()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].OccurrenceAt.Before(out[j].OccurrenceAt) })
+	c.JSON(http.StatusOK, out)
+}
+
+// occurrenceFromBody parses {occurrence_at} and checks it is a future slot
+// of the series.
+func occurrenceFromBody(c *gin.Context, s TaskSeries) (time.Time, bool) {
+	var in struct {
+		OccurrenceAt string `json:"occurrence_at"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return time.Time{}, false
+	}
+	r, dtstart, err := s.rule()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid stored rule"})
+		return time.Time{}, false
+	}
+	at, err := parseLocalTime(in.OccurrenceAt, dtstart.Location())
+	if err != nil || !r.isOccurrence(dtstart, at) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "occurrence_at is not an occurrence of this series"})
+		return time.Time{}, false
+	}
+	if !at.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "occurrence is in the past"})
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// POST /task-series/:id/occurrences/skip {occurrence_at} — cancels one
+// occurrence, generated or not.
+func skipSeriesOccurrence(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+	s, ok := loadMySeries(c, tx, true)
+	if !ok {
+		return
+	}
+	at, ok := occurrenceFromBody(c, s)
+	if !ok {
+		return
+	}
+	if _, err := tx.Exec(ctx, `
+    insert into public.task_series_skips(series_id,occurrence_at) values ($1,$2)
+    on conflict do nothing
+  `, s.ID, at); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	tag, err := tx.Exec(ctx, `
+    update public.tasks set status='cancelled', cancelled_by='requester'
+    where series_id=$1 and occurrence_at=$2 and status='open'
+  `, s.ID, at)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"occurrence_at": at, "status": "skipped", "cancelled_task": tag.RowsAffected() > 0})
+}
+
+// POST /task-series/:id/occurrences/materialize {occurrence_at} — creates
+// the task for a slot beyond the horizon now, so it can be edited with
+// PATCH /tasks/:id.
+func materializeSeriesOccurrence(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+	s, ok := loadMySeries(c, tx, true)
+	if !ok {
+		return
+	}
+	if s.Status != "active" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "series not active"})
+		return
+	}
+	at, ok := occurrenceFromBody(c, s)
+	if !ok {
+		return
+	}
+	if isSkipped(ctx, tx, s.ID, at) {
+		c.JSON(http.StatusConflict, gin.H{"error": "occurrence was skipped"})
+		return
+	}
+	helper := ""
+	if s.OfferPreviousAssignee {
+		helper = previousAssignee(ctx, tx, s)
+	}
+	id, created, err := insertOccurrence(ctx, tx, s, at, helper)
+	if errors.Is(err, errOverBudget) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	t, err := scanTask(tx.QueryRow(ctx, `select `+taskColumns+` from public.tasks where id=$1`, id))
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, t)
+}
@@ -0,0 +1,260 @@
+package main
+
+// Reliability scores, computed from data we already store:
+//   late_clock_ins  first clock-in more than onTimeGrace after scheduled_at (window_end for windows)
+//   no_shows        scheduled, not cancelled task with no clock-in
+//                   noShowAfter past its start
+//   cancellations   tasks cancelled by the user's side (tasks.cancelled_by):
+//                   as helper, or as requester after someone was assigned
+//   withdrawals     the helper backing out before the work: offers they
+//                   withdrew without proposing again in that thread, and
+//                   admin unassigns attributed to the helper (unassigned_by)
+//   disputes        rejected expense claims
+//   stale_worklogs  sessions left running longer than staleWorklogAfter
+//                   (what an auto-close would have cut off)
+// score = 100 − 100 × Σ(weight × count) / (engagements + reliabilityPrior),
+// clamped to 0–100, where engagements = tasks assigned to the user + the
+// user's own tasks that got an assignee. The prior keeps a single incident
+// from sinking a new user.
+// Recomputation is incremental: triggers queue users in reliability_dirty
+// and reliabilityLoop recomputes just those (plus users whose scheduled task
+// just crossed the no-show line).
+// Scores gate acceptTask (per category, once engagements ≥
+// reliabilityMinSample), rank helpers for direct offers, and in
+// listAvailableTasks sink tasks of requesters below reliabilityFlakyBelow.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	reliabilityLoopInterval = 5 * time.Minute
+	reliabilityBatch        = 200
+	reliabilityPrior        = 5
+	reliabilityMinSample    = 3
+	reliabilityFlakyBelow   = 60
+	noShowAfter             = 2 * time.Hour
+	staleWorklogAfter       = 12 * time.Hour
+)
+
+var reliabilityWeights = map[string]float64{
+	"late_clock_ins": 0.5,
+	"no_shows":       3,
+	"cancellations":  1.5,
+	"withdrawals":    1.5,
+	"disputes":       1,
+	"stale_worklogs": 0.5,
+}
+
+type Reliability struct {
+	Score       float64        `json:"score"`
+	Engagements int            `json:"engagements"`
+	Components  map[string]int `json:"components"`
+	ComputedAt  time.Time      `json:"computed_at"`
+}
+
+// computeReliability reads the raw counts for uid and derives the score.
+func computeReliability(ctx context.Context, uid string) (Reliability, error) {
+	now := time.Now()
+	var asHelper, ownAssigned, late, noShows, helperCancels, ownCancels, withdrawals, disputes, stale int
+	err := db.QueryRow(ctx, `
+    with a as (
+      select t.status, t.cancelled_by, coalesce(t.window_end, t.scheduled_at) as scheduled_at, t.is_immediate, -- 時段任務：到 window_end 前開始都算準時
+             (select min(w.start_at) from public.worklogs w where w.task_id = t.id and w."user" = $1) as first_in
+      from public.tasks t where t.assigned_to = $1
+    )
+    select
+      (select count(*)::int from a),
+      (select count(*)::int from public.tasks where requester = $1 and assigned_to <> ''),
+      (select count(*)::int from a where not is_immediate and scheduled_at is not null and first_in is not null
+                                     and extract(epoch from (first_in - scheduled_at)) > $2),
+      (select count(*)::int from a where not is_immediate and scheduled_at is not null and first_in is null
+                                     and scheduled_at < $3 and status not in ('completed','cancelled')),
+      (select count(*)::int from a where status = 'cancelled' and cancelled_by = 'helper'),
+      (select count(*)::int from public.tasks where requester = $1 and assigned_to <> '' and status = 'cancelled'
+                                                and cancelled_by = 'requester'),
+      (select count(*)::int from public.admin_audit_log
+        where action = 'task_transition' and detail->>'previous_assignee' = $1
+          and coalesce((detail->>'unassigned')::boolean, false)
+          and coalesce(detail->>'unassigned_by', 'helper') = 'helper') -- 舊紀錄沒有歸屬，照舊算幫手
+      + (select count(*)::int from public.task_offers o
+          where o.helper_id = $1 and o.proposed_by = 'helper' and o.status = 'withdrawn'
+            and not exists (select 1 from public.task_offers n -- 改價重送不算
+                            where n.task_id = o.task_id and n.helper_id = o.helper_id and n.created_at > o.created_at)),
+      (select count(*)::int from public.task_expenses where submitted_by = $1 and status = 'rejected'),
+      (select count(*)::int from public.worklogs
+        where "user" = $1 and extract(epoch from (coalesce(end_at, $4) - start_at)) > $5)
+  `, uid, int(onTimeGrace.Seconds()), now.Add(-noShowAfter), now, int(staleWorklogAfter.Seconds())).Scan(
+		&asHelper, &ownAssigned, &late, &noShows, &helperCancels, &ownCancels, &withdrawals, &disputes, &stale)
+	if err != nil {
+		return Reliability{}, err
+	}
+
+	r := Reliability{
+		Engagements: asHelper + ownAssigned,
+		Components: map[string]int{
+			"late_clock_ins": late,
+			"no_shows":       noShows,
+			"cancellations":  helperCancels + ownCancels,
+			"withdrawals":    withdrawals,
+			"disputes":       disputes,
+			"stale_worklogs": stale,
+		},
+		ComputedAt: now,
+	}
+	penalty := 0.0
+	for k, n := range r.Components {
+		penalty += reliabilityWeights[k] * float64(n)
+	}
+	score := 100 - 100*penalty/float64(r.Engagements+reliabilityPrior)
+	r.Score = math.Round(math.Max(0, math.Min(100, score))*100) / 100
+	return r, nil
+}
+
+func storeReliability(ctx context.Context, uid string, r Reliability) error {
+	comps, _ := json.Marshal(r.Components)
+	_, err := db.Exec(ctx, `
+    insert into public.reliability_scores(user_id,score,engagements,components,computed_at)
+    values ($1,$2,$3,$4,$5)
+    on conflict (user_id) do update
+    set score=$2, engagements=$3, components=$4, computed_at=$5
+  `, uid, r.Score, r.Engagements, comps, r.ComputedAt)
+	return err
+}
+
+// loadReliability returns the stored score; ok=false if none yet.
+func loadReliability(ctx context.Context, q dbtx, uid string) (r Reliability, ok bool, err error) {
+	var comps []byte
+	err = q.QueryRow(ctx, `
+    select score::float8, engagements, components, computed_at
+    from public.reliability_scores where user_id=$1
+  `, uid).Scan(&r.Score, &r.Engagements, &comps, &r.ComputedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r, false, nil
+	}
+	if err != nil {
+		return r, false, err
+	}
+	_ = json.Unmarshal(comps, &r.Components)
+	return r, true, nil
+}
+
+// flakyRequesterSQL is true for tasks (alias t) whose requester has an
+// established score below reliabilityFlakyBelow; listAvailableTasks orders by it.
+var flakyRequesterSQL = fmt.Sprintf(`coalesce((
+      select r.score < %d and r.engagements >= %d
+      from public.reliability_scores r where r.user_id = t.requester), false)`,
+	reliabilityFlakyBelow, reliabilityMinSample)
+
+// reliabilityAllows: gate for acceptTask. Users below the sample size are
+// not gated.
+func reliabilityAllows(ctx context.Context, q dbtx, uid string, minScore float64) (bool, Reliability, error) {
+	r, ok, err := loadReliability(ctx, q, uid)
+	if err != nil || !ok || minScore <= 0 || r.Engagements < reliabilityMinSample {
+		return true, r, err
+	}
+	return r.Score >= minScore, r, nil
+}
+
+// -------- Job --------
+
+func reliabilityLoop() {
+	t := time.NewTicker(reliabilityLoopInterval)
+	defer t.Stop()
+	last := time.Now().Add(-24 * time.Hour)
+	for ; ; <-t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), reliabilityLoopInterval)
+		now := time.Now()
+		// 排程時間剛跨過 no-show 門檻的任務：沒有任何寫入會觸發 trigger，這裡補標記
+		if _, err := db.Exec(ctx, `
+      insert into public.reliability_dirty(user_id)
+      select distinct assigned_to from public.tasks
+      where assigned_to <> '' and coalesce(window_end, scheduled_at) >= $1 and coalesce(window_end, scheduled_at) < $2
+      on conflict do nothing
+    `, last.Add(-noShowAfter), now.Add(-noShowAfter)); err != nil {
+			log.Printf("[reliability] mark no-shows: %v", err)
+		} else {
+			last = now
+		}
+		for {
+			n, err := recomputeDirtyReliability(ctx)
+			if err != nil {
+				log.Printf("[reliability] recompute: %v", err)
+				break
+			}
+			if n < reliabilityBatch {
+				break
+			}
+		}
+		cancel()
+	}
+}
+
+// recomputeDirtyReliability takes one batch off the queue and recomputes it.
+// Users that fail are queued again.
+func recomputeDirtyReliability(ctx context.Context) (int, error) {
+	rows, err := db.Query(ctx, `
+    delete from public.reliability_dirty
+    where user_id in (
+      select user_id from public.reliability_dirty order by marked_at limit $1 for update skip locked
+    )
+    returning user_id
+  `, reliabilityBatch)
+	if err != nil {
+		return 0, err
+	}
+	var users []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		users = append(users, uid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, uid := range users {
+		r, err := computeReliability(ctx, uid)
+		if err == nil {
+			err = storeReliability(ctx, uid, r)
+		}
+		if err != nil {
+			log.Printf("[reliability] %s: %v", uid, err)
+			_, _ = db.Exec(ctx, `select public.mark_reliability_dirty($1)`, uid)
+		}
+	}
+	return len(users), nil
+}
+
+// -------- Reliability handlers --------
+
+// GET /me/reliability — score with its component breakdown.
+func getMyReliability(c *gin.Context) {
+	r, ok, err := loadReliability(c.Request.Context(), db, c.GetString("uid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"score": nil, "engagements": 0, "components": gin.H{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"score": r.Score, "engagements": r.Engagements, "components": r.Components,
+		"weights": reliabilityWeights, "computed_at": r.ComputedAt,
+	})
+}
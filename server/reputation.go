@@ -0,0 +1,45 @@
+package main
+
+// Reputation statistics shown on public profiles (see getUserProfile).
+
+import (
+	"context"
+	"time"
+)
+
+// onTimeGrace: a clock-in up to this long after scheduled_at counts as on time.
+const onTimeGrace = 10 * time.Minute
+
+type helperStats struct {
+	CompletedTasks int
+	ScheduledTasks int      // completed tasks with a scheduled start and a clock-in
+	OnTimeRate     *float64 // nil without scheduled tasks
+}
+
+// loadHelperStats: completed tasks as assignee, and how many of the scheduled
+// ones started (first clock-in) within onTimeGrace of scheduled_at.
+func loadHelperStats(ctx context.Context, uid string) (helperStats, error) {
+	var s helperStats
+	var onTime int
+	err := db.QueryRow(ctx, `
+    with done as (
+      select coalesce(t.window_end, t.scheduled_at) as scheduled_at, t.is_immediate,
+             (select min(w.start_at) from public.worklogs w
+              where w.task_id = t.id and w."user" = t.assigned_to) as first_in
+      from public.tasks t
+      where t.assigned_to = $1 and t.status = 'completed'
+    )
+    select count(*)::int,
+           count(*) filter (where not is_immediate and scheduled_at is not null and first_in is not null)::int,
+           count(*) filter (where not is_immediate and scheduled_at is not null and extract(epoch from (first_in - scheduled_at)) <= $2)::int
+    from done
+  `, uid, int(onTimeGrace.Seconds())).Scan(&s.CompletedTasks, &s.ScheduledTasks, &onTime)
+	if err != nil {
+		return s, err
+	}
+	if s.ScheduledTasks > 0 {
+		r := float64(onTime) / float64(s.ScheduledTasks)
+		s.OnTimeRate = &r
+	}
+	return s, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+// Reviews after completion. The requester and the assignee of a completed
+// task can each leave one review (1–5 stars + text) within the review
+// window. Double-blind: a review becomes visible once the other side has
+// also reviewed, or when the window closes. Submitted reviews are final.
+// Optional ENV:
+//   REVIEW_WINDOW_DAYS=14
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const maxReviewLen = 2000
+
+func reviewWindow() time.Duration {
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("REVIEW_WINDOW_DAYS"))); err == nil && v > 0 {
+		return time.Duration(v) * 24 * time.Hour
+	}
+	return 14 * 24 * time.Hour
+}
+
+// revealedReviewSQL: condition for review r being public.
+// $1 = completed_at cutoff (now - review window).
+const revealedReviewSQL = `(
+      exists (select 1 from public.task_reviews o where o.task_id = r.task_id and o.reviewer = r.reviewee)
+      or exists (select 1 from public.tasks t where t.id = r.task_id and t.completed_at < $1)
+    )`
+
+type Review struct {
+	ID        string    `json:"id"`
+	TaskID    string    `json:"task_id"`
+	Reviewer  string    `json:"reviewer"`
+	Reviewee  string    `json:"reviewee"`
+	Role      string    `json:"role"` // reviewer's role: requester | assignee
+	Stars     int       `json:"stars"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const reviewColumns = `r.id,r.task_id,r.reviewer,r.reviewee,r.role,r.stars,r.body,r.created_at`
+
+func scanReview(rows interface{ Scan(dest ...any) error }) (Review, error) {
+	var r Review
+	err := rows.Scan(&r.ID, &r.TaskID, &r.Reviewer, &r.Reviewee, &r.Role, &r.Stars, &r.Body, &r.CreatedAt)
+	return r, err
+}
+
+// userRating: average stars and count of revealed reviews about uid
+// (avg is nil without reviews).
+func userRating(ctx context.Context, uid string) (avg *float64, count int, err error) {
+	err = db.QueryRow(ctx, `
+    select round(avg(r.stars)::numeric, 2)::float8, count(*)::int
+    from public.task_reviews r
+    where r.reviewee = $2 and `+revealedReviewSQL+`
+  `, time.Now().Add(-reviewWindow()), uid).Scan(&avg, &count)
+	return avg, count, err
+}
+
+// -------- Review handlers --------
+
+// POST /tasks/:id/reviews {"stars": 1-5, "body": "..."}
+func createReview(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var in struct {
+		Stars int    `json:"stars"`
+		Body  string `json:"body"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	in.Body = strings.TrimSpace(in.Body)
+	if in.Stars < 1 || in.Stars > 5 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stars must be 1-5"})
+		return
+	}
+	if len(in.Body) > maxReviewLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "review too long"})
+		return
+	}
+
+	var requester, assignedTo, status string
+	var completedAt *time.Time
+	if err := db.QueryRow(ctx, `
+    select requester,assigned_to,status,completed_at from public.tasks where id=$1
+  `, taskID).Scan(&requester, &assignedTo, &status, &completedAt); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	var role, reviewee string
+	switch me {
+	case requester:
+		role, reviewee = "requester", assignedTo
+	case assignedTo:
+		role, reviewee = "assignee", requester
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+		return
+	}
+	if status != "completed" || completedAt == nil || reviewee == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task not completed"})
+		return
+	}
+	if time.Since(*completedAt) > reviewWindow() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "review window closed"})
+		return
+	}
+
+	r, err := scanReview(db.QueryRow(ctx, `
+    insert into public.task_reviews as r(task_id,reviewer,reviewee,role,stars,body)
+    values ($1,$2,$3,$4,$5,$6)
+    on conflict (task_id, reviewer) do nothing
+    returning `+reviewColumns, taskID, me, reviewee, role, in.Stars, in.Body))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusConflict, gin.H{"error": "already reviewed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, r)
+}
+
+// GET /tasks/:id/reviews — participants only. "theirs" stays null until revealed.
+func getTaskReviews(c *gin.Context) {
+	taskID := c.Param("id")
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var requester, assignedTo string
+	var completedAt *time.Time
+	if err := db.QueryRow(ctx, `
+    select requester,assigned_to,completed_at from public.tasks where id=$1
+  `, taskID).Scan(&requester, &assignedTo, &completedAt); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if me != requester && me != assignedTo {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+		return
+	}
+
+	rows, err := db.Query(ctx, `select `+reviewColumns+` from public.task_reviews r where r.task_id=$1`, taskID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	var mine, theirs *Review
+	for rows.Next() {
+		r, err := scanReview(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		if r.Reviewer == me {
+			mine = &r
+		} else {
+			theirs = &r
+		}
+	}
+
+	var closesAt *time.Time
+	windowClosed := false
+	if completedAt != nil {
+		t := completedAt.Add(reviewWindow())
+		closesAt = &t
+		windowClosed = time.Now().After(t)
+	}
+	revealed := windowClosed || (mine != nil && theirs != nil)
+	out := gin.H{
+		"mine":                 mine,
+		"theirs":               nil,
+		"other_side_submitted": theirs != nil,
+		"revealed":             revealed,
+		"window_closes_at":     closesAt,
+		"can_review":           mine == nil && completedAt != nil && !windowClosed,
+	}
+	if revealed {
+		out["theirs"] = theirs
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GET /users/:id/reviews?limit=&offset= — revealed reviews about a user.
+func listUserReviews(c *gin.Context) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+	limit, offset := pageParams(c)
+
+	rows, err := db.Query(ctx, `
+    select `+reviewColumns+`
+    from public.task_reviews r
+    where r.reviewee = $2 and `+revealedReviewSQL+`
+    order by r.created_at desc
+    limit $3 offset $4
+  `, time.Now().Add(-reviewWindow()), id, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []Review{}
+	for rows.Next() {
+		r, err := scanReview(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, r)
+	}
+	avg, count, err := userRating(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rating": avg, "rating_count": count, "items": out})
+}
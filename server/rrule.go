@@ -0,0 +1,262 @@
+package main
+
+// Minimal RFC 5545 RRULE support for recurring tasks (see recurring.go).
+// Supported: FREQ=DAILY|WEEKLY|MONTHLY, INTERVAL, BYDAY (WEEKLY, plain
+// weekdays), BYMONTHDAY (MONTHLY, 1..31 or -1 = last day), COUNT, UNTIL.
+// Weeks start on Monday. Occurrences are built in the series' time zone so
+// "every Tuesday 15:00" stays at 15:00 local across DST changes.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // IANA zones even on minimal images
+)
+
+const (
+	maxRRuleCount    = 1000
+	maxRRuleInterval = 365
+	maxRRulePeriods  = 100000 // 保護：避免永遠找不到下一次的規則無限迴圈
+)
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+type rrule struct {
+	Freq       string // DAILY | WEEKLY | MONTHLY
+	Interval   int
+	ByDay      []time.Weekday
+	ByMonthDay []int
+	Count      int        // 0 = unlimited
+	Until      *time.Time // inclusive
+}
+
+// parseRRule parses "FREQ=WEEKLY;BYDAY=TU;COUNT=10" (optional "RRULE:" prefix).
+// A date-only UNTIL means the end of that day in loc.
+func parseRRule(s string, loc *time.Location) (rrule, error) {
+	r := rrule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(strings.ToUpper(s)), "RRULE:")
+	if s == "" {
+		return r, errors.New("rrule required")
+	}
+	for _, part := range strings.Split(s, ";") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok || v == "" {
+			return r, fmt.Errorf("invalid rrule part %q", part)
+		}
+		switch k {
+		case "FREQ":
+			if v != "DAILY" && v != "WEEKLY" && v != "MONTHLY" {
+				return r, errors.New("FREQ must be DAILY, WEEKLY or MONTHLY")
+			}
+			r.Freq = v
+		case "INTERVAL":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxRRuleInterval {
+				return r, errors.New("INTERVAL must be between 1 and 365")
+			}
+			r.Interval = n
+		case "BYDAY":
+			for _, d := range strings.Split(v, ",") {
+				wd, ok := rruleWeekdays[d]
+				if !ok {
+					return r, fmt.Errorf("invalid BYDAY %q", d)
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(v, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n > 31 || n < -1 {
+					return r, fmt.Errorf("invalid BYMONTHDAY %q", d)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxRRuleCount {
+				return r, errors.New("COUNT must be between 1 and 1000")
+			}
+			r.Count = n
+		case "UNTIL":
+			var t time.Time
+			var err error
+			if len(v) == 8 {
+				t, err = time.ParseInLocation("20060102", v, loc)
+				t = t.AddDate(0, 0, 1).Add(-time.Second)
+			} else {
+				t, err = time.Parse("20060102T150405Z", v)
+			}
+			if err != nil {
+				return r, errors.New("UNTIL must be YYYYMMDD or YYYYMMDDTHHMMSSZ")
+			}
+			r.Until = &t
+		default:
+			return r, fmt.Errorf("unsupported rrule part %s", k)
+		}
+	}
+	switch {
+	case r.Freq == "":
+		return r, errors.New("FREQ required")
+	case len(r.ByDay) > 0 && r.Freq != "WEEKLY":
+		return r, errors.New("BYDAY is only supported with FREQ=WEEKLY")
+	case len(r.ByMonthDay) > 0 && r.Freq != "MONTHLY":
+		return r, errors.New("BYMONTHDAY is only supported with FREQ=MONTHLY")
+	case r.Count > 0 && r.Until != nil:
+		return r, errors.New("COUNT and UNTIL are mutually exclusive")
+	}
+	return r, nil
+}
+
+// String returns the canonical form stored in the DB.
+func (r rrule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		var days []string
+		for _, wd := range r.sortedByDay() {
+			for k, v := range rruleWeekdays {
+				if v == wd {
+					days = append(days, k)
+				}
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		var days []string
+		for _, d := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(d))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// sortedByDay: BYDAY ordered Monday-first, deduplicated.
+func (r rrule) sortedByDay() []time.Weekday {
+	seen := map[time.Weekday]bool{}
+	var out []time.Weekday
+	for _, wd := range r.ByDay {
+		if !seen[wd] {
+			seen[wd] = true
+			out = append(out, wd)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return (out[i]+6)%7 < (out[j]+6)%7 })
+	return out
+}
+
+// each calls fn for every occurrence in (after, upto], in order, starting
+// from dtstart (whose wall clock time is used for every occurrence). fn
+// returns false to stop.
+func (r rrule) each(dtstart, after, upto time.Time, fn func(time.Time) bool) {
+	loc := dtstart.Location()
+	y, m, d := dtstart.Date()
+	hh, mm, ss := dtstart.Clock()
+	at := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, hh, mm, ss, 0, loc) }
+
+	n := 0
+	for p := 0; p < maxRRulePeriods; p++ {
+		var periodStart time.Time
+		var cands []time.Time
+		switch r.Freq {
+		case "DAILY":
+			periodStart = at(y, m, d+p*r.Interval)
+			cands = []time.Time{periodStart}
+		case "WEEKLY":
+			monday := d - int((dtstart.Weekday()+6)%7) + p*7*r.Interval
+			periodStart = time.Date(y, m, monday, 0, 0, 0, 0, loc)
+			days := r.sortedByDay()
+			if len(days) == 0 {
+				days = []time.Weekday{dtstart.Weekday()}
+			}
+			for _, wd := range days {
+				cands = append(cands, at(y, m, monday+int((wd+6)%7)))
+			}
+		case "MONTHLY":
+			periodStart = time.Date(y, m+time.Month(p*r.Interval), 1, 0, 0, 0, 0, loc)
+			py, pm, _ := periodStart.Date()
+			last := time.Date(py, pm+1, 0, 0, 0, 0, 0, loc).Day()
+			days := r.ByMonthDay
+			if len(days) == 0 {
+				days = []int{d}
+			}
+			var ds []int
+			for _, md := range days {
+				if md == -1 {
+					md = last
+				}
+				if md <= last { // 2 月沒有 30 號：依 RFC 跳過
+					ds = append(ds, md)
+				}
+			}
+			sort.Ints(ds)
+			for i, md := range ds {
+				if i == 0 || md != ds[i-1] {
+					cands = append(cands, at(py, pm, md))
+				}
+			}
+		default:
+			return
+		}
+		if periodStart.After(upto) {
+			return
+		}
+		for _, t := range cands {
+			if t.Before(dtstart) {
+				continue
+			}
+			if (r.Count > 0 && n >= r.Count) || (r.Until != nil && t.After(*r.Until)) {
+				return
+			}
+			n++
+			if t.After(upto) {
+				return
+			}
+			if t.After(after) && !fn(t) {
+				return
+			}
+		}
+	}
+}
+
+// occurrences lists the occurrences in (after, upto].
+func (r rrule) occurrences(dtstart, after, upto time.Time) []time.Time {
+	var out []time.Time
+	r.each(dtstart, after, upto, func(t time.Time) bool {
+		out = append(out, t)
+		return true
+	})
+	return out
+}
+
+// next returns the first occurrence after t, if any.
+func (r rrule) next(dtstart, after time.Time) (time.Time, bool) {
+	var out time.Time
+	found := false
+	r.each(dtstart, after, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC), func(t time.Time) bool {
+		out, found = t, true
+		return false
+	})
+	return out, found
+}
+
+// isOccurrence reports whether t is exactly one of the rule's occurrences.
+func (r rrule) isOccurrence(dtstart, t time.Time) bool {
+	got, ok := r.next(dtstart, t.Add(-time.Second))
+	return ok && got.Equal(t)
+}
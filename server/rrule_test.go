@@ -0,0 +1,240 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoc(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestRRuleOccurrences(t *testing.T) {
+	berlin := mustLoc(t, "Europe/Berlin")
+	at := func(y int, m time.Month, d, hh, mm int) time.Time { return time.Date(y, m, d, hh, mm, 0, 0, berlin) }
+
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart time.Time
+		after   time.Time // zero = just before dtstart
+		upto    time.Time
+		want    []time.Time
+	}{
+		{
+			// 2025-03-30 切夏令時間：牆上時間不變，UTC 偏移改變
+			name:    "weekly across DST start",
+			rule:    "FREQ=WEEKLY;BYDAY=TU",
+			dtstart: at(2025, 3, 18, 15, 0),
+			upto:    at(2025, 4, 2, 0, 0),
+			want:    []time.Time{at(2025, 3, 18, 15, 0), at(2025, 3, 25, 15, 0), at(2025, 4, 1, 15, 0)},
+		},
+		{
+			name:    "weekly across DST end",
+			rule:    "FREQ=WEEKLY;BYDAY=SU",
+			dtstart: at(2025, 10, 19, 9, 30),
+			upto:    at(2025, 11, 3, 0, 0),
+			want:    []time.Time{at(2025, 10, 19, 9, 30), at(2025, 10, 26, 9, 30), at(2025, 11, 2, 9, 30)},
+		},
+		{
+			name:    "several weekdays, dtstart mid-week",
+			rule:    "FREQ=WEEKLY;BYDAY=FR,MO,WE",
+			dtstart: at(2025, 1, 8, 8, 0), // Wednesday
+			upto:    at(2025, 1, 14, 0, 0),
+			want:    []time.Time{at(2025, 1, 8, 8, 0), at(2025, 1, 10, 8, 0), at(2025, 1, 13, 8, 0)},
+		},
+		{
+			name:    "every other week",
+			rule:    "FREQ=WEEKLY;INTERVAL=2",
+			dtstart: at(2025, 1, 7, 15, 0),
+			upto:    at(2025, 2, 5, 0, 0),
+			want:    []time.Time{at(2025, 1, 7, 15, 0), at(2025, 1, 21, 15, 0), at(2025, 2, 4, 15, 0)},
+		},
+		{
+			name:    "monthly on the 31st skips short months",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=31",
+			dtstart: at(2025, 1, 31, 10, 0),
+			upto:    at(2025, 6, 1, 0, 0),
+			want:    []time.Time{at(2025, 1, 31, 10, 0), at(2025, 3, 31, 10, 0), at(2025, 5, 31, 10, 0)},
+		},
+		{
+			name:    "monthly from dtstart day never rolls into the next month",
+			rule:    "FREQ=MONTHLY",
+			dtstart: at(2025, 1, 30, 10, 0),
+			upto:    at(2025, 4, 1, 0, 0),
+			want:    []time.Time{at(2025, 1, 30, 10, 0), at(2025, 3, 30, 10, 0)},
+		},
+		{
+			name:    "last day of month",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=-1",
+			dtstart: at(2024, 1, 31, 18, 0),
+			upto:    at(2024, 5, 1, 0, 0),
+			want:    []time.Time{at(2024, 1, 31, 18, 0), at(2024, 2, 29, 18, 0), at(2024, 3, 31, 18, 0), at(2024, 4, 30, 18, 0)},
+		},
+		{
+			name:    "last day and 30th collapse in April",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=30,-1",
+			dtstart: at(2025, 3, 30, 12, 0),
+			upto:    at(2025, 5, 1, 0, 0),
+			want:    []time.Time{at(2025, 3, 30, 12, 0), at(2025, 3, 31, 12, 0), at(2025, 4, 30, 12, 0)},
+		},
+		{
+			name:    "count exhausted",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: at(2025, 1, 1, 9, 0),
+			upto:    at(2025, 2, 1, 0, 0),
+			want:    []time.Time{at(2025, 1, 1, 9, 0), at(2025, 1, 2, 9, 0), at(2025, 1, 3, 9, 0)},
+		},
+		{
+			name:    "count includes occurrences before after",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: at(2025, 1, 1, 9, 0),
+			after:   at(2025, 1, 2, 9, 0),
+			upto:    at(2025, 2, 1, 0, 0),
+			want:    []time.Time{at(2025, 1, 3, 9, 0)},
+		},
+		{
+			name:    "date-only until is inclusive in the local zone",
+			rule:    "FREQ=DAILY;UNTIL=20250103",
+			dtstart: at(2025, 1, 1, 23, 30),
+			upto:    at(2025, 2, 1, 0, 0),
+			want:    []time.Time{at(2025, 1, 1, 23, 30), at(2025, 1, 2, 23, 30), at(2025, 1, 3, 23, 30)},
+		},
+		{
+			name:    "utc until",
+			rule:    "FREQ=DAILY;UNTIL=20250103T080000Z", // 09:00 Berlin
+			dtstart: at(2025, 1, 1, 9, 0),
+			upto:    at(2025, 2, 1, 0, 0),
+			want:    []time.Time{at(2025, 1, 1, 9, 0), at(2025, 1, 2, 9, 0), at(2025, 1, 3, 9, 0)},
+		},
+		{
+			name:    "upto is inclusive",
+			rule:    "FREQ=DAILY",
+			dtstart: at(2025, 1, 1, 9, 0),
+			upto:    at(2025, 1, 2, 9, 0),
+			want:    []time.Time{at(2025, 1, 1, 9, 0), at(2025, 1, 2, 9, 0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseRRule(tt.rule, berlin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after := tt.after
+			if after.IsZero() {
+				after = tt.dtstart.Add(-time.Second)
+			}
+			got := r.occurrences(tt.dtstart, after, tt.upto)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("[%d] = %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRRuleDSTOffsets(t *testing.T) {
+	berlin := mustLoc(t, "Europe/Berlin")
+	r, _ := parseRRule("FREQ=WEEKLY;BYDAY=TU", berlin)
+	dtstart := time.Date(2025, 3, 25, 15, 0, 0, 0, berlin)
+	got := r.occurrences(dtstart, dtstart.Add(-time.Second), dtstart.AddDate(0, 0, 7))
+	if len(got) != 2 {
+		t.Fatalf("got %v", got)
+	}
+	if h := got[0].UTC().Hour(); h != 14 {
+		t.Errorf("before DST: %d:00 UTC, want 14:00", h)
+	}
+	if h := got[1].UTC().Hour(); h != 13 {
+		t.Errorf("after DST: %d:00 UTC, want 13:00", h)
+	}
+}
+
+func TestRRuleNextAndIsOccurrence(t *testing.T) {
+	berlin := mustLoc(t, "Europe/Berlin")
+	dtstart := time.Date(2025, 1, 7, 15, 0, 0, 0, berlin)
+	r, _ := parseRRule("FREQ=WEEKLY;BYDAY=TU;COUNT=3", berlin)
+
+	next, ok := r.next(dtstart, dtstart)
+	if !ok || !next.Equal(dtstart.AddDate(0, 0, 7)) {
+		t.Errorf("next = %s, %v", next, ok)
+	}
+	if _, ok := r.next(dtstart, dtstart.AddDate(0, 0, 14)); ok {
+		t.Error("next after the last COUNT occurrence should not exist")
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"dtstart", dtstart, true},
+		{"second", dtstart.AddDate(0, 0, 7), true},
+		{"same instant in UTC", dtstart.AddDate(0, 0, 14).UTC(), true},
+		{"after COUNT", dtstart.AddDate(0, 0, 21), false},
+		{"wrong hour", dtstart.AddDate(0, 0, 7).Add(time.Hour), false},
+		{"wrong day", dtstart.AddDate(0, 0, 8), false},
+		{"before dtstart", dtstart.AddDate(0, 0, -7), false},
+	}
+	for _, tt := range tests {
+		if got := r.isOccurrence(dtstart, tt.t); got != tt.want {
+			t.Errorf("%s: isOccurrence(%s) = %v, want %v", tt.name, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestParseRRule(t *testing.T) {
+	berlin := mustLoc(t, "Europe/Berlin")
+	tests := []struct {
+		in      string
+		want    string // canonical form
+		wantErr bool
+	}{
+		{in: "RRULE:freq=weekly;byday=tu,mo,tu", want: "FREQ=WEEKLY;BYDAY=MO,TU"},
+		{in: "FREQ=DAILY;INTERVAL=1", want: "FREQ=DAILY"},
+		{in: "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=12", want: "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=12"},
+		{in: "FREQ=DAILY;UNTIL=20250103", want: "FREQ=DAILY;UNTIL=20250103T225959Z"},
+		{in: "", wantErr: true},
+		{in: "FREQ=YEARLY", wantErr: true},
+		{in: "BYDAY=MO", wantErr: true},
+		{in: "FREQ=DAILY;BYDAY=MO", wantErr: true},
+		{in: "FREQ=WEEKLY;BYMONTHDAY=1", wantErr: true},
+		{in: "FREQ=WEEKLY;BYDAY=1MO", wantErr: true},
+		{in: "FREQ=MONTHLY;BYMONTHDAY=-2", wantErr: true},
+		{in: "FREQ=MONTHLY;BYMONTHDAY=32", wantErr: true},
+		{in: "FREQ=DAILY;COUNT=0", wantErr: true},
+		{in: "FREQ=DAILY;INTERVAL=366", wantErr: true},
+		{in: "FREQ=DAILY;COUNT=3;UNTIL=20250103", wantErr: true},
+		{in: "FREQ=DAILY;UNTIL=2025-01-03", wantErr: true},
+		{in: "FREQ=DAILY;BYHOUR=9", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := parseRRule(tt.in, berlin)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseRRule(%q) = %s, want error", tt.in, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRRule(%q): %v", tt.in, err)
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Errorf("parseRRule(%q).String() = %q, want %q", tt.in, got, tt.want)
+		}
+		// 存進 DB 的標準形式要能原樣讀回
+		if again, err := parseRRule(r.String(), berlin); err != nil || again.String() != tt.want {
+			t.Errorf("round trip of %q: %q, %v", tt.want, again.String(), err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+// Task time handling. Every task carries an IANA time zone (the requester's
+// local zone) and either a single scheduled_at or a window
+// [window_start, window_end] ("any time Saturday morning"). For windows,
+// scheduled_at mirrors window_start so ordering and older clients keep
+// working; punctuality checks use window_end (see reliability.go).
+// Times may be sent as RFC3339 or as local wall-clock time in the task's zone.
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	schedulePastGrace = 5 * time.Minute // 時鐘誤差 / 送出延遲
+	maxTaskWindow     = 14 * 24 * time.Hour
+	defaultTimezone   = "UTC"
+)
+
+type taskSchedule struct {
+	At          *time.Time
+	WindowStart *time.Time
+	WindowEnd   *time.Time
+	Timezone    string
+}
+
+func loadTimezone(name string) (*time.Location, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, false
+	}
+	loc, err := time.LoadLocation(name)
+	return loc, err == nil
+}
+
+// schedule resolves the time fields of in. defaultTZ applies when no zone is
+// given; times equal to prev (an unchanged edit) skip the past check.
+func (in *createTaskInput) schedule(defaultTZ string, prev taskSchedule) (taskSchedule, string) {
+	var s taskSchedule
+	s.Timezone = strings.TrimSpace(in.Timezone)
+	if s.Timezone == "" {
+		s.Timezone = defaultTZ
+	}
+	loc, ok := loadTimezone(s.Timezone)
+	if !ok {
+		return s, "timezone must be an IANA zone such as Europe/Berlin"
+	}
+	s.Timezone = loc.String()
+	now := time.Now()
+	scheduled := strings.TrimSpace(in.ScheduledAt)
+	hasWindow := strings.TrimSpace(in.WindowStart) != "" || strings.TrimSpace(in.WindowEnd) != ""
+	same := func(a, b *time.Time) bool { return a != nil && b != nil && a.Equal(*b) }
+
+	switch {
+	case in.IsImmediate:
+		if scheduled != "" || hasWindow {
+			return s, "is_immediate excludes scheduled_at and window_start/window_end"
+		}
+		s.At = &now
+	case hasWindow:
+		if scheduled != "" {
+			return s, "use either scheduled_at or window_start/window_end"
+		}
+		start, err1 := parseLocalTime(in.WindowStart, loc)
+		end, err2 := parseLocalTime(in.WindowEnd, loc)
+		if err1 != nil || err2 != nil {
+			return s, "window_start and window_end are both required (RFC3339 or local YYYY-MM-DDTHH:MM)"
+		}
+		if !end.After(start) {
+			return s, "window_end must be after window_start"
+		}
+		if end.Sub(start) > maxTaskWindow {
+			return s, "window must be at most 14 days"
+		}
+		if end.Before(now.Add(-schedulePastGrace)) && !(same(&start, prev.WindowStart) && same(&end, prev.WindowEnd)) {
+			return s, "window is in the past"
+		}
+		s.At, s.WindowStart, s.WindowEnd = &start, &start, &end
+	case scheduled != "":
+		at, err := parseLocalTime(scheduled, loc)
+		if err != nil {
+			return s, "scheduled_at must be RFC3339 or local YYYY-MM-DDTHH:MM"
+		}
+		if at.Before(now.Add(-schedulePastGrace)) && !same(&at, prev.At) {
+			return s, "scheduled_at is in the past"
+		}
+		s.At = &at
+	}
+	return s, ""
+}
+
+// profileTimezone: the user's saved zone, or defaultTimezone.
+func profileTimezone(ctx context.Context, q dbtx, uid string) string {
+	var tz string
+	_ = q.QueryRow(ctx, `select timezone from public.profiles where user_id=$1`, uid).Scan(&tz)
+	if _, ok := loadTimezone(tz); !ok {
+		return defaultTimezone
+	}
+	return tz
+}
+
+// localDateRange parses ?date_from=&date_to= (YYYY-MM-DD, inclusive) in the
+// zone ?tz= (default: the caller's profile zone). Either bound may be nil.
+func localDateRange(c *gin.Context) (from, to *time.Time, ok bool) {
+	df, dt := strings.TrimSpace(c.Query("date_from")), strings.TrimSpace(c.Query("date_to"))
+	if df == "" && dt == "" {
+		return nil, nil, true
+	}
+	tz := c.Query("tz")
+	if tz == "" {
+		tz = profileTimezone(c.Request.Context(), db, c.GetString("uid"))
+	}
+	loc, valid := loadTimezone(tz)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tz must be an IANA zone"})
+		return nil, nil, false
+	}
+	if df != "" {
+		t, err := time.ParseInLocation("2006-01-02", df, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date_from must be YYYY-MM-DD"})
+			return nil, nil, false
+		}
+		from = &t
+	}
+	if dt != "" {
+		t, err := time.ParseInLocation("2006-01-02", dt, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date_to must be YYYY-MM-DD"})
+			return nil, nil, false
+		}
+		t = t.AddDate(0, 0, 1) // 含當天：到隔天 00:00（本地）
+		to = &t
+	}
+	return from, to, true
+}
+
+// taskDateFilterSQL: tasks whose time (instant or window) overlaps
+// [$from, $to); unscheduled tasks are excluded once a bound is given.
+// from/to are the placeholder numbers of the two bounds.
+func taskDateFilterSQL(from, to string) string {
+	return `
+      and ($` + from + `::timestamptz is null or coalesce(window_end, scheduled_at) >= $` + from + `::timestamptz)
+      and ($` + to + `::timestamptz is null or coalesce(window_start, scheduled_at) < $` + to + `::timestamptz)`
+}
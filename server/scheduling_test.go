@@ -0,0 +1,189 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreateTaskInputSchedule(t *testing.T) {
+	berlin := mustLoc(t, "Europe/Berlin")
+	now := time.Now().Truncate(time.Second)
+	rfc := func(d time.Duration) string { return now.Add(d).Format(time.RFC3339) }
+	ptr := func(d time.Duration) *time.Time { v := now.Add(d); return &v }
+	at := func(y int, m time.Month, d, hh, mm int) *time.Time {
+		v := time.Date(y, m, d, hh, mm, 0, 0, berlin)
+		return &v
+	}
+
+	tests := []struct {
+		name      string
+		in        createTaskInput
+		defaultTZ string
+		prev      taskSchedule
+		wantErr   string
+		wantTZ    string
+		wantAt    *time.Time
+		wantStart *time.Time
+		wantEnd   *time.Time
+	}{
+		{
+			name:   "local time in the given zone",
+			in:     createTaskInput{ScheduledAt: "2099-07-01T15:00", Timezone: "Europe/Berlin"},
+			wantTZ: "Europe/Berlin", wantAt: at(2099, 7, 1, 15, 0),
+		},
+		{
+			name:      "zone defaults to the profile zone",
+			in:        createTaskInput{ScheduledAt: "2099-07-01T15:00"},
+			defaultTZ: "Europe/Berlin",
+			wantTZ:    "Europe/Berlin", wantAt: at(2099, 7, 1, 15, 0),
+		},
+		{
+			name:      "unknown zone",
+			in:        createTaskInput{ScheduledAt: "2099-07-01T15:00", Timezone: "Mars/Olympus"},
+			defaultTZ: "UTC",
+			wantErr:   "timezone must be an IANA zone such as Europe/Berlin",
+		},
+		{
+			name:      "unscheduled",
+			in:        createTaskInput{},
+			defaultTZ: "UTC",
+			wantTZ:    "UTC",
+		},
+		{
+			name:      "malformed time",
+			in:        createTaskInput{ScheduledAt: "tomorrow 3pm"},
+			defaultTZ: "UTC",
+			wantErr:   "scheduled_at must be RFC3339 or local YYYY-MM-DDTHH:MM",
+		},
+		{
+			name:      "past time",
+			in:        createTaskInput{ScheduledAt: rfc(-time.Hour)},
+			defaultTZ: "UTC",
+			wantErr:   "scheduled_at is in the past",
+		},
+		{
+			name:      "just past, within the grace",
+			in:        createTaskInput{ScheduledAt: rfc(-time.Minute)},
+			defaultTZ: "UTC",
+			wantTZ:    "UTC", wantAt: ptr(-time.Minute),
+		},
+		{
+			// 沒改時間的編輯不因「已過去」被擋
+			name:      "unchanged past time on edit",
+			in:        createTaskInput{ScheduledAt: rfc(-time.Hour)},
+			defaultTZ: "UTC",
+			prev:      taskSchedule{At: ptr(-time.Hour)},
+			wantTZ:    "UTC", wantAt: ptr(-time.Hour),
+		},
+		{
+			name:      "moved to another past time on edit",
+			in:        createTaskInput{ScheduledAt: rfc(-2 * time.Hour)},
+			defaultTZ: "UTC",
+			prev:      taskSchedule{At: ptr(-time.Hour)},
+			wantErr:   "scheduled_at is in the past",
+		},
+		{
+			name:      "immediate with a time",
+			in:        createTaskInput{IsImmediate: true, ScheduledAt: rfc(time.Hour)},
+			defaultTZ: "UTC",
+			wantErr:   "is_immediate excludes scheduled_at and window_start/window_end",
+		},
+		{
+			name:   "window mirrors its start into scheduled_at",
+			in:     createTaskInput{WindowStart: "2099-07-04T08:00", WindowEnd: "2099-07-04T12:00", Timezone: "Europe/Berlin"},
+			wantTZ: "Europe/Berlin", wantAt: at(2099, 7, 4, 8, 0), wantStart: at(2099, 7, 4, 8, 0), wantEnd: at(2099, 7, 4, 12, 0),
+		},
+		{
+			name:      "window and scheduled_at",
+			in:        createTaskInput{ScheduledAt: rfc(time.Hour), WindowStart: rfc(time.Hour), WindowEnd: rfc(2 * time.Hour)},
+			defaultTZ: "UTC",
+			wantErr:   "use either scheduled_at or window_start/window_end",
+		},
+		{
+			name:      "window without an end",
+			in:        createTaskInput{WindowStart: rfc(time.Hour)},
+			defaultTZ: "UTC",
+			wantErr:   "window_start and window_end are both required (RFC3339 or local YYYY-MM-DDTHH:MM)",
+		},
+		{
+			name:      "window ending before it starts",
+			in:        createTaskInput{WindowStart: rfc(2 * time.Hour), WindowEnd: rfc(time.Hour)},
+			defaultTZ: "UTC",
+			wantErr:   "window_end must be after window_start",
+		},
+		{
+			name:      "window of exactly 14 days",
+			in:        createTaskInput{WindowStart: rfc(time.Hour), WindowEnd: rfc(time.Hour + maxTaskWindow)},
+			defaultTZ: "UTC",
+			wantTZ:    "UTC", wantAt: ptr(time.Hour), wantStart: ptr(time.Hour), wantEnd: ptr(time.Hour + maxTaskWindow),
+		},
+		{
+			name:      "window longer than 14 days",
+			in:        createTaskInput{WindowStart: rfc(time.Hour), WindowEnd: rfc(time.Hour + maxTaskWindow + time.Minute)},
+			defaultTZ: "UTC",
+			wantErr:   "window must be at most 14 days",
+		},
+		{
+			name:      "window already over",
+			in:        createTaskInput{WindowStart: rfc(-3 * time.Hour), WindowEnd: rfc(-time.Hour)},
+			defaultTZ: "UTC",
+			wantErr:   "window is in the past",
+		},
+		{
+			name:      "window still open counts as upcoming",
+			in:        createTaskInput{WindowStart: rfc(-time.Hour), WindowEnd: rfc(time.Hour)},
+			defaultTZ: "UTC",
+			wantTZ:    "UTC", wantAt: ptr(-time.Hour), wantStart: ptr(-time.Hour), wantEnd: ptr(time.Hour),
+		},
+		{
+			name:      "unchanged past window on edit",
+			in:        createTaskInput{WindowStart: rfc(-3 * time.Hour), WindowEnd: rfc(-time.Hour)},
+			defaultTZ: "UTC",
+			prev:      taskSchedule{WindowStart: ptr(-3 * time.Hour), WindowEnd: ptr(-time.Hour)},
+			wantTZ:    "UTC", wantAt: ptr(-3 * time.Hour), wantStart: ptr(-3 * time.Hour), wantEnd: ptr(-time.Hour),
+		},
+		{
+			name:      "past window with a new end on edit",
+			in:        createTaskInput{WindowStart: rfc(-3 * time.Hour), WindowEnd: rfc(-2 * time.Hour)},
+			defaultTZ: "UTC",
+			prev:      taskSchedule{WindowStart: ptr(-3 * time.Hour), WindowEnd: ptr(-time.Hour)},
+			wantErr:   "window is in the past",
+		},
+	}
+	sameTime := func(a, b *time.Time) bool { return (a == nil) == (b == nil) && (a == nil || a.Equal(*b)) }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, msg := tt.in.schedule(tt.defaultTZ, tt.prev)
+			if msg != tt.wantErr {
+				t.Fatalf("error = %q, want %q", msg, tt.wantErr)
+			}
+			if msg != "" {
+				return
+			}
+			if got.Timezone != tt.wantTZ {
+				t.Errorf("timezone = %q, want %q", got.Timezone, tt.wantTZ)
+			}
+			if !sameTime(got.At, tt.wantAt) {
+				t.Errorf("at = %v, want %v", got.At, tt.wantAt)
+			}
+			if !sameTime(got.WindowStart, tt.wantStart) || !sameTime(got.WindowEnd, tt.wantEnd) {
+				t.Errorf("window = %v–%v, want %v–%v", got.WindowStart, got.WindowEnd, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestCreateTaskInputScheduleImmediate(t *testing.T) {
+	before := time.Now()
+	in := createTaskInput{IsImmediate: true}
+	got, msg := in.schedule("UTC", taskSchedule{})
+	if msg != "" {
+		t.Fatal(msg)
+	}
+	if got.At == nil || got.At.Before(before) || got.At.After(time.Now()) {
+		t.Errorf("immediate task at %v, want now", got.At)
+	}
+	if got.WindowStart != nil || got.WindowEnd != nil {
+		t.Errorf("immediate task has a window")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+// Outgoing SMS (phone verification codes).
+// Optional ENV:
+//   SMS_PROVIDER=fake           (only "fake" for now: logs the message)
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+)
+
+var sms SMSProvider
+
+type SMSProvider interface {
+	Name() string
+	Send(ctx context.Context, to, body string) error
+}
+
+func initSMSProvider() {
+	switch p := strings.TrimSpace(os.Getenv("SMS_PROVIDER")); p {
+	case "", "fake":
+		sms = fakeSMSProvider{}
+	default:
+		log.Fatalf("unknown SMS_PROVIDER %q", p)
+	}
+	log.Printf("[sms] provider: %s", sms.Name())
+}
+
+// -------- Fake provider (local testing) --------
+// Writes the message to the log instead of sending it.
+
+type fakeSMSProvider struct{}
+
+func (fakeSMSProvider) Name() string { return "fake" }
+
+func (fakeSMSProvider) Send(ctx context.Context, to, body string) error {
+	log.Printf("[sms:fake] → %s: %s", to, body)
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Requester spending: actual cost of completed tasks (labor + tips + approved
+// expenses) and the estimated cost of tasks still open. Budgets are monthly
+// (UTC calendar month) and stored on the profile.
+
+type spendingBucket struct {
+	Period         string `json:"period"`
+	Category       string `json:"category"`
+	SpentCents     int    `json:"spent_cents"`
+	CommittedCents int    `json:"committed_cents"`
+	Tasks          int    `json:"tasks"`
+}
+
+// estimatedCostCents: what a task is expected to cost when posted.
+func estimatedCostCents(minutes int) int {
+	return minutes * centsPerMinute
+}
+
+// taskCostSQL computes per task: actual cost if completed, estimate otherwise.
+// Args: $1 requester, $2 from, $3 to (exclusive), $4 default cents per minute.
+const taskCostSQL = `
+    select t.id, t.category, t.status, coalesce(t.completed_at, t.created_at) as at,
+           case when t.status = 'completed' then
+             coalesce((select sum(greatest(ceil(extract(epoch from (w.end_at - w.start_at))/60.0), 1))::int
+                       from public.worklogs w
+                       where w.task_id = t.id and w.end_at is not null and w.end_at > w.start_at), 0)
+             * coalesce(t.rate_cents_per_minute, $4)
+             + t.tip_cents
+             + coalesce((select sum(e.amount_cents)::int from public.task_expenses e
+                         where e.task_id = t.id and e.status = 'approved'), 0)
+           else t.estimated_minutes * coalesce(t.rate_cents_per_minute, $4) end as cents
+    from public.tasks t
+    where t.requester = $1 and t.status <> 'cancelled'
+      and coalesce(t.completed_at, t.created_at) >= $2 and coalesce(t.completed_at, t.created_at) < $3
+  `
+
+// monthToDateCents: spent + committed for the requester in the current UTC month.
+func monthToDateCents(ctx context.Context, q dbtx, me string) (int, error) {
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var cents int
+	err := q.QueryRow(ctx, `select coalesce(sum(cents)::int, 0) from (`+taskCostSQL+`) x`,
+		me, start, start.AddDate(0, 1, 0), centsPerMinute).Scan(&cents)
+	return cents, err
+}
+
+// lockBudget serializes budget checks of one requester by locking their
+// profile row; take it in the transaction that inserts or grows the tasks,
+// before checkBudget, so two concurrent requests cannot both fit.
+func lockBudget(ctx context.Context, tx pgx.Tx, me string) error {
+	_, err := tx.Exec(ctx, `select 1 from public.profiles where user_id=$1 for update`, me)
+	return err
+}
+
+// checkBudget returns a warning when adding estimateCents would exceed the
+// requester's monthly budget; reject is true when the budget is a hard limit.
+// Pass the transaction when tasks were already inserted in it, so they count.
+func checkBudget(ctx context.Context, q dbtx, me string, estimateCents int) (warning string, reject bool, err error) {
+	if estimateCents <= 0 {
+		return "", false, nil
+	}
+	var budget *int
+	var hard bool
+	err = q.QueryRow(ctx, `select monthly_budget_cents, budget_hard_limit from public.profiles where user_id=$1`, me).Scan(&budget, &hard)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && budget == nil) {
+		return "", false, nil // 沒有 profile 或沒設預算：不檢查
+	}
+	if err != nil {
+		return "", false, err
+	}
+	used, err := monthToDateCents(ctx, q, me)
+	if err != nil {
+		return "", false, err
+	}
+	if used+estimateCents <= *budget {
+		return "", false, nil
+	}
+	warning = fmt.Sprintf("monthly budget exceeded: %s of %s would be used",
+		formatCents(used+estimateCents), formatCents(*budget))
+	return warning, hard, nil
+}
+
+// GET /me/spending?from=&to=&tz=&period=day|week|month
+func getMySpending(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	from, to, loc, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	period := c.DefaultQuery("period", "month")
+	if period != "day" && period != "week" && period != "month" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be day, week or month"})
+		return
+	}
+
+	rows, err := db.Query(ctx, taskCostSQL, me, from, to, centsPerMinute)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+
+	buckets := map[string]*spendingBucket{}
+	byCategory := map[string]int{}
+	var spent, committed int
+	for rows.Next() {
+		var id, category, status string
+		var at time.Time
+		var cents int
+		if err := rows.Scan(&id, &category, &status, &at, &cents); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		d, w, m := periodKeys(at.In(loc))
+		key := map[string]string{"day": d, "week": w, "month": m}[period]
+		b := buckets[key+"|"+category]
+		if b == nil {
+			b = &spendingBucket{Period: key, Category: category}
+			buckets[key+"|"+category] = b
+		}
+		b.Tasks++
+		if status == "completed" {
+			b.SpentCents += cents
+			spent += cents
+		} else {
+			b.CommittedCents += cents
+			committed += cents
+		}
+		byCategory[category] += cents
+	}
+
+	items := make([]spendingBucket, 0, len(buckets))
+	for _, b := range buckets {
+		items = append(items, *b)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Period != items[j].Period {
+			return items[i].Period < items[j].Period
+		}
+		return items[i].Category < items[j].Category
+	})
+
+	// 本月預算狀態
+	budget := gin.H{"monthly_budget_cents": nil, "hard_limit": false}
+	var limit *int
+	var hard bool
+	if err := db.QueryRow(ctx, `select monthly_budget_cents, budget_hard_limit from public.profiles where user_id=$1`, me).Scan(&limit, &hard); err == nil && limit != nil {
+		used, _ := monthToDateCents(ctx, db, me)
+		budget = gin.H{
+			"monthly_budget_cents": *limit,
+			"hard_limit":           hard,
+			"used_cents":           used,
+			"remaining_cents":      *limit - used,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":   from.In(loc),
+		"to":     to.In(loc),
+		"tz":     loc.String(),
+		"period": period,
+		"totals": gin.H{
+			"spent_cents":     spent,
+			"committed_cents": committed,
+			"by_category":     byCategory,
+		},
+		"items":  items,
+		"budget": budget,
+	})
+}
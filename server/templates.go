@@ -0,0 +1,376 @@
+package main
+
+// Task templates and duplication for requesters who post the same errand
+// again and again. A template stores every createTaskInput field except the
+// time (given when the task is created), with locations kept as a list
+// instead of the " | "-joined location_text the app sends today.
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxTemplatesPerUser = 50
+	maxTemplateNameLen  = 100
+	maxTemplateStops    = 10
+	locationSeparator   = " | " // 前端用它串接多個地點
+)
+
+// taskLocation is one stop of an errand.
+type taskLocation struct {
+	Address string `json:"address"`
+	Note    string `json:"note,omitempty"` // e.g. "ring twice", "2nd floor"
+}
+
+type TaskTemplate struct {
+	ID                string         `json:"id"`
+	Owner             string         `json:"owner"`
+	Name              string         `json:"name"`
+	Title             string         `json:"title"`
+	Description       string         `json:"description"`
+	Category          string         `json:"category"`
+	Locations         []taskLocation `json:"locations"`
+	EstimatedMinutes  int            `json:"estimated_minutes"`
+	PrepayAmountCents int            `json:"prepay_amount_cents"`
+	IsImmediate       bool           `json:"is_immediate"`
+	DirectTo          []string       `json:"direct_to"`
+	DirectToFavorites bool           `json:"direct_to_favorites"`
+	ExclusiveMinutes  int            `json:"exclusive_minutes"`
+	Checklist         []string       `json:"checklist"`
+	RequireChecklist  bool           `json:"require_checklist"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+}
+
+const templateColumns = `id,owner,name,title,description,category,locations::text,estimated_minutes,
+           prepay_amount_cents,is_immediate,direct_to,direct_to_favorites,exclusive_minutes,
+           checklist,require_checklist,created_at,updated_at`
+
+func scanTemplate(rows interface{ Scan(dest ...any) error }) (TaskTemplate, error) {
+	var t TaskTemplate
+	var locations string
+	err := rows.Scan(
+		&t.ID, &t.Owner, &t.Name, &t.Title, &t.Description, &t.Category, &locations, &t.EstimatedMinutes,
+		&t.PrepayAmountCents, &t.IsImmediate, &t.DirectTo, &t.DirectToFavorites, &t.ExclusiveMinutes,
+		&t.Checklist, &t.RequireChecklist, &t.CreatedAt, &t.UpdatedAt,
+	)
+	if err != nil {
+		return t, err
+	}
+	_ = json.Unmarshal([]byte(locations), &t.Locations)
+	if t.Locations == nil {
+		t.Locations = []taskLocation{}
+	}
+	if t.DirectTo == nil {
+		t.DirectTo = []string{}
+	}
+	if t.Checklist == nil {
+		t.Checklist = []string{}
+	}
+	return t, nil
+}
+
+// locationText joins the stops the way the app displays them.
+func locationText(locs []taskLocation) string {
+	var parts []string
+	for _, l := range locs {
+		s := l.Address
+		if l.Note != "" {
+			s += " (" + l.Note + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, locationSeparator)
+}
+
+// taskInput turns the template into a createTaskInput (time left empty).
+func (t TaskTemplate) taskInput() createTaskInput {
+	require := t.RequireChecklist
+	return createTaskInput{
+		Title: t.Title, Description: t.Description, Category: t.Category,
+		LocationText: locationText(t.Locations), EstimatedMinutes: t.EstimatedMinutes,
+		PrepayAmountCents: t.PrepayAmountCents, IsImmediate: t.IsImmediate,
+		DirectTo: t.DirectTo, DirectToFavorites: t.DirectToFavorites, ExclusiveMinutes: t.ExclusiveMinutes,
+		Checklist: t.Checklist, RequireChecklist: &require,
+	}
+}
+
+type templateInput struct {
+	Name              string         `json:"name"`
+	Title             string         `json:"title"`
+	Description       string         `json:"description"`
+	Category          string         `json:"category"`
+	Locations         []taskLocation `json:"locations"`
+	EstimatedMinutes  int            `json:"estimated_minutes"`
+	PrepayAmountCents int            `json:"prepay_amount_cents"`
+	IsImmediate       bool           `json:"is_immediate"`
+	DirectTo          []string       `json:"direct_to"`
+	DirectToFavorites bool           `json:"direct_to_favorites"`
+	ExclusiveMinutes  int            `json:"exclusive_minutes"`
+	Checklist         []string       `json:"checklist"`
+	RequireChecklist  bool           `json:"require_checklist"`
+}
+
+// validate normalises the input in place; returns an error message or "".
+func (in *templateInput) validate() string {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" || len([]rune(in.Name)) > maxTemplateNameLen {
+		return "name required (max 100 characters)"
+	}
+	base := createTaskInput{
+		Title: in.Title, Description: in.Description, Category: in.Category,
+		EstimatedMinutes: in.EstimatedMinutes, PrepayAmountCents: in.PrepayAmountCents,
+	}
+	if msg := base.validate(); msg != "" {
+		return msg
+	}
+	in.Title, in.Description, in.Category = base.Title, base.Description, base.Category
+	in.EstimatedMinutes, in.PrepayAmountCents = base.EstimatedMinutes, base.PrepayAmountCents
+
+	locs := []taskLocation{}
+	for _, l := range in.Locations {
+		l.Address, l.Note = strings.TrimSpace(l.Address), strings.TrimSpace(l.Note)
+		if l.Address == "" {
+			continue
+		}
+		if strings.Contains(l.Address, locationSeparator) || strings.Contains(l.Note, locationSeparator) {
+			return `locations must not contain " | "`
+		}
+		locs = append(locs, l)
+	}
+	if len(locs) > maxTemplateStops {
+		return "at most 10 locations"
+	}
+	in.Locations = locs
+
+	if len(in.DirectTo) > maxDirectTargets {
+		return "direct_to must list up to 10 users"
+	}
+	if in.DirectTo == nil {
+		in.DirectTo = []string{}
+	}
+	in.ExclusiveMinutes = min(max(in.ExclusiveMinutes, 0), maxExclusiveMinutes)
+	checklist, err := normalizeChecklist(in.Checklist)
+	if err != nil {
+		return err.Error()
+	}
+	in.Checklist = checklist
+	return ""
+}
+
+// taskTimeInput is the body for creating a task from a template or a
+// duplicate: only the time is new (see scheduling.go for the formats).
+type taskTimeInput struct {
+	IsImmediate *bool  `json:"is_immediate"` // nil = keep the source's setting
+	ScheduledAt string `json:"scheduled_at"`
+	WindowStart string `json:"window_start"`
+	WindowEnd   string `json:"window_end"`
+	Timezone    string `json:"timezone"` // "" = the source task's zone / the profile zone
+}
+
+func bindTaskTime(c *gin.Context) (taskTimeInput, bool) {
+	var in taskTimeInput
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+			return in, false
+		}
+	}
+	return in, true
+}
+
+func (tt taskTimeInput) apply(in *createTaskInput) {
+	if tt.IsImmediate != nil {
+		in.IsImmediate = *tt.IsImmediate
+	}
+	in.ScheduledAt, in.WindowStart, in.WindowEnd = tt.ScheduledAt, tt.WindowStart, tt.WindowEnd
+	if tt.Timezone != "" {
+		in.Timezone = tt.Timezone
+	}
+	if tt.hasTime() {
+		in.IsImmediate = false
+	}
+}
+
+func (tt taskTimeInput) hasTime() bool {
+	return strings.TrimSpace(tt.ScheduledAt) != "" || strings.TrimSpace(tt.WindowStart) != "" || strings.TrimSpace(tt.WindowEnd) != ""
+}
+
+// -------- Template handlers --------
+
+// GET /task-templates
+func listTemplates(c *gin.Context) {
+	rows, err := db.Query(c.Request.Context(), `
+    select `+templateColumns+` from public.task_templates
+    where owner=$1 order by name asc
+  `, c.GetString("uid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []TaskTemplate{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, t)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// POST /task-templates
+func createTemplate(c *gin.Context) {
+	var in templateInput
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	locs, _ := json.Marshal(in.Locations)
+	t, err := scanTemplate(db.QueryRow(c.Request.Context(), `
+    insert into public.task_templates
+      (owner,name,title,description,category,locations,estimated_minutes,prepay_amount_cents,is_immediate,
+       direct_to,direct_to_favorites,exclusive_minutes,checklist,require_checklist)
+    select $1,$2,$3,$4,$5,$6::jsonb,$7,$8,$9,$10,$11,$12,$13,$14
+    where (select count(*) from public.task_templates where owner=$1) < $15
+    returning `+templateColumns,
+		c.GetString("uid"), in.Name, in.Title, in.Description, in.Category, string(locs), in.EstimatedMinutes,
+		in.PrepayAmountCents, in.IsImmediate, in.DirectTo, in.DirectToFavorites, in.ExclusiveMinutes,
+		in.Checklist, in.RequireChecklist, maxTemplatesPerUser))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusConflict, gin.H{"error": "template limit reached"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, t)
+}
+
+// GET /task-templates/:id
+func getTemplate(c *gin.Context) {
+	t, err := scanTemplate(db.QueryRow(c.Request.Context(), `
+    select `+templateColumns+` from public.task_templates where id::text=$1 and owner=$2
+  `, c.Param("id"), c.GetString("uid")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// PUT /task-templates/:id — full replace.
+func updateTemplate(c *gin.Context) {
+	var in templateInput
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	locs, _ := json.Marshal(in.Locations)
+	t, err := scanTemplate(db.QueryRow(c.Request.Context(), `
+    update public.task_templates
+    set name=$3, title=$4, description=$5, category=$6, locations=$7::jsonb, estimated_minutes=$8,
+        prepay_amount_cents=$9, is_immediate=$10, direct_to=$11, direct_to_favorites=$12,
+        exclusive_minutes=$13, checklist=$14, require_checklist=$15, updated_at=now()
+    where id::text=$1 and owner=$2
+    returning `+templateColumns,
+		c.Param("id"), c.GetString("uid"), in.Name, in.Title, in.Description, in.Category, string(locs),
+		in.EstimatedMinutes, in.PrepayAmountCents, in.IsImmediate, in.DirectTo, in.DirectToFavorites,
+		in.ExclusiveMinutes, in.Checklist, in.RequireChecklist))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// DELETE /task-templates/:id
+func deleteTemplate(c *gin.Context) {
+	tag, err := db.Exec(c.Request.Context(), `
+    delete from public.task_templates where id::text=$1 and owner=$2
+  `, c.Param("id"), c.GetString("uid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /task-templates/:id/tasks {scheduled_at? | window_start+window_end?, is_immediate?, timezone?} — posts a
+// task from the template (same checks as createTask).
+func createTaskFromTemplate(c *gin.Context) {
+	t, err := scanTemplate(db.QueryRow(c.Request.Context(), `
+    select `+templateColumns+` from public.task_templates where id::text=$1 and owner=$2
+  `, c.Param("id"), c.GetString("uid")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	tt, ok := bindTaskTime(c)
+	if !ok {
+		return
+	}
+	in := t.taskInput()
+	tt.apply(&in)
+	createTaskFrom(c, in)
+}
+
+// POST /tasks/:id/duplicate {scheduled_at? | window_start+window_end?, is_immediate?, timezone?} — requester only;
+// copies an open or closed task (with its checklist, unchecked) into a new
+// open task. Direct offers and agreed rates are not copied.
+func duplicateTask(c *gin.Context) {
+	ctx := c.Request.Context()
+	src, err := scanTask(db.QueryRow(ctx, `select `+taskColumns+` from public.tasks where id=$1`, c.Param("id")))
+	if err != nil || src.Requester != c.GetString("uid") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	tt, ok := bindTaskTime(c)
+	if !ok {
+		return
+	}
+	cl, err := loadChecklist(ctx, db, src.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	var items []string
+	for _, it := range cl.Items {
+		items = append(items, it.Text)
+	}
+	require := src.RequireChecklist
+	in := createTaskInput{
+		Title: src.Title, Description: src.Description, Category: src.Category,
+		LocationText: src.LocationText, EstimatedMinutes: src.EstimatedMinutes,
+		PrepayAmountCents: src.PrepayAmountCents, IsImmediate: src.IsImmediate,
+		Timezone: src.Timezone, Checklist: items, RequireChecklist: &require,
+	}
+	tt.apply(&in)
+	if !in.IsImmediate && !tt.hasTime() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scheduled_at or window_start/window_end required (or is_immediate)"})
+		return
+	}
+	createTaskFrom(c, in)
+}
@@ -0,0 +1,496 @@
+package main
+
+// Identity verification tiers. Levels are cumulative:
+//   1 (verifyEmail) email verified — auth.users.email_confirmed_at or a
+//                   session started from an emailed link/code (synced on
+//                   profile reads and before accepting work; backfilled from
+//                   auth.users by the migration). user_metadata is writable
+//                   by the user and never counts.
+//   2 (verifyPhone) + phone verified with an SMS code (see sms.go)
+//   3 (verifyID)    + ID document approved by an admin
+// profiles.verification_level is a generated column, so editing the email
+// or phone drops the level until the new value is verified again.
+// Each task category declares the level an assignee needs (categories.go).
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	verifyNone  = 0
+	verifyEmail = 1
+	verifyPhone = 2
+	verifyID    = 3
+)
+
+const (
+	phoneCodeTTL         = 10 * time.Minute
+	phoneCodeResendAfter = time.Minute
+	phoneCodeMaxAttempts = 5
+	maxIDDocumentBytes   = 10 << 20 // 10 MB
+)
+
+var idDocumentTypes = map[string]bool{
+	"passport": true, "id_card": true, "driving_licence": true, "residence_permit": true,
+}
+
+var phoneRe = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// normalizePhone strips spaces, dashes and parentheses; E.164 only.
+func normalizePhone(s string) (string, bool) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')', '.':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+	return s, phoneRe.MatchString(s)
+}
+
+// emailVerifiedClaim reports whether the token proves the caller owns its
+// email: the session was started from an emailed link/code (the app's login
+// flow). user_metadata is not consulted — users can set it themselves.
+func emailVerifiedClaim(claims jwt.MapClaims) bool {
+	if email, _ := claims["email"].(string); email == "" {
+		return false
+	}
+	amr, _ := claims["amr"].([]any)
+	for _, a := range amr {
+		m, _ := a.(map[string]any)
+		switch m["method"] {
+		case "magiclink", "email/signup":
+			return true
+		case "otp":
+			// 手機 OTP 登入也是 "otp"；只有沒有 phone claim 時才算 email
+			if phone, _ := claims["phone"].(string); phone == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// syncEmailVerification records a verified email on the profile: proven by
+// the token's login method or confirmed in auth.users.
+func syncEmailVerification(c *gin.Context) {
+	if impersonating(c) {
+		return // claims belong to the admin, not the target
+	}
+	fromToken := false
+	if v, ok := c.Get("claims"); ok {
+		fromToken = emailVerifiedClaim(v.(jwt.MapClaims))
+	}
+	if _, err := db.Exec(c.Request.Context(), `
+    update public.profiles p set verified_email=p.email, email_verified_at=now()
+    where p.user_id=$1 and p.email=$2 and p.email <> '' and p.verified_email <> p.email
+      and ($3 or exists (
+        select 1 from auth.users u
+        where u.id::text = p.user_id and u.email_confirmed_at is not null and lower(u.email) = lower(p.email)
+      ))
+  `, c.GetString("uid"), c.GetString("email"), fromToken); err != nil {
+		log.Printf("[verify] sync email: %v", err)
+	}
+}
+
+func verificationLevel(ctx context.Context, q dbtx, uid string) (int, error) {
+	var level int
+	err := q.QueryRow(ctx, `select verification_level from public.profiles where user_id=$1`, uid).Scan(&level)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return verifyNone, nil
+	}
+	return level, err
+}
+
+func hashPhoneCode(uid, phone, code string) string {
+	sum := sha256.Sum256([]byte(uid + "|" + phone + "|" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+func newPhoneCode() string {
+	n, _ := rand.Int(rand.Reader, big.NewInt(1000000))
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+type IDDocument struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	DocType    string     `json:"doc_type"`
+	Status     string     `json:"status"` // pending | approved | rejected
+	Reason     string     `json:"reason,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+const idDocumentColumns = `id,user_id,doc_type,status,reason,reviewed_at,created_at`
+
+func scanIDDocument(rows interface{ Scan(dest ...any) error }) (IDDocument, error) {
+	var d IDDocument
+	err := rows.Scan(&d.ID, &d.UserID, &d.DocType, &d.Status, &d.Reason, &d.ReviewedAt, &d.CreatedAt)
+	return d, err
+}
+
+// -------- Verification handlers --------
+
+// GET /me/verification
+func getMyVerification(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+	syncEmailVerification(c)
+
+	var level int
+	var email, phone string
+	var emailAt, phoneAt, idAt *time.Time
+	err := db.QueryRow(ctx, `
+    select verification_level,
+           case when verified_email <> '' and verified_email = email then verified_email else '' end,
+           case when verified_phone <> '' and verified_phone = phone then verified_phone else '' end,
+           email_verified_at, phone_verified_at, id_verified_at
+    from public.profiles where user_id=$1
+  `, me).Scan(&level, &email, &phone, &emailAt, &phoneAt, &idAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	var doc *IDDocument
+	d, err := scanIDDocument(db.QueryRow(ctx, `
+    select `+idDocumentColumns+` from public.id_documents
+    where user_id=$1 order by created_at desc limit 1
+  `, me))
+	if err == nil {
+		doc = &d
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"level":          level,
+		"email_verified": email != "",
+		"phone_verified": phone != "",
+		"phone":          phone,
+		"id_verified":    idAt != nil,
+		"id_document":    doc,
+	})
+}
+
+// POST /me/verification/phone {"phone": "+3161234567"} — sends a code.
+func startPhoneVerification(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var in struct {
+		Phone string `json:"phone"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	phone, ok := normalizePhone(in.Phone)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "phone must be in international format, e.g. +31612345678"})
+		return
+	}
+
+	var taken bool
+	_ = db.QueryRow(ctx, `
+    select exists (select 1 from public.profiles where verified_phone=$1 and user_id<>$2 and phone_verified_at is not null)
+  `, phone, me).Scan(&taken)
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "phone already verified by another account"})
+		return
+	}
+
+	code := newPhoneCode()
+	// 上一封還在冷卻期就不更新（where 條件不成立 → 沒有回傳列）
+	var sentAt time.Time
+	err := db.QueryRow(ctx, `
+    insert into public.phone_verifications(user_id,phone,code_hash,attempts,sent_at,expires_at)
+    values ($1,$2,$3,0,now(),$4)
+    on conflict (user_id) do update
+    set phone=excluded.phone, code_hash=excluded.code_hash, attempts=0,
+        sent_at=excluded.sent_at, expires_at=excluded.expires_at
+    where public.phone_verifications.sent_at < $5
+    returning sent_at
+  `, me, phone, hashPhoneCode(me, phone, code), time.Now().Add(phoneCodeTTL), time.Now().Add(-phoneCodeResendAfter)).Scan(&sentAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "code recently sent, try again later"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if err := sms.Send(ctx, phone, "Your Hora verification code is "+code); err != nil {
+		log.Printf("[verify] sms to %s: %v", phone, err)
+		_, _ = db.Exec(ctx, `delete from public.phone_verifications where user_id=$1`, me)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "could not send sms"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"phone": phone, "expires_in": int(phoneCodeTTL.Seconds())})
+}
+
+// POST /me/verification/phone/confirm {"code": "123456"}
+// On success the number also becomes the profile phone.
+func confirmPhoneVerification(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var in struct {
+		Code string `json:"code"`
+	}
+	if err := c.BindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var phone, codeHash string
+	var attempts int
+	var expiresAt time.Time
+	err = tx.QueryRow(ctx, `
+    select phone, code_hash, attempts, expires_at
+    from public.phone_verifications where user_id=$1 for update
+  `, me).Scan(&phone, &codeHash, &attempts, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no pending verification"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if time.Now().After(expiresAt) || attempts >= phoneCodeMaxAttempts {
+		_, _ = tx.Exec(ctx, `delete from public.phone_verifications where user_id=$1`, me)
+		_ = tx.Commit(ctx)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code expired, request a new one"})
+		return
+	}
+	got := hashPhoneCode(me, phone, strings.TrimSpace(in.Code))
+	if subtle.ConstantTimeCompare([]byte(got), []byte(codeHash)) != 1 {
+		_, _ = tx.Exec(ctx, `update public.phone_verifications set attempts=attempts+1 where user_id=$1`, me)
+		_ = tx.Commit(ctx)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code", "attempts_left": phoneCodeMaxAttempts - attempts - 1})
+		return
+	}
+
+	email := c.GetString("email")
+	if _, err := tx.Exec(ctx, `
+    insert into public.profiles(user_id,email,name,phone,city,avatar_url,bio,verified_phone,phone_verified_at,created_at,updated_at)
+    values ($1,$2,$3,$4,'','','',$4,now(),now(),now())
+    on conflict (user_id) do update
+    set phone=$4, verified_phone=$4, phone_verified_at=now(), updated_at=now()
+  `, me, email, deriveName(email), phone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if _, err := tx.Exec(ctx, `delete from public.phone_verifications where user_id=$1`, me); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	getMyVerification(c)
+}
+
+// POST /me/verification/id (multipart: doc_type, document) — queued for admin review.
+func submitIDDocument(c *gin.Context) {
+	me := c.GetString("uid")
+	ctx := c.Request.Context()
+	// 先限制 body 大小，PostForm 會解析整個 multipart
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxIDDocumentBytes+1<<20)
+
+	docType := strings.TrimSpace(c.PostForm("doc_type"))
+	if !idDocumentTypes[docType] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "doc_type must be passport, id_card, driving_licence or residence_permit"})
+		return
+	}
+	fh, err := c.FormFile("document")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "document file required"})
+		return
+	}
+	if fh.Size > maxIDDocumentBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "document too large"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid document"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxIDDocumentBytes+1))
+	f.Close()
+	if err != nil || len(data) > maxIDDocumentBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid document"})
+		return
+	}
+	ctype := http.DetectContentType(data)
+	ext, ok := receiptTypes[ctype] // 與收據相同：jpeg / png / pdf
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "document must be jpeg, png or pdf"})
+		return
+	}
+
+	key := newBlobKey("id-documents/"+me, ext)
+	if err := blobs.Put(ctx, key, bytes.NewReader(data), ctype); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
+		return
+	}
+	d, err := scanIDDocument(db.QueryRow(ctx, `
+    insert into public.id_documents(user_id,doc_type,file_key,content_type)
+    values ($1,$2,$3,$4)
+    on conflict (user_id) where status = 'pending' do nothing
+    returning `+idDocumentColumns, me, docType, key, ctype))
+	if err != nil {
+		_ = blobs.Delete(ctx, key)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a document is already pending review"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, d)
+}
+
+// -------- ID document review (admin) --------
+
+// GET /admin/id-documents?status=pending&limit=&offset=
+func adminListIDDocuments(c *gin.Context) {
+	status := c.DefaultQuery("status", "pending")
+	limit, offset := pageParams(c)
+	rows, err := db.Query(c.Request.Context(), `
+    select `+idDocumentColumns+` from public.id_documents
+    where status=$1 order by created_at asc limit $2 offset $3
+  `, status, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+	out := []IDDocument{}
+	for rows.Next() {
+		d, err := scanIDDocument(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan error"})
+			return
+		}
+		out = append(out, d)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GET /admin/id-documents/:id/file — every view is audited.
+func adminGetIDDocumentFile(c *gin.Context) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	var userID, key, ctype string
+	if err := db.QueryRow(ctx, `
+    select user_id, file_key, content_type from public.id_documents where id::text=$1
+  `, id).Scan(&userID, &key, &ctype); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	rc, err := blobs.Get(ctx, key)
+	if errors.Is(err, errBlobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
+		return
+	}
+	defer rc.Close()
+	auditAdmin(ctx, c.GetString("uid"), "view_id_document", userID, map[string]any{"document": id})
+
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, -1, ctype, rc, nil)
+}
+
+func adminApproveIDDocument(c *gin.Context) { reviewIDDocument(c, "approved") }
+func adminRejectIDDocument(c *gin.Context)  { reviewIDDocument(c, "rejected") }
+
+// reviewIDDocument decides a pending document; rejections need {"reason"}.
+func reviewIDDocument(c *gin.Context, decision string) {
+	id := c.Param("id")
+	actor := c.GetString("uid")
+	ctx := c.Request.Context()
+
+	var in struct {
+		Reason string `json:"reason"`
+	}
+	_ = c.ShouldBindJSON(&in)
+	in.Reason = strings.TrimSpace(in.Reason)
+	if decision == "rejected" && in.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason required"})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	d, err := scanIDDocument(tx.QueryRow(ctx, `
+    update public.id_documents
+    set status=$2, reason=$3, reviewed_by=$4, reviewed_at=now()
+    where id::text=$1 and status='pending'
+    returning `+idDocumentColumns, id, decision, in.Reason, actor))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "document not pending"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if decision == "approved" {
+		if _, err := tx.Exec(ctx, `
+      update public.profiles set id_verified_at=now(), updated_at=now() where user_id=$1
+    `, d.UserID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	auditAdmin(ctx, actor, "id_document_"+decision, d.UserID, map[string]any{
+		"document": d.ID, "doc_type": d.DocType, "reason": in.Reason,
+	})
+	c.JSON(http.StatusOK, d)
+}
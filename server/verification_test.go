@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestEmailVerifiedClaim(t *testing.T) {
+	amr := func(methods ...string) []any {
+		out := []any{}
+		for _, m := range methods {
+			out = append(out, map[string]any{"method": m, "timestamp": float64(1700000000)})
+		}
+		return out
+	}
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   bool
+	}{
+		{"magic link", jwt.MapClaims{"email": "a@example.com", "amr": amr("magiclink")}, true},
+		{"email otp", jwt.MapClaims{"email": "a@example.com", "amr": amr("otp")}, true},
+		{"signup confirmation", jwt.MapClaims{"email": "a@example.com", "amr": amr("email/signup")}, true},
+		{"phone otp", jwt.MapClaims{"email": "a@example.com", "phone": "+491701234567", "amr": amr("otp")}, false},
+		{"password", jwt.MapClaims{"email": "a@example.com", "amr": amr("password")}, false},
+		{"self-set user_metadata", jwt.MapClaims{
+			"email":         "a@example.com",
+			"amr":           amr("password"),
+			"user_metadata": map[string]any{"email_verified": true},
+		}, false},
+		{"no email", jwt.MapClaims{"amr": amr("magiclink")}, false},
+	}
+	for _, tt := range tests {
+		if got := emailVerifiedClaim(tt.claims); got != tt.want {
+			t.Errorf("%s: emailVerifiedClaim = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+// Access-token verification for authMiddleware.
+// Configuration is read once at startup (not per request):
+//   JWT_ALLOWED_ALGS=RS256,ES256        algorithms accepted (others rejected before key lookup);
+//                                       HS256 is added to the default when SUPABASE_JWT_SECRET is set
+//   JWT_AUDIENCE=authenticated          required "aud"
+//   JWT_ALLOWED_ROLES=authenticated     accepted Supabase "role" claims
+//   JWT_CLOCK_SKEW=30s                  leeway for exp / nbf / iat
+//   SUPABASE_JWT_SECRET                 key for HS* tokens (legacy projects)
+// Asymmetric algorithms (RS*, PS*, ES*) use the cached JWKS.
+// Failures are counted by reason in the expvar map "auth_verify_failures".
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var verifier *tokenVerifier
+
+var (
+	authVerifyOK       = expvar.NewInt("auth_verify_ok")
+	authVerifyFailures = expvar.NewMap("auth_verify_failures")
+)
+
+type tokenVerifier struct {
+	issuer       string
+	audience     string
+	allowedAlgs  []string
+	allowedRoles map[string]bool
+	leeway       time.Duration
+	hmacSecret   []byte
+	jwks         jwt.Keyfunc // asymmetric keys; nil = none configured
+}
+
+// verifyError carries a short, metric-friendly reason.
+type verifyError struct {
+	reason string
+	err    error
+}
+
+func (e *verifyError) Error() string {
+	if e.err != nil {
+		return e.reason + ": " + e.err.Error()
+	}
+	return e.reason
+}
+
+func (e *verifyError) Unwrap() error { return e.err }
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func envOr(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// devMode: HORA_DEV_MODE=true unlocks fakes and fallbacks meant for local
+// development only (fake OTP/payout backends, random signing keys).
+func devMode() bool {
+	return strings.TrimSpace(os.Getenv("HORA_DEV_MODE")) == "true"
+}
+
+func newTokenVerifierFromEnv(issuer string, jwks jwt.Keyfunc) (*tokenVerifier, error) {
+	leeway, err := time.ParseDuration(envOr("JWT_CLOCK_SKEW", "30s"))
+	if err != nil || leeway < 0 {
+		return nil, fmt.Errorf("invalid JWT_CLOCK_SKEW")
+	}
+	secret := strings.TrimSpace(os.Getenv("SUPABASE_JWT_SECRET"))
+	defAlgs := "RS256,ES256"
+	if secret != "" {
+		defAlgs += ",HS256"
+	}
+	v := &tokenVerifier{
+		issuer:       issuer,
+		audience:     envOr("JWT_AUDIENCE", "authenticated"),
+		allowedAlgs:  splitList(envOr("JWT_ALLOWED_ALGS", defAlgs)),
+		allowedRoles: map[string]bool{},
+		leeway:       leeway,
+		hmacSecret:   []byte(secret),
+		jwks:         jwks,
+	}
+	for _, r := range splitList(envOr("JWT_ALLOWED_ROLES", "authenticated")) {
+		v.allowedRoles[r] = true
+	}
+	for _, alg := range v.allowedAlgs {
+		switch {
+		case strings.HasPrefix(alg, "HS"):
+			if len(v.hmacSecret) == 0 {
+				return nil, fmt.Errorf("%s allowed but SUPABASE_JWT_SECRET is not set", alg)
+			}
+		case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"), strings.HasPrefix(alg, "ES"):
+			if v.jwks == nil {
+				return nil, fmt.Errorf("%s allowed but no JWKS configured", alg)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported algorithm %q in JWT_ALLOWED_ALGS", alg)
+		}
+	}
+	return v, nil
+}
+
+func (v *tokenVerifier) algAllowed(alg string) bool {
+	for _, a := range v.allowedAlgs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *tokenVerifier) keyfunc(t *jwt.Token) (interface{}, error) {
+	// alg 已由 parser 的 WithValidMethods 過濾；這裡只依家族選 key
+	alg := t.Method.Alg()
+	if strings.HasPrefix(alg, "HS") {
+		return v.hmacSecret, nil
+	}
+	return v.jwks(t)
+}
+
+// Verify parses and validates tokenStr and returns its claims.
+func (v *tokenVerifier) Verify(tokenStr string) (jwt.MapClaims, error) {
+	claims, err := v.verify(tokenStr)
+	if err != nil {
+		var ve *verifyError
+		if errors.As(err, &ve) {
+			authVerifyFailures.Add(ve.reason, 1)
+		}
+		return nil, err
+	}
+	authVerifyOK.Add(1)
+	return claims, nil
+}
+
+func (v *tokenVerifier) verify(tokenStr string) (jwt.MapClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(v.allowedAlgs),
+		jwt.WithLeeway(v.leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.audience),
+	)
+	claims := jwt.MapClaims{}
+	token, err := parser.ParseWithClaims(tokenStr, claims, v.keyfunc)
+	if err != nil {
+		reason := failureReason(err)
+		if reason == "signature" && token != nil && token.Method != nil && !v.algAllowed(token.Method.Alg()) {
+			reason = "algorithm"
+		}
+		return nil, &verifyError{reason: reason, err: err}
+	}
+
+	if role, _ := claims["role"].(string); !v.allowedRoles[role] {
+		return nil, &verifyError{reason: "role", err: fmt.Errorf("role %q not allowed", role)}
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, &verifyError{reason: "missing_sub"}
+	}
+	return claims, nil
+}
+
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return "malformed"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		if errors.Is(err, keyfunc.ErrKIDNotFound) || errors.Is(err, keyfunc.ErrKID) {
+			return "unknown_key"
+		}
+		return "signature"
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		if errors.Is(err, keyfunc.ErrKIDNotFound) || errors.Is(err, keyfunc.ErrKID) {
+			return "unknown_key"
+		}
+		return "unverifiable"
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return "not_yet_valid"
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return "issuer"
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return "audience"
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return "missing_claim"
+	default:
+		return "invalid"
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer     = "https://example.supabase.co/auth/v1"
+	testHMACSecret = "test-hmac-secret-0123456789abcdef"
+)
+
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+// serveTestJWKS publishes the public halves of keys (kid "rsa-1", "ec-1")
+// the way Supabase does.
+func serveTestJWKS(t *testing.T) (testKeys, *keyfunc.JWKS) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	pad := func(n *big.Int) []byte { return n.FillBytes(make([]byte, 32)) }
+	set := map[string]any{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa-1", "alg": "RS256", "use": "sig",
+			"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec-1", "alg": "ES256", "use": "sig", "crv": "P-256",
+			"x": b64(pad(ecKey.X)), "y": b64(pad(ecKey.Y)),
+		},
+	}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(srv.Close)
+
+	jwks, err := keyfunc.Get(srv.URL, keyfunc.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(jwks.EndBackground)
+	return testKeys{rsa: rsaKey, ec: ecKey}, jwks
+}
+
+func testClaims(mutate func(jwt.MapClaims)) jwt.MapClaims {
+	now := time.Now()
+	c := jwt.MapClaims{
+		"iss":  testIssuer,
+		"aud":  "authenticated",
+		"sub":  "5f1c1f5e-0000-4000-8000-000000000001",
+		"role": "authenticated",
+		"iat":  now.Add(-time.Minute).Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+	}
+	if mutate != nil {
+		mutate(c)
+	}
+	return c
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func failureCount(reason string) int64 {
+	if v, ok := authVerifyFailures.Get(reason).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestTokenVerifier(t *testing.T) {
+	t.Setenv("SUPABASE_JWT_SECRET", testHMACSecret)
+	t.Setenv("JWT_ALLOWED_ALGS", "RS256,ES256,HS256")
+	t.Setenv("JWT_AUDIENCE", "")
+	t.Setenv("JWT_ALLOWED_ROLES", "")
+	t.Setenv("JWT_CLOCK_SKEW", "30s")
+
+	keys, jwks := serveTestJWKS(t)
+	v, err := newTokenVerifierFromEnv(testIssuer, jwks.Keyfunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacKey := []byte(testHMACSecret)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		token  func() string
+		reason string // "" = accepted
+	}{
+		{"rs256", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(nil))
+		}, ""},
+		{"es256", func() string {
+			return signToken(t, jwt.SigningMethodES256, "ec-1", keys.ec, testClaims(nil))
+		}, ""},
+		{"hs256", func() string {
+			return signToken(t, jwt.SigningMethodHS256, "", hmacKey, testClaims(nil))
+		}, ""},
+		{"alg not allowed", func() string {
+			return signToken(t, jwt.SigningMethodRS384, "rsa-1", keys.rsa, testClaims(nil))
+		}, "algorithm"},
+		{"alg none", func() string {
+			return signToken(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, testClaims(nil))
+		}, "algorithm"},
+		{"wrong key for kid", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", otherRSA, testClaims(nil))
+		}, "signature"},
+		{"wrong hmac secret", func() string {
+			return signToken(t, jwt.SigningMethodHS256, "", []byte("not-the-secret"), testClaims(nil))
+		}, "signature"},
+		{"unknown kid", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rotated-away", keys.rsa, testClaims(nil))
+		}, "unknown_key"},
+		{"wrong audience", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) { c["aud"] = "service" }))
+		}, "audience"},
+		{"wrong issuer", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example/auth/v1" }))
+		}, "issuer"},
+		{"disallowed role", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) { c["role"] = "service_role" }))
+		}, "role"},
+		{"missing sub", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) { delete(c, "sub") }))
+		}, "missing_sub"},
+		{"missing exp", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) { delete(c, "exp") }))
+		}, "missing_claim"},
+		{"expired", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) {
+				c["iat"] = now.Add(-2 * time.Hour).Unix()
+				c["exp"] = now.Add(-time.Hour).Unix()
+			}))
+		}, "expired"},
+		{"expired within leeway", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) { c["exp"] = now.Add(-10 * time.Second).Unix() }))
+		}, ""},
+		{"expired just outside leeway", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) { c["exp"] = now.Add(-45 * time.Second).Unix() }))
+		}, "expired"},
+		{"nbf within leeway", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) { c["nbf"] = now.Add(10 * time.Second).Unix() }))
+		}, ""},
+		{"nbf outside leeway", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) { c["nbf"] = now.Add(5 * time.Minute).Unix() }))
+		}, "not_yet_valid"},
+		{"issued in the future", func() string {
+			return signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims(func(c jwt.MapClaims) { c["iat"] = now.Add(5 * time.Minute).Unix() }))
+		}, "not_yet_valid"},
+		{"malformed", func() string { return "not.a.jwt" }, "malformed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			okBefore := authVerifyOK.Value()
+			failBefore := failureCount(tt.reason)
+
+			claims, err := v.Verify(tt.token())
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if claims["sub"] == "" {
+					t.Fatal("no sub in claims")
+				}
+				if got := authVerifyOK.Value(); got != okBefore+1 {
+					t.Errorf("auth_verify_ok = %d, want %d", got, okBefore+1)
+				}
+				return
+			}
+			var ve *verifyError
+			if !errors.As(err, &ve) {
+				t.Fatalf("Verify error = %v, want verifyError %q", err, tt.reason)
+			}
+			if ve.reason != tt.reason {
+				t.Fatalf("reason = %q (%v), want %q", ve.reason, err, tt.reason)
+			}
+			if got := failureCount(tt.reason); got != failBefore+1 {
+				t.Errorf("auth_verify_failures[%s] = %d, want %d", tt.reason, got, failBefore+1)
+			}
+			if got := authVerifyOK.Value(); got != okBefore {
+				t.Errorf("auth_verify_ok changed on failure: %d → %d", okBefore, got)
+			}
+		})
+	}
+}
+
+func TestTokenVerifierConfig(t *testing.T) {
+	_, jwks := serveTestJWKS(t)
+	tests := []struct {
+		name    string
+		env     map[string]string
+		jwks    bool
+		wantErr bool
+	}{
+		{"defaults without secret", map[string]string{}, true, false},
+		{"hs256 without secret", map[string]string{"JWT_ALLOWED_ALGS": "HS256"}, true, true},
+		{"rs256 without jwks", map[string]string{"JWT_ALLOWED_ALGS": "RS256"}, false, true},
+		{"none is not an algorithm", map[string]string{"JWT_ALLOWED_ALGS": "RS256,none"}, true, true},
+		{"negative skew", map[string]string{"JWT_CLOCK_SKEW": "-1s"}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"SUPABASE_JWT_SECRET", "JWT_ALLOWED_ALGS", "JWT_CLOCK_SKEW", "JWT_AUDIENCE", "JWT_ALLOWED_ROLES"} {
+				t.Setenv(k, tt.env[k])
+			}
+			var kf jwt.Keyfunc
+			if tt.jwks {
+				kf = jwks.Keyfunc
+			}
+			_, err := newTokenVerifierFromEnv(testIssuer, kf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}